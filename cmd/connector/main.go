@@ -1,8 +1,11 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/damianiandrea/mongodb-nats-connector/internal/config"
 	"github.com/damianiandrea/mongodb-nats-connector/pkg/connector"
@@ -10,7 +13,20 @@ import (
 
 const defaultConfigFileName = "connector.yaml"
 
+var (
+	startAtOperationTime = flag.String("start-at-operation-time", "",
+		"start watching at the given RFC3339 date or extended json timestamp, overrides the configuration of every collection")
+	resumeAfter = flag.String("resume-after", "",
+		"resume watching after the given token, requires a single configured collection")
+	startAfter = flag.String("start-after", "",
+		"start watching after the given token, requires a single configured collection")
+	ignoreStoredToken = flag.Bool("ignore-stored-token", false,
+		"ignore the stored resume tokens of every collection")
+)
+
 func main() {
+	flag.Parse()
+
 	configFileName := getEnvOrDefault("CONFIG_FILE", defaultConfigFileName)
 	cfg, err := config.Load(configFileName)
 	if err != nil {
@@ -23,11 +39,40 @@ func main() {
 		connector.WithNatsUrl(getEnvOrDefault("NATS_URL", cfg.Connector.Nats.Url)),
 		connector.WithServerAddr(getEnvOrDefault("SERVER_ADDR", cfg.Connector.Server.Addr)),
 	}
+	opts = append(opts, mongoOptions(&cfg.Connector.Mongo)...)
+	opts = append(opts, natsOptions(&cfg.Connector.Nats)...)
+	if cfg.Connector.FailFast != nil && *cfg.Connector.FailFast {
+		opts = append(opts, connector.WithFailFast())
+	}
+	if election := cfg.Connector.LeaderElection; election != nil {
+		var leaseTtl time.Duration
+		if election.LeaseTtlMs != nil {
+			leaseTtl = time.Duration(*election.LeaseTtlMs) * time.Millisecond
+		}
+		opts = append(opts, connector.WithLeaderElection(election.Bucket, leaseTtl),
+			connector.WithInstanceId(getEnvOrDefault("INSTANCE_ID", election.InstanceId)))
+		if election.Distribute != nil && *election.Distribute {
+			opts = append(opts, connector.WithDistribution())
+		}
+	}
+	if (*resumeAfter != "" || *startAfter != "") && len(cfg.Connector.Collections) != 1 {
+		log.Fatalf("-resume-after and -start-after require a single configured collection")
+	}
 	for _, coll := range cfg.Connector.Collections {
+		overrideStartPosition(coll)
 		collOpts := []connector.CollectionOption{
 			connector.WithTokensDbName(coll.TokensDbName),
 			connector.WithTokensCollName(coll.TokensCollName),
 			connector.WithStreamName(coll.StreamName),
+			connector.WithPipeline(coll.Pipeline),
+			connector.WithStartAtOperationTime(coll.StartAtOperationTime),
+			connector.WithResumeAfter(coll.ResumeAfter),
+			connector.WithStartAfter(coll.StartAfter),
+			connector.WithFullDocument(coll.FullDocument),
+			connector.WithFullDocumentBeforeChange(coll.FullDocumentBeforeChange),
+			connector.WithOnHistoryLost(coll.OnHistoryLost),
+			connector.WithFormat(coll.Format),
+			connector.WithEnvelope(coll.Envelope),
 		}
 		if coll.ChangeStreamPreAndPostImages != nil && *coll.ChangeStreamPreAndPostImages {
 			collOpts = append(collOpts, connector.WithChangeStreamPreAndPostImages())
@@ -35,8 +80,108 @@ func main() {
 		if coll.TokensCollCapped != nil && coll.TokensCollSizeInBytes != nil && *coll.TokensCollCapped {
 			collOpts = append(collOpts, connector.WithTokensCollCapped(*coll.TokensCollSizeInBytes))
 		}
-		opt := connector.WithCollection(coll.DbName, coll.CollName, collOpts...)
-		opts = append(opts, opt)
+		switch coll.TokensRetention {
+		case "", "all":
+		case "latest":
+			collOpts = append(collOpts, connector.WithTokensRetentionLatest())
+		case "count":
+			if coll.TokensRetentionCount == nil {
+				log.Fatalf("tokensRetention count requires tokensRetentionCount")
+			}
+			collOpts = append(collOpts, connector.WithTokensRetentionCount(*coll.TokensRetentionCount))
+		case "ttl":
+			if coll.TokensRetentionTtlSeconds == nil {
+				log.Fatalf("tokensRetention ttl requires tokensRetentionTtlSeconds")
+			}
+			ttl := time.Duration(*coll.TokensRetentionTtlSeconds) * time.Second
+			collOpts = append(collOpts, connector.WithTokensRetentionTtl(ttl))
+		default:
+			log.Fatalf("invalid tokensRetention %v: must be one of all, latest, count or ttl", coll.TokensRetention)
+		}
+		switch coll.CheckpointStore {
+		case "", "mongo":
+		case "nats":
+			collOpts = append(collOpts, connector.WithNatsCheckpointStore(coll.CheckpointBucket))
+		case "file":
+			collOpts = append(collOpts, connector.WithFileCheckpointStore(coll.CheckpointDir))
+		case "stream":
+			collOpts = append(collOpts, connector.WithStreamCheckpointStore())
+		default:
+			log.Fatalf("invalid checkpointStore %v: must be one of mongo, nats, file or stream", coll.CheckpointStore)
+		}
+		if coll.Snapshot != nil && *coll.Snapshot {
+			collOpts = append(collOpts, connector.WithSnapshot())
+		}
+		if coll.CheckpointEvents != nil {
+			collOpts = append(collOpts, connector.WithCheckpointEvents(*coll.CheckpointEvents))
+		}
+		if coll.CheckpointIntervalMs != nil {
+			interval := time.Duration(*coll.CheckpointIntervalMs) * time.Millisecond
+			collOpts = append(collOpts, connector.WithCheckpointInterval(interval))
+		}
+		if coll.RetryInitialDelayMs != nil {
+			delay := time.Duration(*coll.RetryInitialDelayMs) * time.Millisecond
+			collOpts = append(collOpts, connector.WithRetryInitialDelay(delay))
+		}
+		if coll.RetryMaxDelayMs != nil {
+			delay := time.Duration(*coll.RetryMaxDelayMs) * time.Millisecond
+			collOpts = append(collOpts, connector.WithRetryMaxDelay(delay))
+		}
+		if coll.RetryMaxAttempts != nil {
+			collOpts = append(collOpts, connector.WithRetryMaxAttempts(*coll.RetryMaxAttempts))
+		}
+		if coll.RestartDelayMs != nil {
+			delay := time.Duration(*coll.RestartDelayMs) * time.Millisecond
+			collOpts = append(collOpts, connector.WithRestartDelay(delay))
+		}
+		if coll.PublishWindow != nil {
+			collOpts = append(collOpts, connector.WithPublishWindow(*coll.PublishWindow))
+		}
+		if coll.MaxRestarts != nil {
+			collOpts = append(collOpts, connector.WithMaxRestarts(*coll.MaxRestarts))
+		}
+		if coll.ShowExpandedEvents != nil && *coll.ShowExpandedEvents {
+			collOpts = append(collOpts, connector.WithShowExpandedEvents())
+		}
+		if coll.FollowRenames != nil && *coll.FollowRenames {
+			collOpts = append(collOpts, connector.WithFollowRenames())
+		}
+		for _, rule := range coll.Redact {
+			switch rule.Action {
+			case "drop":
+				collOpts = append(collOpts, connector.WithDroppedField(rule.Path))
+			case "replace":
+				collOpts = append(collOpts, connector.WithReplacedField(rule.Path, rule.Value))
+			case "hash":
+				collOpts = append(collOpts, connector.WithHashedField(rule.Path, rule.Salt))
+			case "keepLast":
+				collOpts = append(collOpts, connector.WithMaskedField(rule.Path, rule.KeepLast))
+			default:
+				log.Fatalf("invalid redaction action %v: must be one of drop, replace, hash or keepLast", rule.Action)
+			}
+		}
+		if coll.Stream != nil {
+			collOpts = append(collOpts, streamOptions(coll.Stream)...)
+		}
+		if coll.IgnoreStoredToken != nil && *coll.IgnoreStoredToken {
+			collOpts = append(collOpts, connector.WithIgnoreStoredToken())
+		}
+		if len(coll.IncludeColls) > 0 {
+			collOpts = append(collOpts, connector.WithIncludedColls(coll.IncludeColls...))
+		}
+		if len(coll.ExcludeColls) > 0 {
+			collOpts = append(collOpts, connector.WithExcludedColls(coll.ExcludeColls...))
+		}
+		switch coll.Scope {
+		case "", "collection":
+			opts = append(opts, connector.WithCollection(coll.DbName, coll.CollName, collOpts...))
+		case "database":
+			opts = append(opts, connector.WithDatabase(coll.DbName, collOpts...))
+		case "deployment":
+			opts = append(opts, connector.WithDeployment(collOpts...))
+		default:
+			log.Fatalf("invalid scope %v: must be one of collection, database or deployment", coll.Scope)
+		}
 	}
 
 	if conn, err := connector.New(opts...); err != nil {
@@ -46,6 +191,130 @@ func main() {
 	}
 }
 
+// overrideStartPosition overrides the start position of the given collection with the one set by command line flags.
+func overrideStartPosition(coll *config.Collection) {
+	if *startAtOperationTime != "" || *resumeAfter != "" || *startAfter != "" {
+		coll.StartAtOperationTime = *startAtOperationTime
+		coll.ResumeAfter = *resumeAfter
+		coll.StartAfter = *startAfter
+	}
+	if *ignoreStoredToken {
+		coll.IgnoreStoredToken = ignoreStoredToken
+	}
+}
+
+// streamOptions returns the options of the NATS stream of a collection, set in the given configuration.
+func streamOptions(stream *config.Stream) []connector.CollectionOption {
+	opts := []connector.CollectionOption{
+		connector.WithStreamDescription(stream.Description),
+		connector.WithStreamStorage(stream.Storage),
+		connector.WithStreamRetention(stream.Retention),
+		connector.WithStreamDiscard(stream.Discard),
+		connector.WithStreamReconcile(stream.Reconcile),
+	}
+	if stream.Replicas != nil {
+		opts = append(opts, connector.WithStreamReplicas(*stream.Replicas))
+	}
+	if stream.MaxAgeMs != nil {
+		opts = append(opts, connector.WithStreamMaxAge(time.Duration(*stream.MaxAgeMs)*time.Millisecond))
+	}
+	if stream.MaxBytes != nil {
+		opts = append(opts, connector.WithStreamMaxBytes(*stream.MaxBytes))
+	}
+	if stream.MaxMsgs != nil {
+		opts = append(opts, connector.WithStreamMaxMsgs(*stream.MaxMsgs))
+	}
+	if stream.MaxMsgsPerSubject != nil {
+		opts = append(opts, connector.WithStreamMaxMsgsPerSubject(*stream.MaxMsgsPerSubject))
+	}
+	if stream.MaxMsgSize != nil {
+		opts = append(opts, connector.WithStreamMaxMsgSize(*stream.MaxMsgSize))
+	}
+	if stream.DuplicateWindowMs != nil {
+		window := time.Duration(*stream.DuplicateWindowMs) * time.Millisecond
+		opts = append(opts, connector.WithStreamDuplicateWindow(window))
+	}
+	if stream.Placement != nil {
+		opts = append(opts, connector.WithStreamPlacement(stream.Placement.Cluster, stream.Placement.Tags...))
+	}
+	if stream.DenyDelete != nil && *stream.DenyDelete {
+		opts = append(opts, connector.WithStreamDenyDelete())
+	}
+	if stream.DenyPurge != nil && *stream.DenyPurge {
+		opts = append(opts, connector.WithStreamDenyPurge())
+	}
+	return opts
+}
+
+// mongoOptions returns the options of the MongoDB connection, set in the given configuration or in the environment.
+func mongoOptions(cfg *config.Mongo) []connector.Option {
+	tls := cfg.TLS
+	if tls == nil {
+		tls = &config.TLS{}
+	}
+	auth := cfg.Auth
+	if auth == nil {
+		auth = &config.MongoAuth{}
+	}
+	password := readSecret(getEnvOrDefault("MONGO_PASSWORD", auth.Password),
+		getEnvOrDefault("MONGO_PASSWORD_FILE", auth.PasswordFile))
+	opts := []connector.Option{
+		connector.WithMongoAppName(cfg.AppName),
+		connector.WithMongoTLS(getEnvOrDefault("MONGO_TLS_CERT_FILE", tls.CertFile),
+			getEnvOrDefault("MONGO_TLS_KEY_FILE", tls.KeyFile), getEnvOrDefault("MONGO_TLS_CA_FILE", tls.CaFile)),
+		connector.WithMongoAuth(auth.Mechanism, auth.Source, getEnvOrDefault("MONGO_USERNAME", auth.Username), password),
+		connector.WithMongoReadConcern(cfg.ReadConcern),
+		connector.WithMongoCompressors(cfg.Compressors...),
+	}
+	var minPoolSize, maxPoolSize uint64
+	if cfg.MinPoolSize != nil {
+		minPoolSize = *cfg.MinPoolSize
+	}
+	if cfg.MaxPoolSize != nil {
+		maxPoolSize = *cfg.MaxPoolSize
+	}
+	opts = append(opts, connector.WithMongoPoolSize(minPoolSize, maxPoolSize))
+	if cfg.MaxConnIdleTimeMs != nil {
+		idleTime := time.Duration(*cfg.MaxConnIdleTimeMs) * time.Millisecond
+		opts = append(opts, connector.WithMongoMaxConnIdleTime(idleTime))
+	}
+	return opts
+}
+
+// natsOptions returns the options of the NATS connection, set in the given configuration or in the environment.
+func natsOptions(cfg *config.Nats) []connector.Option {
+	tls := cfg.TLS
+	if tls == nil {
+		tls = &config.TLS{}
+	}
+	token := readSecret(getEnvOrDefault("NATS_TOKEN", cfg.Token), getEnvOrDefault("NATS_TOKEN_FILE", cfg.TokenFile))
+	password := readSecret(getEnvOrDefault("NATS_PASSWORD", cfg.Password),
+		getEnvOrDefault("NATS_PASSWORD_FILE", cfg.PasswordFile))
+	return []connector.Option{
+		connector.WithNatsCredentials(getEnvOrDefault("NATS_CREDS_FILE", cfg.CredsFile)),
+		connector.WithNatsNKeySeed(getEnvOrDefault("NATS_NKEY_SEED_FILE", cfg.NKeySeedFile)),
+		connector.WithNatsToken(token),
+		connector.WithNatsUserPassword(getEnvOrDefault("NATS_USER", cfg.User), password),
+		connector.WithNatsTLS(getEnvOrDefault("NATS_TLS_CERT_FILE", tls.CertFile),
+			getEnvOrDefault("NATS_TLS_KEY_FILE", tls.KeyFile), getEnvOrDefault("NATS_TLS_CA_FILE", tls.CaFile)),
+		connector.WithNatsJetStreamDomain(getEnvOrDefault("NATS_JS_DOMAIN", cfg.JetStreamDomain)),
+		connector.WithNatsJetStreamApiPrefix(getEnvOrDefault("NATS_JS_API_PREFIX", cfg.JetStreamApiPrefix)),
+	}
+}
+
+// readSecret returns the content of the given file, without its trailing new line, or the given value if no file is
+// set, so that the secrets can be mounted as files rather than written in the configuration.
+func readSecret(value, file string) string {
+	if file == "" {
+		return value
+	}
+	secret, err := os.ReadFile(file)
+	if err != nil {
+		log.Fatalf("could not read secret: %v", err)
+	}
+	return strings.TrimRight(string(secret), "\r\n")
+}
+
 func getEnvOrDefault(env, def string) string {
 	if val, found := os.LookupEnv(env); found {
 		return val
@@ -27,11 +27,13 @@ type Config struct {
 }
 
 type Connector struct {
-	Log         Log           `yaml:"log"`
-	Mongo       Mongo         `yaml:"mongo"`
-	Nats        Nats          `yaml:"nats"`
-	Server      Server        `yaml:"server"`
-	Collections []*Collection `yaml:"collections"`
+	Log            Log             `yaml:"log"`
+	Mongo          Mongo           `yaml:"mongo"`
+	Nats           Nats            `yaml:"nats"`
+	Server         Server          `yaml:"server"`
+	FailFast       *bool           `yaml:"failFast,omitempty"`
+	LeaderElection *LeaderElection `yaml:"leaderElection,omitempty"`
+	Collections    []*Collection   `yaml:"collections"`
 }
 
 type Log struct {
@@ -39,24 +41,126 @@ type Log struct {
 }
 
 type Mongo struct {
-	Uri string `yaml:"uri"`
+	Uri               string     `yaml:"uri"`
+	AppName           string     `yaml:"appName,omitempty"`
+	TLS               *TLS       `yaml:"tls,omitempty"`
+	Auth              *MongoAuth `yaml:"auth,omitempty"`
+	MaxPoolSize       *uint64    `yaml:"maxPoolSize,omitempty"`
+	MinPoolSize       *uint64    `yaml:"minPoolSize,omitempty"`
+	MaxConnIdleTimeMs *int64     `yaml:"maxConnIdleTimeMs,omitempty"`
+	ReadConcern       string     `yaml:"readConcern,omitempty"`
+	Compressors       []string   `yaml:"compressors,omitempty"`
+}
+
+type MongoAuth struct {
+	Mechanism    string `yaml:"mechanism,omitempty"`
+	Source       string `yaml:"source,omitempty"`
+	Username     string `yaml:"username,omitempty"`
+	Password     string `yaml:"password,omitempty"`
+	PasswordFile string `yaml:"passwordFile,omitempty"`
 }
 
 type Nats struct {
-	Url string `yaml:"url"`
+	Url                string `yaml:"url"`
+	CredsFile          string `yaml:"credsFile,omitempty"`
+	NKeySeedFile       string `yaml:"nkeySeedFile,omitempty"`
+	Token              string `yaml:"token,omitempty"`
+	TokenFile          string `yaml:"tokenFile,omitempty"`
+	User               string `yaml:"user,omitempty"`
+	Password           string `yaml:"password,omitempty"`
+	PasswordFile       string `yaml:"passwordFile,omitempty"`
+	TLS                *TLS   `yaml:"tls,omitempty"`
+	JetStreamDomain    string `yaml:"jetStreamDomain,omitempty"`
+	JetStreamApiPrefix string `yaml:"jetStreamApiPrefix,omitempty"`
+}
+
+type TLS struct {
+	CertFile string `yaml:"certFile,omitempty"`
+	KeyFile  string `yaml:"keyFile,omitempty"`
+	CaFile   string `yaml:"caFile,omitempty"`
 }
 
 type Server struct {
 	Addr string `yaml:"addr"`
 }
 
+type LeaderElection struct {
+	Bucket     string `yaml:"bucket,omitempty"`
+	LeaseTtlMs *int64 `yaml:"leaseTtlMs,omitempty"`
+	InstanceId string `yaml:"instanceId,omitempty"`
+	Distribute *bool  `yaml:"distribute,omitempty"`
+}
+
 type Collection struct {
-	DbName                       string `yaml:"dbName,omitempty"`
-	CollName                     string `yaml:"collName,omitempty"`
-	ChangeStreamPreAndPostImages *bool  `yaml:"changeStreamPreAndPostImages,omitempty"`
-	TokensDbName                 string `yaml:"tokensDbName,omitempty"`
-	TokensCollName               string `yaml:"tokensCollName,omitempty"`
-	TokensCollCapped             *bool  `yaml:"tokensCollCapped,omitempty"`
-	TokensCollSizeInBytes        *int64 `yaml:"tokensCollSizeInBytes,omitempty"`
-	StreamName                   string `yaml:"streamName,omitempty"`
+	Scope                        string       `yaml:"scope,omitempty"`
+	DbName                       string       `yaml:"dbName,omitempty"`
+	CollName                     string       `yaml:"collName,omitempty"`
+	IncludeColls                 []string     `yaml:"includeColls,omitempty"`
+	ExcludeColls                 []string     `yaml:"excludeColls,omitempty"`
+	ChangeStreamPreAndPostImages *bool        `yaml:"changeStreamPreAndPostImages,omitempty"`
+	TokensDbName                 string       `yaml:"tokensDbName,omitempty"`
+	TokensCollName               string       `yaml:"tokensCollName,omitempty"`
+	TokensCollCapped             *bool        `yaml:"tokensCollCapped,omitempty"`
+	TokensCollSizeInBytes        *int64       `yaml:"tokensCollSizeInBytes,omitempty"`
+	TokensRetention              string       `yaml:"tokensRetention,omitempty"`
+	TokensRetentionCount         *int         `yaml:"tokensRetentionCount,omitempty"`
+	TokensRetentionTtlSeconds    *int64       `yaml:"tokensRetentionTtlSeconds,omitempty"`
+	StreamName                   string       `yaml:"streamName,omitempty"`
+	Pipeline                     string       `yaml:"pipeline,omitempty"`
+	Snapshot                     *bool        `yaml:"snapshot,omitempty"`
+	StartAtOperationTime         string       `yaml:"startAtOperationTime,omitempty"`
+	ResumeAfter                  string       `yaml:"resumeAfter,omitempty"`
+	StartAfter                   string       `yaml:"startAfter,omitempty"`
+	IgnoreStoredToken            *bool        `yaml:"ignoreStoredToken,omitempty"`
+	FullDocument                 string       `yaml:"fullDocument,omitempty"`
+	FullDocumentBeforeChange     string       `yaml:"fullDocumentBeforeChange,omitempty"`
+	CheckpointEvents             *int         `yaml:"checkpointEvents,omitempty"`
+	CheckpointIntervalMs         *int64       `yaml:"checkpointIntervalMs,omitempty"`
+	OnHistoryLost                string       `yaml:"onHistoryLost,omitempty"`
+	Format                       string       `yaml:"format,omitempty"`
+	Envelope                     string       `yaml:"envelope,omitempty"`
+	PublishWindow                *int         `yaml:"publishWindow,omitempty"`
+	CheckpointStore              string       `yaml:"checkpointStore,omitempty"`
+	CheckpointBucket             string       `yaml:"checkpointBucket,omitempty"`
+	CheckpointDir                string       `yaml:"checkpointDir,omitempty"`
+	ShowExpandedEvents           *bool        `yaml:"showExpandedEvents,omitempty"`
+	FollowRenames                *bool        `yaml:"followRenames,omitempty"`
+	Redact                       []*Redaction `yaml:"redact,omitempty"`
+	RetryInitialDelayMs          *int64       `yaml:"retryInitialDelayMs,omitempty"`
+	RetryMaxDelayMs              *int64       `yaml:"retryMaxDelayMs,omitempty"`
+	RetryMaxAttempts             *int         `yaml:"retryMaxAttempts,omitempty"`
+	RestartDelayMs               *int64       `yaml:"restartDelayMs,omitempty"`
+	MaxRestarts                  *int         `yaml:"maxRestarts,omitempty"`
+	Stream                       *Stream      `yaml:"stream,omitempty"`
+}
+
+type Stream struct {
+	Description       string           `yaml:"description,omitempty"`
+	Storage           string           `yaml:"storage,omitempty"`
+	Replicas          *int             `yaml:"replicas,omitempty"`
+	Retention         string           `yaml:"retention,omitempty"`
+	MaxAgeMs          *int64           `yaml:"maxAgeMs,omitempty"`
+	MaxBytes          *int64           `yaml:"maxBytes,omitempty"`
+	MaxMsgs           *int64           `yaml:"maxMsgs,omitempty"`
+	MaxMsgsPerSubject *int64           `yaml:"maxMsgsPerSubject,omitempty"`
+	MaxMsgSize        *int32           `yaml:"maxMsgSize,omitempty"`
+	Discard           string           `yaml:"discard,omitempty"`
+	DuplicateWindowMs *int64           `yaml:"duplicateWindowMs,omitempty"`
+	Placement         *StreamPlacement `yaml:"placement,omitempty"`
+	DenyDelete        *bool            `yaml:"denyDelete,omitempty"`
+	DenyPurge         *bool            `yaml:"denyPurge,omitempty"`
+	Reconcile         string           `yaml:"reconcile,omitempty"`
+}
+
+type StreamPlacement struct {
+	Cluster string   `yaml:"cluster,omitempty"`
+	Tags    []string `yaml:"tags,omitempty"`
+}
+
+type Redaction struct {
+	Path     string `yaml:"path"`
+	Action   string `yaml:"action"`
+	Value    string `yaml:"value,omitempty"`
+	Salt     string `yaml:"salt,omitempty"`
+	KeepLast int    `yaml:"keepLast,omitempty"`
 }
@@ -15,10 +15,37 @@ connector:
     level: "debug"
   mongo:
     uri: "mongodb://127.0.0.1:27017,127.0.0.1:27018,127.0.0.1:27019/?replicaSet=mongodb-nats-connector"
+    appName: "connector"
+    tls:
+      certFile: "/etc/mongo/client.pem"
+      keyFile: "/etc/mongo/client.key"
+      caFile: "/etc/mongo/ca.pem"
+    auth:
+      mechanism: "SCRAM-SHA-256"
+      username: "connector"
+      passwordFile: "/run/secrets/mongo-password"
+    maxPoolSize: 20
+    minPoolSize: 5
+    maxConnIdleTimeMs: 60000
+    readConcern: "majority"
+    compressors: ["zstd", "snappy"]
   nats:
     url: "nats://127.0.0.1:4222"
+    user: "connector"
+    passwordFile: "/run/secrets/nats-password"
+    tls:
+      certFile: "/etc/nats/client.crt"
+      keyFile: "/etc/nats/client.key"
+      caFile: "/etc/nats/ca.crt"
+    jetStreamDomain: "hub"
   server:
     addr: ":8080"
+  failFast: true
+  leaderElection:
+    bucket: "leases"
+    leaseTtlMs: 10000
+    instanceId: "connector-0"
+    distribute: true
   collections:
     - dbName: "test-connector"
       collName: "coll1"
@@ -28,13 +55,64 @@ connector:
       tokensCollCapped: true
       tokensCollSizeInBytes: 4096
       streamName: "COLL1"
+      pipeline: '[{"$match": {"operationType": "insert"}}]'
+      snapshot: true
+      fullDocument: "required"
+      fullDocumentBeforeChange: "off"
+      checkpointEvents: 100
+      checkpointIntervalMs: 5000
+      onHistoryLost: "resnapshot"
+      format: "canonicalExtJSON"
+      envelope: "cloudEventsBinary"
+      publishWindow: 64
+      checkpointStore: "nats"
+      checkpointBucket: "checkpoints"
+      showExpandedEvents: true
+      followRenames: true
+      redact:
+        - path: "**.email"
+          action: "hash"
+          salt: "pepper"
+        - path: "phone"
+          action: "keepLast"
+          keepLast: 4
+      retryInitialDelayMs: 1000
+      retryMaxDelayMs: 60000
+      retryMaxAttempts: 10
+      restartDelayMs: 2000
+      maxRestarts: 3
+      stream:
+        description: "coll1 change events"
+        storage: "file"
+        replicas: 3
+        retention: "limits"
+        maxAgeMs: 86400000
+        maxBytes: 1073741824
+        maxMsgs: 1000000
+        maxMsgsPerSubject: 500000
+        maxMsgSize: 1048576
+        discard: "old"
+        duplicateWindowMs: 600000
+        placement:
+          cluster: "east"
+          tags: ["ssd"]
+        denyDelete: true
+        denyPurge: true
+        reconcile: "update"
     - dbName: "test-connector"
       collName: "coll2"
       changeStreamPreAndPostImages: true
       tokensDbName: "resume-tokens"
       tokensCollName: "coll2"
       tokensCollCapped: false
+      tokensRetention: "count"
+      tokensRetentionCount: 10
       streamName: "COLL2"
+      startAtOperationTime: "2023-05-09T12:00:00Z"
+      ignoreStoredToken: true
+    - scope: "database"
+      dbName: "tenants"
+      excludeColls: ["audit"]
 `
 
 var invalidYamlConfig = `
@@ -50,21 +128,83 @@ func TestLoad(t *testing.T) {
 		config, err := Load(configFile)
 
 		var (
-			logLevel        = "debug"
-			mongoUri        = "mongodb://127.0.0.1:27017,127.0.0.1:27018,127.0.0.1:27019/?replicaSet=mongodb-nats-connector"
-			natsUrl         = "nats://127.0.0.1:4222"
-			addr            = ":8080"
-			csPrePostImages = true
-			capped          = true
-			nonCapped       = false
-			collSize        = int64(4096)
+			logLevel             = "debug"
+			mongoUri             = "mongodb://127.0.0.1:27017,127.0.0.1:27018,127.0.0.1:27019/?replicaSet=mongodb-nats-connector"
+			natsUrl              = "nats://127.0.0.1:4222"
+			addr                 = ":8080"
+			csPrePostImages      = true
+			capped               = true
+			nonCapped            = false
+			collSize             = int64(4096)
+			snapshot             = true
+			ignoreStoredToken    = true
+			checkpointEvents     = 100
+			checkpointIntervalMs = int64(5000)
+			tokensRetentionCount = 10
+			showExpandedEvents   = true
+			followRenames        = true
+			retryInitialDelayMs  = int64(1000)
+			retryMaxDelayMs      = int64(60000)
+			retryMaxAttempts     = 10
+			restartDelayMs       = int64(2000)
+			maxRestarts          = 3
+			publishWindow        = 64
+			leaseTtlMs           = int64(10000)
+			distribute           = true
+			streamReplicas       = 3
+			streamMaxAgeMs       = int64(86400000)
+			streamMaxBytes       = int64(1073741824)
+			streamMaxMsgs        = int64(1000000)
+			streamMaxMsgsPerSubj = int64(500000)
+			streamMaxMsgSize     = int32(1048576)
+			duplicateWindowMs    = int64(600000)
+			denyDelete           = true
+			denyPurge            = true
+			maxPoolSize          = uint64(20)
+			minPoolSize          = uint64(5)
+			maxConnIdleTimeMs    = int64(60000)
 		)
 
 		require.NoError(t, err)
 		require.Equal(t, logLevel, config.Connector.Log.Level)
-		require.Equal(t, mongoUri, config.Connector.Mongo.Uri)
-		require.Equal(t, natsUrl, config.Connector.Nats.Url)
+		require.Equal(t, Mongo{
+			Uri:     mongoUri,
+			AppName: "connector",
+			TLS: &TLS{
+				CertFile: "/etc/mongo/client.pem",
+				KeyFile:  "/etc/mongo/client.key",
+				CaFile:   "/etc/mongo/ca.pem",
+			},
+			Auth: &MongoAuth{
+				Mechanism:    "SCRAM-SHA-256",
+				Username:     "connector",
+				PasswordFile: "/run/secrets/mongo-password",
+			},
+			MaxPoolSize:       &maxPoolSize,
+			MinPoolSize:       &minPoolSize,
+			MaxConnIdleTimeMs: &maxConnIdleTimeMs,
+			ReadConcern:       "majority",
+			Compressors:       []string{"zstd", "snappy"},
+		}, config.Connector.Mongo)
+		require.Equal(t, Nats{
+			Url:          natsUrl,
+			User:         "connector",
+			PasswordFile: "/run/secrets/nats-password",
+			TLS: &TLS{
+				CertFile: "/etc/nats/client.crt",
+				KeyFile:  "/etc/nats/client.key",
+				CaFile:   "/etc/nats/ca.crt",
+			},
+			JetStreamDomain: "hub",
+		}, config.Connector.Nats)
 		require.Equal(t, addr, config.Connector.Server.Addr)
+		require.True(t, *config.Connector.FailFast)
+		require.Equal(t, &LeaderElection{
+			Bucket:     "leases",
+			LeaseTtlMs: &leaseTtlMs,
+			InstanceId: "connector-0",
+			Distribute: &distribute,
+		}, config.Connector.LeaderElection)
 		require.Contains(t, config.Connector.Collections, &Collection{
 			DbName:                       "test-connector",
 			CollName:                     "coll1",
@@ -74,6 +214,46 @@ func TestLoad(t *testing.T) {
 			TokensCollCapped:             &capped,
 			TokensCollSizeInBytes:        &collSize,
 			StreamName:                   "COLL1",
+			Pipeline:                     `[{"$match": {"operationType": "insert"}}]`,
+			Snapshot:                     &snapshot,
+			FullDocument:                 "required",
+			FullDocumentBeforeChange:     "off",
+			CheckpointEvents:             &checkpointEvents,
+			CheckpointIntervalMs:         &checkpointIntervalMs,
+			OnHistoryLost:                "resnapshot",
+			Format:                       "canonicalExtJSON",
+			Envelope:                     "cloudEventsBinary",
+			PublishWindow:                &publishWindow,
+			CheckpointStore:              "nats",
+			CheckpointBucket:             "checkpoints",
+			ShowExpandedEvents:           &showExpandedEvents,
+			FollowRenames:                &followRenames,
+			Redact: []*Redaction{
+				{Path: "**.email", Action: "hash", Salt: "pepper"},
+				{Path: "phone", Action: "keepLast", KeepLast: 4},
+			},
+			RetryInitialDelayMs: &retryInitialDelayMs,
+			RetryMaxDelayMs:     &retryMaxDelayMs,
+			RetryMaxAttempts:    &retryMaxAttempts,
+			RestartDelayMs:      &restartDelayMs,
+			MaxRestarts:         &maxRestarts,
+			Stream: &Stream{
+				Description:       "coll1 change events",
+				Storage:           "file",
+				Replicas:          &streamReplicas,
+				Retention:         "limits",
+				MaxAgeMs:          &streamMaxAgeMs,
+				MaxBytes:          &streamMaxBytes,
+				MaxMsgs:           &streamMaxMsgs,
+				MaxMsgsPerSubject: &streamMaxMsgsPerSubj,
+				MaxMsgSize:        &streamMaxMsgSize,
+				Discard:           "old",
+				DuplicateWindowMs: &duplicateWindowMs,
+				Placement:         &StreamPlacement{Cluster: "east", Tags: []string{"ssd"}},
+				DenyDelete:        &denyDelete,
+				DenyPurge:         &denyPurge,
+				Reconcile:         "update",
+			},
 		})
 		require.Contains(t, config.Connector.Collections, &Collection{
 			DbName:                       "test-connector",
@@ -82,7 +262,16 @@ func TestLoad(t *testing.T) {
 			TokensDbName:                 "resume-tokens",
 			TokensCollName:               "coll2",
 			TokensCollCapped:             &nonCapped,
+			TokensRetention:              "count",
+			TokensRetentionCount:         &tokensRetentionCount,
 			StreamName:                   "COLL2",
+			StartAtOperationTime:         "2023-05-09T12:00:00Z",
+			IgnoreStoredToken:            &ignoreStoredToken,
+		})
+		require.Contains(t, config.Connector.Collections, &Collection{
+			Scope:        "database",
+			DbName:       "tenants",
+			ExcludeColls: []string{"audit"},
 		})
 	})
 	t.Run("when file not found should return error", func(t *testing.T) {
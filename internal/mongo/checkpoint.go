@@ -0,0 +1,98 @@
+package mongo
+
+import (
+	"context"
+	"time"
+)
+
+// defaultIdleCheckpointInterval is how often the post batch resume token of a quiet change stream is stored, when
+// no checkpoint interval is configured.
+const defaultIdleCheckpointInterval = 1 * time.Minute
+
+// CheckpointPolicy tells how often the resume tokens of the published change events are stored.
+// A checkpoint is performed once Events change events have been published, or once Interval has elapsed since the
+// last checkpoint, whichever comes first. If both are zero, a checkpoint is performed after every change event.
+type CheckpointPolicy struct {
+	Events   int
+	Interval time.Duration
+}
+
+// IdleInterval returns how often the post batch resume token of a quiet change stream is stored.
+func (p CheckpointPolicy) IdleInterval() time.Duration {
+	if p.Interval > 0 {
+		return p.Interval
+	}
+	return defaultIdleCheckpointInterval
+}
+
+// checkpointer stores the resume tokens of a change stream, according to a CheckpointPolicy.
+type checkpointer struct {
+	store  CheckpointStore
+	key    string
+	policy CheckpointPolicy
+
+	// pending is the last tracked resume token, stored is the last stored one.
+	pending       string
+	pendingEvents int
+	stored        string
+	storedAt      time.Time
+}
+
+func newCheckpointer(store CheckpointStore, key string, policy CheckpointPolicy) *checkpointer {
+	if policy.Events <= 0 && policy.Interval <= 0 {
+		policy.Events = 1
+	}
+	return &checkpointer{
+		store:    store,
+		key:      key,
+		policy:   policy,
+		storedAt: time.Now(),
+	}
+}
+
+// track records the given resume token as the one to be stored at the next checkpoint.
+// The token either belongs to a published change event, or it is a post batch resume token.
+func (cp *checkpointer) track(token string, published bool) {
+	if token == "" || token == cp.pending {
+		return
+	}
+	cp.pending = token
+	if published {
+		cp.pendingEvents++
+	}
+}
+
+// flushIfDue stores the pending resume token, if a checkpoint is due according to the policy.
+// When the change stream is idle, the pending token is stored once the checkpoint interval has elapsed.
+func (cp *checkpointer) flushIfDue(ctx context.Context, idle bool) error {
+	if cp.due(time.Now(), idle) {
+		return cp.flush(ctx)
+	}
+	return nil
+}
+
+func (cp *checkpointer) due(now time.Time, idle bool) bool {
+	if cp.pending == "" || cp.pending == cp.stored {
+		return false
+	}
+	elapsed := now.Sub(cp.storedAt)
+	if idle {
+		return elapsed >= cp.policy.IdleInterval()
+	}
+	return (cp.policy.Events > 0 && cp.pendingEvents >= cp.policy.Events) ||
+		(cp.policy.Interval > 0 && elapsed >= cp.policy.Interval)
+}
+
+// flush stores the pending resume token, if it has not been stored yet.
+func (cp *checkpointer) flush(ctx context.Context) error {
+	if cp.pending == "" || cp.pending == cp.stored {
+		return nil
+	}
+	if err := cp.store.Save(ctx, cp.key, &Checkpoint{ResumeToken: cp.pending}); err != nil {
+		return err
+	}
+	cp.stored = cp.pending
+	cp.storedAt = time.Now()
+	cp.pendingEvents = 0
+	return nil
+}
@@ -0,0 +1,88 @@
+package mongo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_checkpointer_due(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name          string
+		policy        CheckpointPolicy
+		pending       string
+		pendingEvents int
+		stored        string
+		storedAt      time.Time
+		idle          bool
+		want          bool
+	}{
+		{
+			name:          "should checkpoint after every event by default",
+			pending:       "token1",
+			pendingEvents: 1,
+			storedAt:      now,
+			want:          true,
+		},
+		{
+			name:          "should not checkpoint if the pending token has already been stored",
+			pending:       "token1",
+			pendingEvents: 1,
+			stored:        "token1",
+			storedAt:      now,
+			want:          false,
+		},
+		{
+			name:          "should not checkpoint before the configured number of events",
+			policy:        CheckpointPolicy{Events: 10},
+			pending:       "token9",
+			pendingEvents: 9,
+			storedAt:      now,
+			want:          false,
+		},
+		{
+			name:          "should checkpoint after the configured number of events",
+			policy:        CheckpointPolicy{Events: 10},
+			pending:       "token10",
+			pendingEvents: 10,
+			storedAt:      now,
+			want:          true,
+		},
+		{
+			name:          "should checkpoint after the configured interval",
+			policy:        CheckpointPolicy{Events: 10, Interval: time.Second},
+			pending:       "token1",
+			pendingEvents: 1,
+			storedAt:      now.Add(-2 * time.Second),
+			want:          true,
+		},
+		{
+			name:     "should not checkpoint an idle change stream before the default interval",
+			policy:   CheckpointPolicy{Events: 10},
+			pending:  "pbrt",
+			storedAt: now.Add(-time.Second),
+			idle:     true,
+			want:     false,
+		},
+		{
+			name:     "should checkpoint an idle change stream after the default interval",
+			policy:   CheckpointPolicy{Events: 10},
+			pending:  "pbrt",
+			storedAt: now.Add(-2 * time.Minute),
+			idle:     true,
+			want:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp := newCheckpointer(nil, "", tt.policy)
+			cp.pending = tt.pending
+			cp.pendingEvents = tt.pendingEvents
+			cp.stored = tt.stored
+			cp.storedAt = tt.storedAt
+			require.Equal(t, tt.want, cp.due(now, tt.idle))
+		})
+	}
+}
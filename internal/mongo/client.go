@@ -7,8 +7,10 @@ import (
 	"io"
 	"log/slog"
 	"net/url"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
@@ -18,6 +20,8 @@ import (
 
 const (
 	defaultName = "mongo"
+
+	indexOptionsConflictCode = 85
 )
 
 type Client interface {
@@ -26,6 +30,7 @@ type Client interface {
 
 	CreateCollection(ctx context.Context, opts *CreateCollectionOptions) error
 	WatchCollection(ctx context.Context, opts *WatchCollectionOptions) error
+	WatchDatabase(ctx context.Context, opts *WatchDatabaseOptions) error
 }
 
 type CreateCollectionOptions struct {
@@ -34,26 +39,123 @@ type CreateCollectionOptions struct {
 	Capped                       bool
 	SizeInBytes                  int64
 	ChangeStreamPreAndPostImages bool
+	// ExpireAfter, if greater than 0, creates a TTL index on the createdAt field of the resume tokens.
+	ExpireAfter time.Duration
+}
+
+type ChangeEvent struct {
+	StreamName string
+	Subj       string
+	MsgId      string
+	Data       []byte
+	Headers    map[string]string
 }
 
-type ChangeEventHandler func(ctx context.Context, subj, msgId string, data []byte) error
+type ChangeEventHandler func(ctx context.Context, event *ChangeEvent) error
 
 type WatchCollectionOptions struct {
-	WatchedDbName          string
-	WatchedCollName        string
-	ResumeTokensDbName     string
-	ResumeTokensCollName   string
-	ResumeTokensCollCapped bool
-	StreamName             string
-	ChangeEventHandler     ChangeEventHandler
+	WatchedDbName            string
+	WatchedCollName          string
+	ResumeTokensDbName       string
+	ResumeTokensCollName     string
+	ResumeTokensCollCapped   bool
+	ResumeTokensRetention    TokensRetention
+	CheckpointStore          CheckpointStore
+	CheckpointKey            string
+	StreamName               string
+	Pipeline                 []bson.D
+	Snapshot                 bool
+	StartPosition            StartPosition
+	FullDocument             string
+	FullDocumentBeforeChange string
+	CheckpointPolicy         CheckpointPolicy
+	OnHistoryLost            string
+	ShowExpandedEvents       bool
+	FollowRenames            bool
+	Redaction                []RedactionRule
+	RetryPolicy              RetryPolicy
+	Format                   string
+	Envelope                 string
+	PublishWindow            int
+	ChangeEventHandler       ChangeEventHandler
+	AsyncChangeEventHandler  AsyncChangeEventHandler
+	// StoredTokenReplaced, if set, is called once a checkpoint has been stored in place of the stored resume tokens
+	// ignored because of the start position, so that they are not ignored again when watching again.
+	StoredTokenReplaced func()
+}
+
+// WatchDatabaseOptions configures a single change stream on a whole database or, if WatchedDbName is empty, on the
+// whole deployment. The stream name of each change event is derived from its namespace by StreamNameFunc.
+type WatchDatabaseOptions struct {
+	WatchedDbName            string
+	IncludedCollNames        []string
+	ExcludedCollNames        []string
+	ExcludedNamespaces       []Namespace
+	ResumeTokensDbName       string
+	ResumeTokensCollName     string
+	ResumeTokensCollCapped   bool
+	ResumeTokensRetention    TokensRetention
+	CheckpointStore          CheckpointStore
+	CheckpointKey            string
+	StreamNameFunc           func(dbName, collName string) string
+	Pipeline                 []bson.D
+	StartPosition            StartPosition
+	FullDocument             string
+	FullDocumentBeforeChange string
+	CheckpointPolicy         CheckpointPolicy
+	OnHistoryLost            string
+	ShowExpandedEvents       bool
+	Redaction                []RedactionRule
+	RetryPolicy              RetryPolicy
+	Format                   string
+	Envelope                 string
+	PublishWindow            int
+	ChangeEventHandler       ChangeEventHandler
+	AsyncChangeEventHandler  AsyncChangeEventHandler
+	// StoredTokenReplaced, if set, is called once a checkpoint has been stored in place of the stored resume tokens
+	// ignored because of the start position, so that they are not ignored again when watching again.
+	StoredTokenReplaced func()
+}
+
+// StartPosition tells where a change stream must start from when no resume token has been stored, or when the stored
+// resume tokens must be ignored. At most one of AtOperationTime, ResumeAfter and StartAfter should be set.
+type StartPosition struct {
+	AtOperationTime   *primitive.Timestamp
+	ResumeAfter       string
+	StartAfter        string
+	IgnoreStoredToken bool
+}
+
+func (p StartPosition) isZero() bool {
+	return p.AtOperationTime == nil && p.ResumeAfter == "" && p.StartAfter == ""
+}
+
+func (p StartPosition) apply(logger *slog.Logger, changeStreamOpts *options.ChangeStreamOptions) {
+	switch {
+	case p.AtOperationTime != nil:
+		logger.Debug("starting at operation time", "clusterTime", *p.AtOperationTime)
+		changeStreamOpts.SetStartAtOperationTime(p.AtOperationTime)
+	case p.ResumeAfter != "":
+		logger.Debug("resuming after token", "token", p.ResumeAfter)
+		changeStreamOpts.SetResumeAfter(bson.D{{Key: "_data", Value: p.ResumeAfter}})
+	case p.StartAfter != "":
+		logger.Debug("starting after token", "token", p.StartAfter)
+		changeStreamOpts.SetStartAfter(bson.D{{Key: "_data", Value: p.StartAfter}})
+	}
+}
+
+type Namespace struct {
+	DbName   string
+	CollName string
 }
 
 var _ Client = &DefaultClient{}
 
 type DefaultClient struct {
-	uri    string
-	name   string
-	logger *slog.Logger
+	uri        string
+	name       string
+	logger     *slog.Logger
+	connection ConnectionOptions
 
 	client *mongo.Client
 }
@@ -73,7 +175,11 @@ func NewDefaultClient(opts ...ClientOption) (*DefaultClient, error) {
 		return nil, fmt.Errorf("invalid mongodb uri: %v", err)
 	}
 
-	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(c.uri))
+	clientOpts, err := c.connection.clientOptions(c.uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mongodb options: %v", err)
+	}
+	client, err := mongo.Connect(context.Background(), clientOpts)
 	if err != nil {
 		return nil, fmt.Errorf("could not connect to mongodb: %v", err)
 	}
@@ -120,6 +226,13 @@ func (c *DefaultClient) CreateCollection(ctx context.Context, opts *CreateCollec
 		c.logger.Debug("created mongodb collection", "collName", opts.CollName, "dbName", opts.DbName)
 	}
 
+	// creates or updates the TTL index used to expire the resume tokens
+	if opts.ExpireAfter > 0 {
+		if err = createTtlIndex(ctx, db.Collection(opts.CollName), opts.ExpireAfter); err != nil {
+			return err
+		}
+	}
+
 	// enables change stream pre and post images
 	if opts.ChangeStreamPreAndPostImages {
 		err = db.RunCommand(ctx, bson.D{{Key: "collMod", Value: opts.CollName},
@@ -132,82 +245,476 @@ func (c *DefaultClient) CreateCollection(ctx context.Context, opts *CreateCollec
 	return nil
 }
 
+func createTtlIndex(ctx context.Context, coll *mongo.Collection, expireAfter time.Duration) error {
+	expireAfterSeconds := int32(expireAfter.Seconds())
+	keys := bson.D{{Key: createdAtField, Value: 1}}
+	index := mongo.IndexModel{Keys: keys, Options: options.Index().SetExpireAfterSeconds(expireAfterSeconds)}
+	_, err := coll.Indexes().CreateOne(ctx, index)
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.HasErrorCode(indexOptionsConflictCode) {
+		// the index already exists with a different expiration, update it
+		err = coll.Database().RunCommand(ctx, bson.D{{Key: "collMod", Value: coll.Name()},
+			{Key: "index", Value: bson.D{{Key: "keyPattern", Value: keys},
+				{Key: "expireAfterSeconds", Value: expireAfterSeconds}}}}).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("could not create ttl index on mongo collection %v: %v", coll.Name(), err)
+	}
+	return nil
+}
+
 func (c *DefaultClient) WatchCollection(ctx context.Context, opts *WatchCollectionOptions) error {
+	watchedColl := c.client.Database(opts.WatchedDbName).Collection(opts.WatchedCollName)
+	wOpts := &watchOptions{
+		kind:                   "collection",
+		target:                 fmt.Sprintf("collection %v", opts.WatchedCollName),
+		logArgs:                []any{"collName", opts.WatchedCollName},
+		ns:                     Namespace{DbName: opts.WatchedDbName, CollName: opts.WatchedCollName},
+		pipeline:               opts.Pipeline,
+		resumeTokensDbName:     opts.ResumeTokensDbName,
+		resumeTokensCollName:   opts.ResumeTokensCollName,
+		resumeTokensCollCapped: opts.ResumeTokensCollCapped,
+		checkpointStore:        opts.CheckpointStore,
+		checkpointKey:          opts.CheckpointKey,
+		streamName: func(_, _ string) string {
+			return opts.StreamName
+		},
+		changeEventHandler:       opts.ChangeEventHandler,
+		asyncChangeEventHandler:  opts.AsyncChangeEventHandler,
+		publishWindow:            opts.PublishWindow,
+		storedTokenReplaced:      opts.StoredTokenReplaced,
+		snapshotColl:             watchedColl,
+		snapshot:                 opts.Snapshot,
+		startPosition:            opts.StartPosition,
+		fullDocument:             opts.FullDocument,
+		fullDocumentBeforeChange: opts.FullDocumentBeforeChange,
+		checkpointPolicy:         opts.CheckpointPolicy,
+		tokensRetention:          opts.ResumeTokensRetention,
+		onHistoryLost:            opts.OnHistoryLost,
+		showExpandedEvents:       opts.ShowExpandedEvents,
+		followRenames:            opts.FollowRenames,
+		redactor:                 newRedactor(opts.Redaction),
+		retryPolicy:              opts.RetryPolicy,
+		format:                   opts.Format,
+		envelope:                 opts.Envelope,
+	}
+	return c.watch(ctx, watchedColl, wOpts)
+}
+
+func (c *DefaultClient) WatchDatabase(ctx context.Context, opts *WatchDatabaseOptions) error {
+	pipeline := mongo.Pipeline{}
+	if filter := namespaceFilter(opts); len(filter) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.D{{Key: "$and", Value: filter}}}})
+	}
+	pipeline = append(pipeline, opts.Pipeline...)
+
+	wOpts := &watchOptions{
+		pipeline:                 pipeline,
+		resumeTokensDbName:       opts.ResumeTokensDbName,
+		resumeTokensCollName:     opts.ResumeTokensCollName,
+		resumeTokensCollCapped:   opts.ResumeTokensCollCapped,
+		checkpointStore:          opts.CheckpointStore,
+		checkpointKey:            opts.CheckpointKey,
+		streamName:               opts.StreamNameFunc,
+		changeEventHandler:       opts.ChangeEventHandler,
+		asyncChangeEventHandler:  opts.AsyncChangeEventHandler,
+		publishWindow:            opts.PublishWindow,
+		storedTokenReplaced:      opts.StoredTokenReplaced,
+		startPosition:            opts.StartPosition,
+		fullDocument:             opts.FullDocument,
+		fullDocumentBeforeChange: opts.FullDocumentBeforeChange,
+		checkpointPolicy:         opts.CheckpointPolicy,
+		tokensRetention:          opts.ResumeTokensRetention,
+		onHistoryLost:            opts.OnHistoryLost,
+		showExpandedEvents:       opts.ShowExpandedEvents,
+		redactor:                 newRedactor(opts.Redaction),
+		retryPolicy:              opts.RetryPolicy,
+		format:                   opts.Format,
+		envelope:                 opts.Envelope,
+	}
+
+	// an empty database name means that the whole deployment must be watched
+	if opts.WatchedDbName == "" {
+		wOpts.kind = "deployment"
+		wOpts.target = "deployment"
+		return c.watch(ctx, c.client, wOpts)
+	}
+	wOpts.kind = "database"
+	wOpts.target = fmt.Sprintf("database %v", opts.WatchedDbName)
+	wOpts.logArgs = []any{"dbName", opts.WatchedDbName}
+	wOpts.ns = Namespace{DbName: opts.WatchedDbName}
+	return c.watch(ctx, c.client.Database(opts.WatchedDbName), wOpts)
+}
+
+// namespaceFilter builds the conditions used to discard the change events of the namespaces that must not be
+// published, when watching a database or a whole deployment.
+func namespaceFilter(opts *WatchDatabaseOptions) bson.A {
+	filter := bson.A{}
+	if len(opts.IncludedCollNames) > 0 {
+		filter = append(filter, bson.D{{Key: "ns.coll", Value: bson.D{{Key: "$in", Value: opts.IncludedCollNames}}}})
+	}
+	if len(opts.ExcludedCollNames) > 0 {
+		filter = append(filter, bson.D{{Key: "ns.coll", Value: bson.D{{Key: "$nin", Value: opts.ExcludedCollNames}}}})
+	}
+	if len(opts.ExcludedNamespaces) > 0 {
+		nor := bson.A{}
+		for _, ns := range opts.ExcludedNamespaces {
+			nor = append(nor, bson.D{{Key: "ns.db", Value: ns.DbName}, {Key: "ns.coll", Value: ns.CollName}})
+		}
+		filter = append(filter, bson.D{{Key: "$nor", Value: nor}})
+	}
+	return filter
+}
+
+// watchable is implemented by the mongo collections, databases and clients that can be watched.
+type watchable interface {
+	Watch(ctx context.Context, pipeline any, opts ...*options.ChangeStreamOptions) (*mongo.ChangeStream, error)
+}
+
+type watchOptions struct {
+	kind                     string
+	target                   string
+	logArgs                  []any
+	ns                       Namespace
+	pipeline                 []bson.D
+	resumeTokensDbName       string
+	resumeTokensCollName     string
+	resumeTokensCollCapped   bool
+	checkpointStore          CheckpointStore
+	checkpointKey            string
+	streamName               func(dbName, collName string) string
+	changeEventHandler       ChangeEventHandler
+	asyncChangeEventHandler  AsyncChangeEventHandler
+	publishWindow            int
+	storedTokenReplaced      func()
+	snapshotColl             *mongo.Collection
+	snapshot                 bool
+	startPosition            StartPosition
+	fullDocument             string
+	fullDocumentBeforeChange string
+	checkpointPolicy         CheckpointPolicy
+	tokensRetention          TokensRetention
+	onHistoryLost            string
+	showExpandedEvents       bool
+	followRenames            bool
+	redactor                 *redactor
+	retryPolicy              RetryPolicy
+	format                   string
+	envelope                 string
+}
 
-	resumeTokensDb := c.client.Database(opts.ResumeTokensDbName)
-	resumeTokensColl := resumeTokensDb.Collection(opts.ResumeTokensCollName)
+func (c *DefaultClient) watch(ctx context.Context, watched watchable, opts *watchOptions) error {
+	// the checkpoints are stored in the resume tokens collection, unless another store is given
+	store := opts.checkpointStore
+	if store == nil {
+		store = &resumeTokensStore{
+			coll:      c.client.Database(opts.resumeTokensDbName).Collection(opts.resumeTokensCollName),
+			capped:    opts.resumeTokensCollCapped,
+			retention: opts.tokensRetention,
+		}
+	}
 
-	watchedDb := c.client.Database(opts.WatchedDbName)
-	watchedColl := watchedDb.Collection(opts.WatchedCollName)
+	// the stored resume tokens are ignored until a new one is stored
+	ignoreStoredToken := opts.startPosition.IgnoreStoredToken
+	storedTokenReplaced := func() {
+		if ignoreStoredToken && opts.storedTokenReplaced != nil {
+			opts.storedTokenReplaced()
+		}
+		ignoreStoredToken = false
+	}
+	// the lost resume token is ignored until a new one, or a new snapshot progress, is stored
+	historyLost := false
+	// the renamed collection is watched from the time it has been renamed, until a new resume token is stored
+	var renamedAt *primitive.Timestamp
+	// the change stream is reopened after a delay when it fails
+	retrier := newRetrier(opts.retryPolicy, c.logger.With(opts.logArgs...))
 
 	for {
-		findOneOpts := options.FindOne()
-		if opts.ResumeTokensCollCapped {
-			// use natural sort for capped collections to get the last inserted resume token
-			findOneOpts.SetSort(bson.D{{Key: "$natural", Value: -1}})
-		} else {
-			// cannot rely on natural sort for uncapped collections, sort by id instead
-			findOneOpts.SetSort(bson.D{{Key: "_id", Value: -1}})
+		var err error
+		lastResumeToken := &Checkpoint{}
+		if historyLost {
+			c.logger.Debug("ignoring lost resume token")
+		} else if renamedAt != nil {
+			c.logger.Debug("ignoring resume token of renamed collection")
+		} else if ignoreStoredToken {
+			c.logger.Debug("ignoring stored resume token")
+		} else if lastResumeToken, err = store.Load(ctx, opts.checkpointKey); err != nil {
+			if err = retrier.backoff(ctx, err); err != nil {
+				return err
+			}
+			continue
+		}
+
+		changeStreamOpts := options.ChangeStream()
+		if opts.fullDocument != "" {
+			changeStreamOpts.SetFullDocument(options.FullDocument(opts.fullDocument))
+		}
+		if opts.fullDocumentBeforeChange != "" {
+			changeStreamOpts.SetFullDocumentBeforeChange(options.FullDocument(opts.fullDocumentBeforeChange))
+		}
+		if opts.showExpandedEvents {
+			changeStreamOpts.SetShowExpandedEvents(true)
 		}
 
-		lastResumeToken := &resumeToken{}
-		err := resumeTokensColl.FindOne(ctx, bson.D{}, findOneOpts).Decode(lastResumeToken)
-		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
-			return fmt.Errorf("could not fetch or decode resume token: %v", err)
+		switch {
+		case renamedAt != nil:
+			c.logger.Debug("starting at operation time", "clusterTime", *renamedAt)
+			changeStreamOpts.SetStartAtOperationTime(renamedAt)
+		case lastResumeToken.ResumeToken != "":
+			// unlike resumeAfter, startAfter can also start a new change stream after an invalidate event, such as
+			// the ones sent when the watched collection is dropped or renamed
+			c.logger.Debug("starting after token", "token", lastResumeToken.ResumeToken)
+			changeStreamOpts.SetStartAfter(bson.D{{Key: "_data", Value: lastResumeToken.ResumeToken}})
+		case !historyLost && lastResumeToken.Snapshot == nil && !opts.startPosition.isZero():
+			opts.startPosition.apply(c.logger, changeStreamOpts)
+		case shouldSnapshot(opts, lastResumeToken, historyLost):
+			progress := lastResumeToken.Snapshot
+			if progress == nil || !progress.Done {
+				progress, err = c.snapshot(ctx, store, progress, opts)
+				if progress != nil {
+					// the snapshot progress has been stored
+					storedTokenReplaced()
+					historyLost = false
+				}
+				if err != nil {
+					// snapshot progress has been stored after each batch of published documents.
+					// connector will continue the snapshot after the last stored document id.
+					c.logger.Error("could not snapshot mongodb collection", "err", err)
+					if err = retrier.backoff(ctx, err); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			// start right where the snapshot started, so that no change event is missed
+			c.logger.Debug("starting at operation time", "clusterTime", progress.ClusterTime)
+			changeStreamOpts.SetStartAtOperationTime(&progress.ClusterTime)
 		}
 
-		changeStreamOpts := options.ChangeStream().
-			SetFullDocument(options.UpdateLookup).
-			SetFullDocumentBeforeChange(options.WhenAvailable)
+		pipeline := mongo.Pipeline{}
+		pipeline = append(pipeline, opts.pipeline...)
+
+		cs, err := watched.Watch(ctx, pipeline, changeStreamOpts)
+		if isHistoryLost(err) {
+			// the change events after the last resume token are no longer in the oplog, consumers are warned that
+			// they may have missed some of them before recovering according to the policy
+			if gapErr := c.publishGap(ctx, opts, lastResumeToken.ResumeToken, err); gapErr != nil {
+				c.logger.Error("could not publish gap event", "err", gapErr)
+				if err = retrier.backoff(ctx, gapErr); err != nil {
+					return err
+				}
+				continue
+			}
+			if opts.onHistoryLost == ResumeFromNowOnHistoryLost || opts.onHistoryLost == ResnapshotOnHistoryLost {
+				historyLost = true
+				continue
+			}
+		}
+		if err != nil {
+			if err = retrier.backoff(ctx, fmt.Errorf("could not watch mongo %v: %w", opts.target, err)); err != nil {
+				return err
+			}
+			continue
+		}
+		c.logger.Info("watching mongodb "+opts.kind, opts.logArgs...)
+
+		cp := newCheckpointer(store, opts.checkpointKey, opts.checkpointPolicy)
+		if historyLost {
+			// the change stream has been reopened from now: its initial resume token is stored right away, so that
+			// the lost one is not used anymore
+			if token, ok := cs.ResumeToken().Lookup("_data").StringValueOK(); ok {
+				cp.track(token, false)
+			}
+			if err = cp.flush(ctx); err != nil {
+				c.logger.Error("could not checkpoint change stream", "err", err)
+			}
+		}
+		renamed, err := c.consume(ctx, cs, cp, retrier, opts)
+
+		// the resume tokens of the published change events are stored before reopening the change stream or
+		// shutting down, so that they will not be published again.
+		if flushErr := cp.flush(context.Background()); flushErr != nil {
+			// connector will resume after the previous token, publishing duplicate change events.
+			// consumers should be able to detect and discard the duplicate change events by using the msg id.
+			c.logger.Error("could not checkpoint change stream", "err", flushErr)
+		}
+		if cp.stored != "" {
+			storedTokenReplaced()
+			historyLost = false
+			renamedAt = nil
+		}
+
+		c.logger.Info("stopped watching mongodb "+opts.kind, opts.logArgs...)
+		if closeErr := cs.Close(context.Background()); closeErr != nil && err == nil {
+			err = fmt.Errorf("could not close change stream: %v", closeErr)
+		}
 
-		if lastResumeToken.Value != "" {
-			c.logger.Debug("resuming after token", "token", lastResumeToken.Value)
-			changeStreamOpts.SetResumeAfter(bson.D{{Key: "_data", Value: lastResumeToken.Value}})
+		if renamed != nil {
+			// the change stream has been invalidated by the rename, the collection is watched under its new name
+			c.logger.Info("following renamed mongodb collection", append(opts.logArgs,
+				"renamedToDbName", renamed.to.DbName, "renamedToCollName", renamed.to.CollName)...)
+			renamedColl := c.client.Database(renamed.to.DbName).Collection(renamed.to.CollName)
+			watched = renamedColl
+			opts.snapshotColl = renamedColl
+			opts.ns = renamed.to
+			opts.target = fmt.Sprintf("collection %v", renamed.to.CollName)
+			opts.logArgs = []any{"collName", renamed.to.CollName}
+			renamedAt = &renamed.clusterTime
 		}
 
-		cs, err := watchedColl.Watch(ctx, mongo.Pipeline{}, changeStreamOpts)
 		if err != nil {
-			return fmt.Errorf("could not watch mongo collection %v: %v", watchedColl.Name(), err)
+			// the change stream has failed: it is reopened after a delay, unless the error is permanent
+			if err = retrier.backoff(ctx, err); err != nil {
+				return err
+			}
 		}
-		c.logger.Info("watching mongodb collection", "collName", watchedColl.Name())
+	}
+}
+
+// renamedNamespace tells where the watched collection has been renamed to, and when.
+type renamedNamespace struct {
+	to          Namespace
+	clusterTime primitive.Timestamp
+}
 
-		for cs.Next(ctx) {
-			currentResumeToken := cs.Current.Lookup("_id", "_data").StringValue()
-			operationType := cs.Current.Lookup("operationType").StringValue()
+// consume publishes the change events received from the given change stream, until it is closed or an error occurs.
+// The resume tokens of the published change events are stored according to the checkpoint policy.
+// If a publish window is set, the change events are published asynchronously, and the change events still being
+// published are waited for before returning.
+// The returned namespace is not nil only if the watched collection has been renamed, and the rename must be followed,
+// in which case the change stream is closed by the following invalidate event.
+// The returned error is nil if the change stream has been closed by an invalidate event.
+func (c *DefaultClient) consume(ctx context.Context, cs *mongo.ChangeStream, cp *checkpointer, retrier *retrier,
+	opts *watchOptions) (renamed *renamedNamespace, err error) {
+	var window *inflightWindow
+	if opts.asyncChangeEventHandler != nil && opts.publishWindow > 1 {
+		window = newInflightWindow(opts.publishWindow)
+		defer func() {
+			// the resume tokens of the change events acknowledged in the meantime can still be stored
+			if settleErr := window.settle(context.Background(), cp, retrier, 0); settleErr != nil && err == nil {
+				c.logger.Error("could not publish change event", "err", settleErr)
+				err = settleErr
+			}
+		}()
+	}
 
-			json, err := bson.MarshalExtJSON(cs.Current, false, false)
+	for {
+		if !cs.TryNext(ctx) {
+			if err = cs.Err(); err != nil {
+				return renamed, fmt.Errorf("could not watch mongo %v: %w", opts.target, err)
+			}
+			if cs.ID() == 0 {
+				// change stream has been closed by an invalidate event
+				return renamed, nil
+			}
+			if window != nil {
+				// the post batch resume token can only be stored after all the previous change events
+				if err = window.settle(ctx, cp, retrier, 0); err != nil {
+					c.logger.Error("could not publish change event", "err", err)
+					return renamed, err
+				}
+			}
+			// no change event is available: the post batch resume token can be stored, so that the resume token
+			// of a quiet change stream still moves forward
+			if token, ok := cs.ResumeToken().Lookup("_data").StringValueOK(); ok {
+				cp.track(token, false)
+			}
+			if err = cp.flushIfDue(ctx, true); err != nil {
+				c.logger.Error("could not checkpoint change stream", "err", err)
+				return renamed, err
+			}
+			retrier.reset()
+			continue
+		}
+
+		currentResumeToken, operationType, err := eventIdentity(cs.Current)
+		if err != nil {
+			c.logger.Error("could not publish change event", "err", err)
+			return renamed, err
+		}
+		dbName, _ := cs.Current.Lookup("ns", "db").StringValueOK()
+		collName, _ := cs.Current.Lookup("ns", "coll").StringValueOK()
+
+		// the fields are redacted before the change event is either logged or published
+		var changeEvent any = cs.Current
+		if opts.redactor != nil {
+			redacted, err := opts.redactor.redactEvent(cs.Current)
 			if err != nil {
-				return fmt.Errorf("could not marshal mongo change event from bson: %v", err)
+				return renamed, Permanent(err)
 			}
-			c.logger.Debug("received change event", "changeEvent", string(json))
+			changeEvent = redacted
+		}
+		data, headers, err := encodeEvent(opts, changeEvent)
+		if err != nil {
+			return renamed, Permanent(fmt.Errorf("could not marshal mongo change event from bson: %v", err))
+		}
+		c.logger.Debug("received change event", "changeEvent", loggable(headers, data))
+
+		streamName := opts.streamName(dbName, collName)
+		event := &ChangeEvent{
+			StreamName: streamName,
+			Subj:       fmt.Sprintf("%s.%s", streamName, operationType),
+			MsgId:      currentResumeToken,
+			Data:       data,
+			Headers:    headers,
+		}
 
-			subj := fmt.Sprintf("%s.%s", opts.StreamName, operationType)
-			if err = opts.ChangeEventHandler(ctx, subj, currentResumeToken, json); err != nil {
-				// current change event was not published.
-				// current resume token will not be stored.
-				// connector will resume after the previous token.
-				c.logger.Error("could not publish change event", err)
-				break
+		if operationType == "rename" && opts.followRenames &&
+			dbName == opts.ns.DbName && collName == opts.ns.CollName {
+			toDbName, _ := cs.Current.Lookup("to", "db").StringValueOK()
+			toCollName, _ := cs.Current.Lookup("to", "coll").StringValueOK()
+			t, i, _ := cs.Current.Lookup("clusterTime").TimestampOK()
+			renamed = &renamedNamespace{
+				to:          Namespace{DbName: toDbName, CollName: toCollName},
+				clusterTime: primitive.Timestamp{T: t, I: i},
 			}
+		}
 
-			if _, err = resumeTokensColl.InsertOne(ctx, &resumeToken{Value: currentResumeToken}); err != nil {
-				// change event has been published but token insertion failed.
-				// connector will resume after the previous token, publishing a duplicate change event.
-				// consumers should be able to detect and discard the duplicate change event by using the msg id.
-				c.logger.Error("could not insert resume token", err)
-				break
+		if window != nil {
+			// the resume token is stored once the change event and all the previous ones have been acknowledged,
+			// waiting for the oldest ones if the publish window is full
+			if err = window.publish(ctx, opts.asyncChangeEventHandler, event, currentResumeToken); err == nil {
+				err = window.settle(ctx, cp, retrier, window.size-1)
 			}
+			if err != nil {
+				c.logger.Error("could not publish change event", "err", err)
+				return renamed, err
+			}
+			continue
 		}
 
-		c.logger.Info("stopped watching mongodb collection", "collName", watchedColl.Name())
-		if err = cs.Close(context.Background()); err != nil {
-			return fmt.Errorf("could not close change stream: %v", err)
+		if err = opts.changeEventHandler(ctx, event); err != nil {
+			// current change event was not published.
+			// current resume token will not be stored.
+			// connector will resume after the last stored token.
+			c.logger.Error("could not publish change event", "err", err)
+			return renamed, err
+		}
+		retrier.reset()
+
+		cp.track(currentResumeToken, true)
+		if err = cp.flushIfDue(ctx, false); err != nil {
+			// change events have been published but token insertion failed.
+			// connector will resume after the last stored token, publishing duplicate change events.
+			// consumers should be able to detect and discard the duplicate change events by using the msg id.
+			c.logger.Error("could not checkpoint change stream", "err", err)
+			return renamed, err
 		}
 	}
 }
 
-type resumeToken struct {
-	Value string `bson:"value"`
+// eventIdentity returns the resume token and the operation type of the given change event. They may have been removed
+// by the pipeline of the change stream, in which case the change event cannot be published.
+func eventIdentity(event bson.Raw) (token, operationType string, err error) {
+	token, ok := event.Lookup("_id", "_data").StringValueOK()
+	if !ok {
+		return "", "", Permanent(errors.New("change event has no resume token"))
+	}
+	operationType, ok = event.Lookup("operationType").StringValueOK()
+	if !ok {
+		return "", "", Permanent(errors.New("change event has no operationType, it must not be removed by the pipeline"))
+	}
+	return token, operationType, nil
 }
 
 type ClientOption func(*DefaultClient)
@@ -227,3 +734,10 @@ func WithLogger(logger *slog.Logger) ClientOption {
 		}
 	}
 }
+
+// WithConnectionOptions sets the options of the connection to MongoDB, overriding the settings of its URI.
+func WithConnectionOptions(opts ConnectionOptions) ClientOption {
+	return func(c *DefaultClient) {
+		c.connection = opts
+	}
+}
@@ -0,0 +1,54 @@
+package mongo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestEventIdentity(t *testing.T) {
+	tests := []struct {
+		name              string
+		event             bson.D
+		wantToken         string
+		wantOperationType string
+		wantErr           string
+	}{
+		{
+			name: "should return the resume token and the operation type",
+			event: bson.D{{Key: "_id", Value: bson.D{{Key: "_data", Value: "token1"}}},
+				{Key: "operationType", Value: "insert"}},
+			wantToken:         "token1",
+			wantOperationType: "insert",
+		},
+		{
+			name:    "should return permanent error cause the operation type has been removed by the pipeline",
+			event:   bson.D{{Key: "_id", Value: bson.D{{Key: "_data", Value: "token1"}}}},
+			wantErr: "change event has no operationType",
+		},
+		{
+			name:    "should return permanent error cause the resume token has been removed by the pipeline",
+			event:   bson.D{{Key: "operationType", Value: "insert"}},
+			wantErr: "change event has no resume token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := bson.Marshal(tt.event)
+			require.NoError(t, err)
+
+			token, operationType, err := eventIdentity(raw)
+
+			if tt.wantErr != "" {
+				require.ErrorIs(t, err, ErrPermanent)
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantToken, token)
+			require.Equal(t, tt.wantOperationType, operationType)
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package mongo
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+)
+
+// The supported mechanisms to authenticate to MongoDB.
+const (
+	ScramSha1AuthMechanism   = "SCRAM-SHA-1"
+	ScramSha256AuthMechanism = "SCRAM-SHA-256"
+	X509AuthMechanism        = "MONGODB-X509"
+	AwsAuthMechanism         = "MONGODB-AWS"
+)
+
+// ConnectionOptions configures the connection to MongoDB on top of its URI: the settings of the URI are overridden by
+// the non-zero fields. If a TLS file is set, the TLS configuration replaces the one of the URI.
+type ConnectionOptions struct {
+	AppName         string
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSCaFile       string
+	AuthMechanism   string
+	AuthSource      string
+	Username        string
+	Password        string
+	MaxPoolSize     uint64
+	MinPoolSize     uint64
+	MaxConnIdleTime time.Duration
+	ReadConcern     string
+	Compressors     []string
+}
+
+// clientOptions returns the options of the MongoDB client connecting to the given URI, overridden by the connection
+// options.
+func (o *ConnectionOptions) clientOptions(uri string) (*options.ClientOptions, error) {
+	clientOpts := options.Client().ApplyURI(uri)
+	if o.AppName != "" {
+		clientOpts.SetAppName(o.AppName)
+	}
+	if o.TLSCertFile != "" || o.TLSCaFile != "" {
+		tlsConfig, err := o.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		clientOpts.SetTLSConfig(tlsConfig)
+	}
+	if o.AuthMechanism != "" || o.AuthSource != "" || o.Username != "" || o.Password != "" {
+		var credential options.Credential
+		if clientOpts.Auth != nil {
+			credential = *clientOpts.Auth
+		}
+		if o.AuthMechanism != "" {
+			credential.AuthMechanism = o.AuthMechanism
+		}
+		if o.AuthSource != "" {
+			credential.AuthSource = o.AuthSource
+		}
+		if o.Username != "" {
+			credential.Username = o.Username
+		}
+		if o.Password != "" {
+			credential.Password = o.Password
+			credential.PasswordSet = true
+		}
+		clientOpts.SetAuth(credential)
+	}
+	if o.MaxPoolSize > 0 {
+		clientOpts.SetMaxPoolSize(o.MaxPoolSize)
+	}
+	if o.MinPoolSize > 0 {
+		clientOpts.SetMinPoolSize(o.MinPoolSize)
+	}
+	if o.MaxConnIdleTime > 0 {
+		clientOpts.SetMaxConnIdleTime(o.MaxConnIdleTime)
+	}
+	if o.ReadConcern != "" {
+		clientOpts.SetReadConcern(readconcern.New(readconcern.Level(o.ReadConcern)))
+	}
+	if len(o.Compressors) > 0 {
+		clientOpts.SetCompressors(o.Compressors)
+	}
+	return clientOpts, clientOpts.Validate()
+}
+
+// tlsConfig returns the TLS configuration presenting the client certificate, if any, and verifying the server
+// certificate with the CA, if any, instead of the system ones.
+func (o *ConnectionOptions) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if o.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.TLSCertFile, o.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load mongodb client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if o.TLSCaFile != "" {
+		ca, err := os.ReadFile(o.TLSCaFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read mongodb ca file: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New("could not read mongodb ca file: no certificate found")
+		}
+	}
+	return tlsConfig, nil
+}
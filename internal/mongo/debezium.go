@@ -0,0 +1,114 @@
+package mongo
+
+import (
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+const (
+	// DebeziumEnvelope publishes the change events in the envelope of the Debezium MongoDB connector, with the
+	// `before`, `after`, `updateDescription`, `source`, `op` and `ts_ms` fields. Only the insert, update, replace,
+	// delete and snapshot events are wrapped, the other events are published as they are.
+	DebeziumEnvelope = "debezium"
+
+	debeziumConnector = "mongodb"
+)
+
+// debeziumOps maps the operation types of the change events to the Debezium op codes.
+var debeziumOps = map[string]string{
+	"insert":              "c",
+	"update":              "u",
+	"replace":             "u",
+	"delete":              "d",
+	snapshotOperationType: "r",
+}
+
+// encodeDebezium wraps the given change event in the Debezium envelope. The documents are written as strings, as the
+// Debezium MongoDB connector does, serialized according to the given format. The returned bool is false if the
+// change event has no Debezium equivalent.
+func encodeDebezium(opts *watchOptions, event bson.Raw) ([]byte, bool, error) {
+	operationType, _ := event.Lookup("operationType").StringValueOK()
+	op, ok := debeziumOps[operationType]
+	if !ok {
+		return nil, false, nil
+	}
+
+	before, err := debeziumDocument(opts.format, event, "fullDocumentBeforeChange")
+	if err != nil {
+		return nil, false, err
+	}
+	after, err := debeziumDocument(opts.format, event, "fullDocument")
+	if err != nil {
+		return nil, false, err
+	}
+	var updateDescription any
+	if desc, ok := event.Lookup("updateDescription").DocumentOK(); ok {
+		updatedFields, err := debeziumDocument(opts.format, desc, "updatedFields")
+		if err != nil {
+			return nil, false, err
+		}
+		updateDescription = bson.D{
+			{Key: "removedFields", Value: debeziumArray(desc, "removedFields")},
+			{Key: "updatedFields", Value: updatedFields},
+			{Key: "truncatedArrays", Value: debeziumArray(desc, "truncatedArrays")},
+		}
+	}
+
+	dbName, _ := event.Lookup("ns", "db").StringValueOK()
+	collName, _ := event.Lookup("ns", "coll").StringValueOK()
+	resumeToken, _ := event.Lookup("_id", "_data").StringValueOK()
+	t, i, _ := event.Lookup("clusterTime").TimestampOK()
+	source := bson.D{
+		{Key: "connector", Value: debeziumConnector},
+		{Key: "name", Value: opts.streamName(dbName, collName)},
+		{Key: "ts_ms", Value: int64(t) * 1000},
+		// Debezium writes the snapshot flag as a string
+		{Key: "snapshot", Value: strconv.FormatBool(operationType == snapshotOperationType)},
+		{Key: "db", Value: dbName},
+		{Key: "collection", Value: collName},
+		{Key: "ord", Value: int64(i)},
+		{Key: "resume_token", Value: resumeToken},
+	}
+	if lsid, err := debeziumDocument(JsonFormat, event, "lsid"); err == nil && lsid != nil {
+		source = append(source, bson.E{Key: "lsid", Value: lsid})
+	}
+	if txnNumber, ok := event.Lookup("txnNumber").AsInt64OK(); ok {
+		source = append(source, bson.E{Key: "txnNumber", Value: txnNumber})
+	}
+
+	data, err := marshalEvent(JsonFormat, bson.D{
+		{Key: "before", Value: before},
+		{Key: "after", Value: after},
+		{Key: "updateDescription", Value: updateDescription},
+		{Key: "source", Value: source},
+		{Key: "op", Value: op},
+		{Key: "ts_ms", Value: time.Now().UnixMilli()},
+		{Key: "transaction", Value: nil},
+	})
+	return data, true, err
+}
+
+// debeziumDocument returns the given field of the given document serialized as a string, or nil if the field is not
+// a document (e.g. it is missing or null).
+func debeziumDocument(format string, doc bson.Raw, key string) (any, error) {
+	field, ok := doc.Lookup(key).DocumentOK()
+	if !ok {
+		return nil, nil
+	}
+	data, err := marshalEvent(format, field)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// debeziumArray returns the given array field of the given document, or an empty array if the field is missing.
+func debeziumArray(doc bson.Raw, key string) any {
+	if field := doc.Lookup(key); field.Type == bsontype.Array {
+		return field
+	}
+	return bson.A{}
+}
@@ -0,0 +1,114 @@
+package mongo
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestEncodeDebezium(t *testing.T) {
+	opts := &watchOptions{
+		envelope: DebeziumEnvelope,
+		format:   JsonFormat,
+		streamName: func(_, _ string) string {
+			return "USERS"
+		},
+	}
+	newEvent := func(operationType string, fields ...bson.E) bson.Raw {
+		event := bson.D{
+			{Key: "_id", Value: bson.D{{Key: "_data", Value: "8264"}}},
+			{Key: "operationType", Value: operationType},
+			{Key: "clusterTime", Value: primitive.Timestamp{T: 1683633600, I: 3}},
+			{Key: "ns", Value: bson.D{{Key: "db", Value: "test-db"}, {Key: "coll", Value: "users"}}},
+			{Key: "documentKey", Value: bson.D{{Key: "_id", Value: "u1"}}},
+		}
+		raw, _ := bson.Marshal(append(event, fields...))
+		return raw
+	}
+	decode := func(t *testing.T, data []byte) map[string]any {
+		var envelope map[string]any
+		require.NoError(t, json.Unmarshal(data, &envelope))
+		require.NotZero(t, envelope["ts_ms"])
+		delete(envelope, "ts_ms")
+		return envelope
+	}
+
+	t.Run("should map an insert event to a create event", func(t *testing.T) {
+		event := newEvent("insert", bson.E{Key: "fullDocument", Value: bson.D{{Key: "_id", Value: "u1"},
+			{Key: "name", Value: "john"}}})
+
+		data, headers, err := encodeEvent(opts, event)
+
+		require.NoError(t, err)
+		require.Equal(t, "application/json", headers["Content-Type"])
+		require.Equal(t, map[string]any{
+			"before":            nil,
+			"after":             `{"_id":"u1","name":"john"}`,
+			"updateDescription": nil,
+			"source": map[string]any{
+				"connector":    "mongodb",
+				"name":         "USERS",
+				"ts_ms":        float64(1683633600000),
+				"snapshot":     "false",
+				"db":           "test-db",
+				"collection":   "users",
+				"ord":          float64(3),
+				"resume_token": "8264",
+			},
+			"op":          "c",
+			"transaction": nil,
+		}, decode(t, data))
+	})
+	t.Run("should map an update event with its update description", func(t *testing.T) {
+		event := newEvent("update",
+			bson.E{Key: "updateDescription", Value: bson.D{
+				{Key: "updatedFields", Value: bson.D{{Key: "name", Value: "jane"}}},
+				{Key: "removedFields", Value: bson.A{"nickname"}},
+			}},
+			bson.E{Key: "fullDocumentBeforeChange", Value: bson.D{{Key: "_id", Value: "u1"}, {Key: "name", Value: "john"}}},
+			bson.E{Key: "fullDocument", Value: bson.D{{Key: "_id", Value: "u1"}, {Key: "name", Value: "jane"}}},
+			bson.E{Key: "txnNumber", Value: int64(7)},
+		)
+
+		data, _, err := encodeEvent(opts, event)
+
+		require.NoError(t, err)
+		envelope := decode(t, data)
+		require.Equal(t, "u", envelope["op"])
+		require.Equal(t, `{"_id":"u1","name":"john"}`, envelope["before"])
+		require.Equal(t, `{"_id":"u1","name":"jane"}`, envelope["after"])
+		require.Equal(t, map[string]any{
+			"removedFields":   []any{"nickname"},
+			"updatedFields":   `{"name":"jane"}`,
+			"truncatedArrays": []any{},
+		}, envelope["updateDescription"])
+		require.Equal(t, float64(7), envelope["source"].(map[string]any)["txnNumber"])
+	})
+	t.Run("should map a delete event and a snapshot document", func(t *testing.T) {
+		data, _, err := encodeEvent(opts, newEvent("delete"))
+
+		require.NoError(t, err)
+		envelope := decode(t, data)
+		require.Equal(t, "d", envelope["op"])
+		require.Nil(t, envelope["after"])
+
+		data, _, err = encodeEvent(opts, newEvent("snapshot", bson.E{Key: "fullDocument", Value: bson.D{
+			{Key: "_id", Value: "u1"}}}))
+
+		require.NoError(t, err)
+		envelope = decode(t, data)
+		require.Equal(t, "r", envelope["op"])
+		require.Equal(t, "true", envelope["source"].(map[string]any)["snapshot"])
+	})
+	t.Run("should publish the events without a debezium equivalent as they are", func(t *testing.T) {
+		data, headers, err := encodeEvent(opts, newEvent("drop"))
+
+		require.NoError(t, err)
+		require.Equal(t, "application/json", headers["Content-Type"])
+		require.Contains(t, string(data), `"operationType":"drop"`)
+		require.NotContains(t, string(data), `"op":`)
+	})
+}
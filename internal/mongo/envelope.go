@@ -0,0 +1,171 @@
+package mongo
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	// NoEnvelope publishes the change events as they are, which is the default envelope.
+	NoEnvelope = "none"
+	// CloudEventsBinaryEnvelope publishes the change events as CloudEvents 1.0 in binary mode: the message data is the
+	// change event, while the CloudEvents attributes are set as `ce-*` headers.
+	CloudEventsBinaryEnvelope = "cloudEventsBinary"
+	// CloudEventsStructuredEnvelope publishes the change events as CloudEvents 1.0 in structured mode: the message data
+	// is a JSON CloudEvent, whose data is the change event.
+	CloudEventsStructuredEnvelope = "cloudEventsStructured"
+
+	cloudEventsSpecVersion        = "1.0"
+	cloudEventsTypePrefix         = "com.mongodb.changestream."
+	cloudEventsHeaderPrefix       = "ce-"
+	cloudEventsStructuredMimeType = "application/cloudevents+json; charset=UTF-8"
+)
+
+// cloudEvent holds the CloudEvents attributes of a change event.
+type cloudEvent struct {
+	id      string
+	source  string
+	typ     string
+	time    string
+	subject string
+}
+
+// encodeEvent serializes the given change event in the format and envelope of the given options, and returns the
+// headers of the message it is published with.
+func encodeEvent(opts *watchOptions, event any) ([]byte, map[string]string, error) {
+	raw, ok := event.(bson.Raw)
+	if !ok {
+		b, err := bson.Marshal(event)
+		if err != nil {
+			return nil, nil, err
+		}
+		raw = b
+	}
+
+	data, headers, err := wrapEvent(opts, raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	addMetadataHeaders(headers, raw)
+	return data, headers, nil
+}
+
+// wrapEvent serializes the given change event in the envelope of the given options.
+func wrapEvent(opts *watchOptions, raw bson.Raw) ([]byte, map[string]string, error) {
+	switch opts.envelope {
+	case "", NoEnvelope:
+		data, err := marshalEvent(opts.format, raw)
+		return data, map[string]string{ContentTypeHeader: ContentType(opts.format)}, err
+	case CloudEventsBinaryEnvelope:
+		data, err := marshalEvent(opts.format, raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		ce := newCloudEvent(raw)
+		headers := map[string]string{
+			ContentTypeHeader:                       ContentType(opts.format),
+			cloudEventsHeaderPrefix + "specversion": cloudEventsSpecVersion,
+			cloudEventsHeaderPrefix + "id":          ce.id,
+			cloudEventsHeaderPrefix + "source":      ce.source,
+			cloudEventsHeaderPrefix + "type":        ce.typ,
+		}
+		if ce.time != "" {
+			headers[cloudEventsHeaderPrefix+"time"] = ce.time
+		}
+		if ce.subject != "" {
+			headers[cloudEventsHeaderPrefix+"subject"] = ce.subject
+		}
+		return data, headers, nil
+	case CloudEventsStructuredEnvelope:
+		ce := newCloudEvent(raw)
+		structured := bson.D{
+			{Key: "specversion", Value: cloudEventsSpecVersion},
+			{Key: "id", Value: ce.id},
+			{Key: "source", Value: ce.source},
+			{Key: "type", Value: ce.typ},
+		}
+		if ce.time != "" {
+			structured = append(structured, bson.E{Key: "time", Value: ce.time})
+		}
+		if ce.subject != "" {
+			structured = append(structured, bson.E{Key: "subject", Value: ce.subject})
+		}
+		structured = append(structured, bson.E{Key: "datacontenttype", Value: ContentType(opts.format)})
+		// the structured mode is always JSON, binary data is base64 encoded
+		format := opts.format
+		if format == BsonFormat {
+			format = RelaxedExtJSONFormat
+			structured = append(structured, bson.E{Key: "data_base64", Value: base64.StdEncoding.EncodeToString(raw)})
+		} else {
+			structured = append(structured, bson.E{Key: "data", Value: raw})
+		}
+		data, err := marshalEvent(format, structured)
+		return data, map[string]string{ContentTypeHeader: cloudEventsStructuredMimeType}, err
+	case DebeziumEnvelope:
+		data, wrapped, err := encodeDebezium(opts, raw)
+		if err != nil || wrapped {
+			return data, map[string]string{ContentTypeHeader: ContentType(JsonFormat)}, err
+		}
+		// the change events that have no Debezium equivalent, such as the DDL events, are published as they are
+		data, err = marshalEvent(opts.format, raw)
+		return data, map[string]string{ContentTypeHeader: ContentType(opts.format)}, err
+	default:
+		return nil, nil, fmt.Errorf("unknown envelope %v", opts.envelope)
+	}
+}
+
+// newCloudEvent derives the CloudEvents attributes from the given change event.
+func newCloudEvent(event bson.Raw) *cloudEvent {
+	ce := &cloudEvent{}
+	ce.id, _ = event.Lookup("_id", "_data").StringValueOK()
+	ce.source = "mongodb://"
+	if dbName, ok := event.Lookup("ns", "db").StringValueOK(); ok {
+		ce.source += dbName
+	}
+	if collName, ok := event.Lookup("ns", "coll").StringValueOK(); ok {
+		ce.source += "/" + collName
+	}
+	operationType, _ := event.Lookup("operationType").StringValueOK()
+	ce.typ = cloudEventsTypePrefix + operationType
+	if eventTime, ok := eventTime(event); ok {
+		ce.time = eventTime.Format(time.RFC3339Nano)
+	}
+	ce.subject, _ = documentKey(event)
+	return ce
+}
+
+// eventTime returns the wall time of the given change event, or its cluster time if the wall time is not available
+// (before MongoDB 6.0).
+func eventTime(event bson.Raw) (time.Time, bool) {
+	if wallTime, ok := event.Lookup("wallTime").DateTimeOK(); ok {
+		return time.UnixMilli(wallTime).UTC(), true
+	}
+	if t, _, ok := event.Lookup("clusterTime").TimestampOK(); ok {
+		return time.Unix(int64(t), 0).UTC(), true
+	}
+	return time.Time{}, false
+}
+
+// documentKey returns the `_id` of the document key of the given change event as a string: the strings are returned
+// as they are, the other values as plain JSON (e.g. the object ids as hex strings).
+func documentKey(event bson.Raw) (string, bool) {
+	id, err := event.LookupErr("documentKey", "_id")
+	if err != nil {
+		return "", false
+	}
+	if s, ok := id.StringValueOK(); ok {
+		return s, true
+	}
+	if oid, ok := id.ObjectIDOK(); ok {
+		return oid.Hex(), true
+	}
+	var buf bytes.Buffer
+	if err = writeJSONValue(&buf, id); err != nil {
+		return "", false
+	}
+	return buf.String(), true
+}
@@ -0,0 +1,88 @@
+package mongo
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestEncodeEvent(t *testing.T) {
+	id, _ := primitive.ObjectIDFromHex("64b7f0c2a1b2c3d4e5f60718")
+	wallTime := time.Date(2023, 5, 9, 12, 0, 0, 0, time.UTC)
+	event, _ := bson.Marshal(bson.D{
+		{Key: "_id", Value: bson.D{{Key: "_data", Value: "8264"}}},
+		{Key: "operationType", Value: "insert"},
+		{Key: "clusterTime", Value: primitive.Timestamp{T: 1683633600, I: 1}},
+		{Key: "wallTime", Value: primitive.NewDateTimeFromTime(wallTime)},
+		{Key: "ns", Value: bson.D{{Key: "db", Value: "test-db"}, {Key: "coll", Value: "users"}}},
+		{Key: "documentKey", Value: bson.D{{Key: "_id", Value: id}}},
+	})
+
+	t.Run("should publish the change event as it is by default", func(t *testing.T) {
+		data, headers, err := encodeEvent(&watchOptions{format: JsonFormat}, bson.Raw(event))
+
+		require.NoError(t, err)
+		require.Equal(t, "application/json", headers["Content-Type"])
+		require.Contains(t, string(data), `"operationType":"insert"`)
+	})
+	t.Run("should set the cloud events attributes as headers in binary mode", func(t *testing.T) {
+		data, headers, err := encodeEvent(&watchOptions{envelope: CloudEventsBinaryEnvelope}, bson.Raw(event))
+
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{
+			"Content-Type":       "application/json; format=relaxedExtJSON",
+			"ce-specversion":     "1.0",
+			"ce-id":              "8264",
+			"ce-source":          "mongodb://test-db/users",
+			"ce-type":            "com.mongodb.changestream.insert",
+			"ce-time":            "2023-05-09T12:00:00Z",
+			"ce-subject":         "64b7f0c2a1b2c3d4e5f60718",
+			"Mongo-Db":           "test-db",
+			"Mongo-Coll":         "users",
+			"Mongo-Op":           "insert",
+			"Mongo-Document-Key": "64b7f0c2a1b2c3d4e5f60718",
+			"Mongo-Cluster-Time": "1683633600.1",
+		}, headers)
+		require.Contains(t, string(data), `"operationType":"insert"`)
+	})
+	t.Run("should wrap the change event in a cloud event in structured mode", func(t *testing.T) {
+		data, headers, err := encodeEvent(&watchOptions{envelope: CloudEventsStructuredEnvelope, format: JsonFormat},
+			bson.Raw(event))
+
+		require.NoError(t, err)
+		require.Equal(t, "application/cloudevents+json; charset=UTF-8", headers["Content-Type"])
+		require.Equal(t, `{"specversion":"1.0","id":"8264","source":"mongodb://test-db/users",`+
+			`"type":"com.mongodb.changestream.insert","time":"2023-05-09T12:00:00Z",`+
+			`"subject":"64b7f0c2a1b2c3d4e5f60718","datacontenttype":"application/json","data":{"_id":{"_data":"8264"},`+
+			`"operationType":"insert","clusterTime":{"t":1683633600,"i":1},"wallTime":"2023-05-09T12:00:00Z",`+
+			`"ns":{"db":"test-db","coll":"users"},"documentKey":{"_id":"64b7f0c2a1b2c3d4e5f60718"}}}`, string(data))
+	})
+	t.Run("should base64 encode the bson change event in structured mode", func(t *testing.T) {
+		data, _, err := encodeEvent(&watchOptions{envelope: CloudEventsStructuredEnvelope, format: BsonFormat},
+			bson.Raw(event))
+
+		require.NoError(t, err)
+		require.Contains(t, string(data), `"datacontenttype":"application/bson"`)
+		require.Contains(t, string(data), `"data_base64":"`+base64.StdEncoding.EncodeToString(event)+`"`)
+	})
+	t.Run("should use the cluster time if the wall time is not available", func(t *testing.T) {
+		snapshot := bson.D{
+			{Key: "_id", Value: bson.D{{Key: "_data", Value: "snapshot-1"}}},
+			{Key: "operationType", Value: "snapshot"},
+			{Key: "clusterTime", Value: primitive.Timestamp{T: 1683633600, I: 1}},
+			{Key: "ns", Value: bson.D{{Key: "db", Value: "test-db"}, {Key: "coll", Value: "users"}}},
+			{Key: "documentKey", Value: bson.D{{Key: "_id", Value: int32(42)}}},
+		}
+
+		_, headers, err := encodeEvent(&watchOptions{envelope: CloudEventsBinaryEnvelope}, snapshot)
+
+		require.NoError(t, err)
+		require.Equal(t, "2023-05-09T12:00:00Z", headers["ce-time"])
+		require.Equal(t, "42", headers["ce-subject"])
+		require.Equal(t, "com.mongodb.changestream.snapshot", headers["ce-type"])
+	})
+}
@@ -0,0 +1,171 @@
+package mongo
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+const (
+	// RelaxedExtJSONFormat serializes the change events as relaxed Extended JSON, which is the default format.
+	RelaxedExtJSONFormat = "relaxedExtJSON"
+	// CanonicalExtJSONFormat serializes the change events as canonical Extended JSON, so that every BSON type can be
+	// decoded exactly.
+	CanonicalExtJSONFormat = "canonicalExtJSON"
+	// BsonFormat publishes the change events as raw BSON documents.
+	BsonFormat = "bson"
+	// JsonFormat serializes the change events as plain JSON: the object ids are written as hex strings, the dates as
+	// RFC3339 strings, and the other BSON types as their closest JSON type.
+	JsonFormat = "json"
+
+	// ContentTypeHeader is the header of the published messages that records the format of their data.
+	ContentTypeHeader = "Content-Type"
+)
+
+// contentTypes maps each format to the content type of the published messages.
+var contentTypes = map[string]string{
+	RelaxedExtJSONFormat:   "application/json; format=relaxedExtJSON",
+	CanonicalExtJSONFormat: "application/json; format=canonicalExtJSON",
+	BsonFormat:             "application/bson",
+	JsonFormat:             "application/json",
+}
+
+// ContentType returns the content type of the messages serialized in the given format.
+func ContentType(format string) string {
+	return contentTypes[defaultIfEmpty(format, RelaxedExtJSONFormat)]
+}
+
+// marshalEvent serializes the given change event in the given format.
+func marshalEvent(format string, event any) ([]byte, error) {
+	switch format {
+	case "", RelaxedExtJSONFormat:
+		return bson.MarshalExtJSON(event, false, false)
+	case CanonicalExtJSONFormat:
+		return bson.MarshalExtJSON(event, true, false)
+	case BsonFormat:
+		return bson.Marshal(event)
+	case JsonFormat:
+		raw, err := bson.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err = writeJSONDocument(&buf, raw); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unknown format %v", format)
+	}
+}
+
+// loggable returns a readable representation of the given data, published with the given headers.
+func loggable(headers map[string]string, data []byte) string {
+	if headers[ContentTypeHeader] == ContentType(BsonFormat) {
+		return bson.Raw(data).String()
+	}
+	return string(data)
+}
+
+func writeJSONDocument(buf *bytes.Buffer, doc bson.Raw) error {
+	elems, err := doc.Elements()
+	if err != nil {
+		return err
+	}
+	buf.WriteByte('{')
+	for i, elem := range elems {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err = writeJSONString(buf, elem.Key()); err != nil {
+			return err
+		}
+		buf.WriteByte(':')
+		if err = writeJSONValue(buf, elem.Value()); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+func writeJSONArray(buf *bytes.Buffer, arr bson.Raw) error {
+	values, err := arr.Values()
+	if err != nil {
+		return err
+	}
+	buf.WriteByte('[')
+	for i, value := range values {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err = writeJSONValue(buf, value); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(']')
+	return nil
+}
+
+func writeJSONValue(buf *bytes.Buffer, value bson.RawValue) error {
+	switch value.Type {
+	case bsontype.EmbeddedDocument:
+		return writeJSONDocument(buf, value.Document())
+	case bsontype.Array:
+		return writeJSONArray(buf, value.Array())
+	case bsontype.String:
+		return writeJSONString(buf, value.StringValue())
+	case bsontype.Symbol:
+		return writeJSONString(buf, value.Symbol())
+	case bsontype.JavaScript:
+		return writeJSONString(buf, value.JavaScript())
+	case bsontype.ObjectID:
+		return writeJSONString(buf, value.ObjectID().Hex())
+	case bsontype.DateTime:
+		return writeJSONString(buf, time.UnixMilli(value.DateTime()).UTC().Format(time.RFC3339Nano))
+	case bsontype.Timestamp:
+		t, i := value.Timestamp()
+		_, _ = fmt.Fprintf(buf, `{"t":%d,"i":%d}`, t, i)
+	case bsontype.Decimal128:
+		return writeJSONString(buf, value.Decimal128().String())
+	case bsontype.Binary:
+		_, data := value.Binary()
+		return writeJSONString(buf, base64.StdEncoding.EncodeToString(data))
+	case bsontype.Regex:
+		pattern, opts := value.Regex()
+		return writeJSONString(buf, fmt.Sprintf("/%s/%s", pattern, opts))
+	case bsontype.Int32:
+		buf.WriteString(strconv.FormatInt(int64(value.Int32()), 10))
+	case bsontype.Int64:
+		buf.WriteString(strconv.FormatInt(value.Int64(), 10))
+	case bsontype.Double:
+		if f := value.Double(); math.IsNaN(f) || math.IsInf(f, 0) {
+			// JSON has no representation for these numbers
+			buf.WriteString("null")
+		} else {
+			buf.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+		}
+	case bsontype.Boolean:
+		buf.WriteString(strconv.FormatBool(value.Boolean()))
+	default:
+		// null, undefined, min key, max key and the deprecated types
+		buf.WriteString("null")
+	}
+	return nil
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	buf.Write(b)
+	return nil
+}
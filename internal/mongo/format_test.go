@@ -0,0 +1,92 @@
+package mongo
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMarshalEvent(t *testing.T) {
+	id, _ := primitive.ObjectIDFromHex("64b7f0c2a1b2c3d4e5f60718")
+	createdAt := time.Date(2023, 5, 9, 12, 0, 0, 0, time.UTC)
+	price, _ := primitive.ParseDecimal128("9.99")
+	event := bson.D{
+		{Key: "operationType", Value: "insert"},
+		{Key: "clusterTime", Value: primitive.Timestamp{T: 1683633600, I: 1}},
+		{Key: "fullDocument", Value: bson.D{
+			{Key: "_id", Value: id},
+			{Key: "createdAt", Value: primitive.NewDateTimeFromTime(createdAt)},
+			{Key: "qty", Value: int64(3)},
+			{Key: "price", Value: price},
+			{Key: "tags", Value: bson.A{"a", int32(1), true, nil}},
+			{Key: "ratio", Value: math.NaN()},
+			{Key: "data", Value: primitive.Binary{Data: []byte("hi")}},
+		}},
+	}
+
+	tests := []struct {
+		name   string
+		format string
+		want   string
+	}{
+		{
+			name:   "should marshal the event as relaxed extended json by default",
+			format: "",
+			want: `{"operationType":"insert","clusterTime":{"$timestamp":{"t":1683633600,"i":1}},"fullDocument":` +
+				`{"_id":{"$oid":"64b7f0c2a1b2c3d4e5f60718"},"createdAt":{"$date":"2023-05-09T12:00:00Z"},"qty":3,` +
+				`"price":{"$numberDecimal":"9.99"},"tags":["a",1,true,null],"ratio":{"$numberDouble":"NaN"},` +
+				`"data":{"$binary":{"base64":"aGk=","subType":"00"}}}}`,
+		},
+		{
+			name:   "should marshal the event as canonical extended json",
+			format: CanonicalExtJSONFormat,
+			want: `{"operationType":"insert","clusterTime":{"$timestamp":{"t":1683633600,"i":1}},"fullDocument":` +
+				`{"_id":{"$oid":"64b7f0c2a1b2c3d4e5f60718"},"createdAt":{"$date":{"$numberLong":"1683633600000"}},` +
+				`"qty":{"$numberLong":"3"},"price":{"$numberDecimal":"9.99"},` +
+				`"tags":["a",{"$numberInt":"1"},true,null],"ratio":{"$numberDouble":"NaN"},` +
+				`"data":{"$binary":{"base64":"aGk=","subType":"00"}}}}`,
+		},
+		{
+			name:   "should marshal the event as plain json",
+			format: JsonFormat,
+			want: `{"operationType":"insert","clusterTime":{"t":1683633600,"i":1},"fullDocument":` +
+				`{"_id":"64b7f0c2a1b2c3d4e5f60718","createdAt":"2023-05-09T12:00:00Z","qty":3,"price":"9.99",` +
+				`"tags":["a",1,true,null],"ratio":null,"data":"aGk="}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := marshalEvent(tt.format, event)
+
+			require.NoError(t, err)
+			require.Equal(t, tt.want, string(data))
+		})
+	}
+
+	t.Run("should marshal the event as raw bson", func(t *testing.T) {
+		data, err := marshalEvent(BsonFormat, event)
+
+		require.NoError(t, err)
+		var got bson.D
+		require.NoError(t, bson.Unmarshal(data, &got))
+		require.Equal(t, "insert", got[0].Value)
+		require.Equal(t, id, bson.Raw(data).Lookup("fullDocument", "_id").ObjectID())
+	})
+	t.Run("should return error cause the format is unknown", func(t *testing.T) {
+		_, err := marshalEvent("xml", event)
+
+		require.EqualError(t, err, "unknown format xml")
+	})
+}
+
+func TestContentType(t *testing.T) {
+	require.Equal(t, "application/json; format=relaxedExtJSON", ContentType(""))
+	require.Equal(t, "application/json; format=canonicalExtJSON", ContentType(CanonicalExtJSONFormat))
+	require.Equal(t, "application/bson", ContentType(BsonFormat))
+	require.Equal(t, "application/json", ContentType(JsonFormat))
+}
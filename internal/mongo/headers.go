@@ -0,0 +1,48 @@
+package mongo
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	// DbHeader is the header of the published messages that holds the database of the change event.
+	DbHeader = "Mongo-Db"
+	// CollHeader is the header of the published messages that holds the collection of the change event.
+	CollHeader = "Mongo-Coll"
+	// OpHeader is the header of the published messages that holds the operation type of the change event.
+	OpHeader = "Mongo-Op"
+	// DocumentKeyHeader is the header of the published messages that holds the `_id` of the document key of the
+	// change event.
+	DocumentKeyHeader = "Mongo-Document-Key"
+	// ClusterTimeHeader is the header of the published messages that holds the cluster time of the change event, as
+	// `<seconds>.<increment>`.
+	ClusterTimeHeader = "Mongo-Cluster-Time"
+	// TxnNumberHeader is the header of the published messages that holds the transaction number of the change event,
+	// if it is part of a transaction.
+	TxnNumberHeader = "Mongo-Txn-Number"
+)
+
+// addMetadataHeaders adds the headers describing the given change event, so that consumers can route the messages
+// without decoding them. The headers whose value is not found in the change event are not added.
+func addMetadataHeaders(headers map[string]string, event bson.Raw) {
+	if dbName, ok := event.Lookup("ns", "db").StringValueOK(); ok {
+		headers[DbHeader] = dbName
+	}
+	if collName, ok := event.Lookup("ns", "coll").StringValueOK(); ok {
+		headers[CollHeader] = collName
+	}
+	if operationType, ok := event.Lookup("operationType").StringValueOK(); ok {
+		headers[OpHeader] = operationType
+	}
+	if key, ok := documentKey(event); ok {
+		headers[DocumentKeyHeader] = key
+	}
+	if t, i, ok := event.Lookup("clusterTime").TimestampOK(); ok {
+		headers[ClusterTimeHeader] = fmt.Sprintf("%d.%d", t, i)
+	}
+	if txnNumber, ok := event.Lookup("txnNumber").AsInt64OK(); ok {
+		headers[TxnNumberHeader] = fmt.Sprint(txnNumber)
+	}
+}
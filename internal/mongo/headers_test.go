@@ -0,0 +1,45 @@
+package mongo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAddMetadataHeaders(t *testing.T) {
+	t.Run("should add the headers describing the change event", func(t *testing.T) {
+		event, _ := bson.Marshal(bson.D{
+			{Key: "_id", Value: bson.D{{Key: "_data", Value: "8264"}}},
+			{Key: "operationType", Value: "update"},
+			{Key: "clusterTime", Value: primitive.Timestamp{T: 1683633600, I: 2}},
+			{Key: "ns", Value: bson.D{{Key: "db", Value: "test-db"}, {Key: "coll", Value: "orders"}}},
+			{Key: "documentKey", Value: bson.D{{Key: "_id", Value: int64(42)}}},
+			{Key: "txnNumber", Value: int64(7)},
+		})
+		headers := map[string]string{}
+
+		addMetadataHeaders(headers, event)
+
+		require.Equal(t, map[string]string{
+			"Mongo-Db":           "test-db",
+			"Mongo-Coll":         "orders",
+			"Mongo-Op":           "update",
+			"Mongo-Document-Key": "42",
+			"Mongo-Cluster-Time": "1683633600.2",
+			"Mongo-Txn-Number":   "7",
+		}, headers)
+	})
+	t.Run("should not add the headers whose value is missing", func(t *testing.T) {
+		event, _ := bson.Marshal(bson.D{
+			{Key: "operationType", Value: "dropDatabase"},
+			{Key: "ns", Value: bson.D{{Key: "db", Value: "test-db"}}},
+		})
+		headers := map[string]string{}
+
+		addMetadataHeaders(headers, event)
+
+		require.Equal(t, map[string]string{"Mongo-Db": "test-db", "Mongo-Op": "dropDatabase"}, headers)
+	})
+}
@@ -0,0 +1,79 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// FailOnHistoryLost stops watching, as the change stream cannot be resumed.
+	FailOnHistoryLost = "fail"
+	// ResumeFromNowOnHistoryLost reopens the change stream from the current time, skipping the lost change events.
+	ResumeFromNowOnHistoryLost = "resumeFromNow"
+	// ResnapshotOnHistoryLost publishes a new snapshot of the watched collection, then reopens the change stream from
+	// the time the snapshot started. It behaves like ResumeFromNowOnHistoryLost if a database or a deployment is
+	// watched.
+	ResnapshotOnHistoryLost = "resnapshot"
+
+	gapOperationType = "gap"
+	historyLostCode  = 286
+)
+
+// isHistoryLost tells whether the given error has been returned because the position where the change stream should
+// be resumed from is no longer in the oplog.
+func isHistoryLost(err error) bool {
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) && serverErr.HasErrorCode(historyLostCode)
+}
+
+// publishGap publishes a gap event on the control subject of the watched namespace, so that consumers know that the
+// change events after the given lost resume token may have never been published.
+func (c *DefaultClient) publishGap(ctx context.Context, opts *watchOptions, lostResumeToken string,
+	historyLostErr error) error {
+	now := time.Now()
+	msgId := fmt.Sprintf("%s-%d", gapOperationType, now.UnixNano())
+	if lostResumeToken != "" {
+		// the gap is published once per lost resume token, even if the connector is restarted
+		msgId = fmt.Sprintf("%s-%s", gapOperationType, lostResumeToken)
+	}
+
+	data, headers, err := encodeEvent(opts, bson.D{
+		{Key: "_id", Value: bson.D{{Key: "_data", Value: msgId}}},
+		{Key: "operationType", Value: gapOperationType},
+		{Key: "reason", Value: "historyLost"},
+		{Key: "onHistoryLost", Value: defaultIfEmpty(opts.onHistoryLost, FailOnHistoryLost)},
+		{Key: "lostResumeToken", Value: lostResumeToken},
+		{Key: "error", Value: historyLostErr.Error()},
+		{Key: "wallTime", Value: now},
+		{Key: "ns", Value: bson.D{{Key: "db", Value: opts.ns.DbName}, {Key: "coll", Value: opts.ns.CollName}}},
+	})
+	if err != nil {
+		return fmt.Errorf("could not marshal gap event from bson: %v", err)
+	}
+	c.logger.Warn("mongodb change stream history lost", append(opts.logArgs, "gapEvent", loggable(headers, data))...)
+
+	streamName := opts.streamName(opts.ns.DbName, opts.ns.CollName)
+	event := &ChangeEvent{
+		StreamName: streamName,
+		Subj:       fmt.Sprintf("%s.%s", streamName, gapOperationType),
+		MsgId:      msgId,
+		Data:       data,
+		Headers:    headers,
+	}
+	if err = opts.changeEventHandler(ctx, event); err != nil {
+		return fmt.Errorf("could not publish gap event: %v", err)
+	}
+	return nil
+}
+
+func defaultIfEmpty(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
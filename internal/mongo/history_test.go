@@ -0,0 +1,50 @@
+package mongo
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestIsHistoryLost(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "should return false if there is no error",
+			err:  nil,
+			want: false,
+		},
+		{
+			name: "should return false if the error is not a server error",
+			err:  errors.New("connection refused"),
+			want: false,
+		},
+		{
+			name: "should return false if the server error has another code",
+			err:  mongo.CommandError{Code: 11000},
+			want: false,
+		},
+		{
+			name: "should return true if the server error is change stream history lost",
+			err:  mongo.CommandError{Code: 286, Name: "ChangeStreamHistoryLost"},
+			want: true,
+		},
+		{
+			name: "should return true if the wrapped server error is change stream history lost",
+			err:  fmt.Errorf("could not watch: %w", mongo.CommandError{Code: 286}),
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, isHistoryLost(tt.err))
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package mongo
+
+import (
+	"context"
+)
+
+// AsyncChangeEventHandler starts publishing the given change event, then calls acked exactly once, with the error
+// returned by the publication, if any. acked must be called even if the context is done, e.g. when the publication
+// times out.
+type AsyncChangeEventHandler func(ctx context.Context, event *ChangeEvent, acked func(err error)) error
+
+// pendingEvent is a change event whose publication has not been acknowledged yet.
+type pendingEvent struct {
+	token string
+	acked chan error
+}
+
+// inflightWindow keeps track of the change events published asynchronously, in the order they have been received, so
+// that the resume token of a change event is checkpointed only once it has been acknowledged together with all the
+// previous ones. At most size change events are published at once.
+type inflightWindow struct {
+	size    int
+	pending []*pendingEvent
+	// err is the first publication error: no resume token is checkpointed after it.
+	err error
+}
+
+func newInflightWindow(size int) *inflightWindow {
+	return &inflightWindow{size: size}
+}
+
+// publish starts publishing the given change event with the given handler.
+func (w *inflightWindow) publish(ctx context.Context, handler AsyncChangeEventHandler, event *ChangeEvent,
+	token string) error {
+	pending := &pendingEvent{token: token, acked: make(chan error, 1)}
+	if err := handler(ctx, event, func(err error) { pending.acked <- err }); err != nil {
+		return err
+	}
+	w.pending = append(w.pending, pending)
+	return nil
+}
+
+// settle checkpoints the resume tokens of the acknowledged change events, from the oldest one, until an unacknowledged
+// change event is found. If more than max change events are still pending, it waits for the oldest ones to be
+// acknowledged. The returned error is the first publication error: the following change events are still waited for,
+// but their resume tokens are never checkpointed, so that they are published again, together with the failed one,
+// when resuming after the last checkpointed token.
+func (w *inflightWindow) settle(ctx context.Context, cp *checkpointer, retrier *retrier, max int) error {
+	for len(w.pending) > 0 {
+		head := w.pending[0]
+		var err error
+		if len(w.pending) > max {
+			err = <-head.acked
+		} else {
+			select {
+			case err = <-head.acked:
+			default:
+				return w.err
+			}
+		}
+		w.pending = w.pending[1:]
+		if w.err != nil {
+			continue
+		}
+		if err != nil {
+			w.err = err
+			return err
+		}
+		retrier.reset()
+		cp.track(head.token, true)
+		if err = cp.flushIfDue(ctx, false); err != nil {
+			return err
+		}
+	}
+	return w.err
+}
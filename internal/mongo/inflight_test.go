@@ -0,0 +1,81 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInflightWindow_settle(t *testing.T) {
+	ctx := context.Background()
+	newTest := func(t *testing.T) (*inflightWindow, *checkpointer, *KeyValueCheckpointStore, map[string]func(err error)) {
+		store, _ := NewFileCheckpointStore(t.TempDir())
+		acks := map[string]func(err error){}
+		handler := func(_ context.Context, event *ChangeEvent, acked func(err error)) error {
+			acks[event.MsgId] = acked
+			return nil
+		}
+		window := newInflightWindow(3)
+		for _, token := range []string{"token1", "token2", "token3"} {
+			require.NoError(t, window.publish(ctx, handler, &ChangeEvent{MsgId: token}, token))
+		}
+		return window, newCheckpointer(store, "coll1", CheckpointPolicy{}), store, acks
+	}
+	retrier := newRetrier(RetryPolicy{}, nil)
+
+	t.Run("should checkpoint up to the highest contiguously acknowledged change event", func(t *testing.T) {
+		window, cp, store, acks := newTest(t)
+		acks["token1"](nil)
+		acks["token3"](nil)
+
+		err := window.settle(ctx, cp, retrier, window.size-1)
+
+		require.NoError(t, err)
+		require.Len(t, window.pending, 2)
+		checkpoint, _ := store.Load(ctx, "coll1")
+		require.Equal(t, "token1", checkpoint.ResumeToken)
+	})
+	t.Run("should wait for all the pending change events when draining", func(t *testing.T) {
+		window, cp, store, acks := newTest(t)
+		acks["token3"](nil)
+		acks["token1"](nil)
+		go acks["token2"](nil)
+
+		err := window.settle(ctx, cp, retrier, 0)
+
+		require.NoError(t, err)
+		require.Empty(t, window.pending)
+		checkpoint, _ := store.Load(ctx, "coll1")
+		require.Equal(t, "token3", checkpoint.ResumeToken)
+	})
+	t.Run("should not checkpoint past a failed change event", func(t *testing.T) {
+		window, cp, store, acks := newTest(t)
+		acks["token1"](nil)
+		acks["token2"](errors.New("nats: timeout"))
+		acks["token3"](nil)
+
+		err := window.settle(ctx, cp, retrier, 0)
+
+		require.EqualError(t, err, "nats: timeout")
+		checkpoint, _ := store.Load(ctx, "coll1")
+		require.Equal(t, "token1", checkpoint.ResumeToken)
+	})
+	t.Run("should not checkpoint past a failed change event when draining after the failure", func(t *testing.T) {
+		window, cp, store, acks := newTest(t)
+		acks["token1"](nil)
+		acks["token2"](errors.New("nats: timeout"))
+		acks["token3"](nil)
+
+		err := window.settle(ctx, cp, retrier, window.size-1)
+		require.EqualError(t, err, "nats: timeout")
+		err = window.settle(ctx, cp, retrier, 0)
+		require.EqualError(t, err, "nats: timeout")
+		require.NoError(t, cp.flush(ctx))
+
+		require.Empty(t, window.pending)
+		checkpoint, _ := store.Load(ctx, "coll1")
+		require.Equal(t, "token1", checkpoint.ResumeToken)
+	})
+}
@@ -0,0 +1,206 @@
+package mongo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	// RedactDrop removes the field.
+	RedactDrop = "drop"
+	// RedactReplace replaces the value of the field with a constant.
+	RedactReplace = "replace"
+	// RedactHash replaces the value of the field with its salted SHA-256 hash.
+	RedactHash = "hash"
+	// RedactKeepLast masks all the characters of the value of the field but the last ones.
+	RedactKeepLast = "keepLast"
+
+	redactionMask = "*"
+)
+
+// RedactionRule tells how the fields matching the given path must be redacted before being published or logged.
+// The path is made of dot separated field names, each of them can be a glob pattern (e.g. `contact.phone*`), and
+// `**` matches any number of fields (e.g. `**.ssn`). Array elements have the same path as their array.
+// Value is only used by RedactReplace, Salt is only used by RedactHash, KeepLast is only used by RedactKeepLast.
+type RedactionRule struct {
+	Path     string
+	Action   string
+	Value    string
+	Salt     string
+	KeepLast int
+}
+
+func (r *RedactionRule) apply(value any) any {
+	if arr, ok := value.(bson.A); ok {
+		redacted := make(bson.A, 0, len(arr))
+		for _, elem := range arr {
+			redacted = append(redacted, r.apply(elem))
+		}
+		return redacted
+	}
+
+	switch r.Action {
+	case RedactReplace:
+		return r.Value
+	case RedactHash:
+		hash := sha256.Sum256([]byte(r.Salt + stringValue(value)))
+		return hex.EncodeToString(hash[:])
+	case RedactKeepLast:
+		chars := []rune(stringValue(value))
+		if len(chars) <= r.KeepLast {
+			// short values are masked entirely, so that they are never published in clear
+			return strings.Repeat(redactionMask, len(chars))
+		}
+		return strings.Repeat(redactionMask, len(chars)-r.KeepLast) + string(chars[len(chars)-r.KeepLast:])
+	}
+	return value
+}
+
+func stringValue(value any) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return fmt.Sprint(value)
+}
+
+// redactor redacts the fields of the documents contained by the change events, according to its rules.
+type redactor struct {
+	rules    []RedactionRule
+	patterns [][]string
+}
+
+// newRedactor returns a redactor for the given rules, or nil if there are none.
+func newRedactor(rules []RedactionRule) *redactor {
+	if len(rules) == 0 {
+		return nil
+	}
+	r := &redactor{rules: rules}
+	for _, rule := range rules {
+		r.patterns = append(r.patterns, strings.Split(rule.Path, "."))
+	}
+	return r
+}
+
+// redactEvent returns the given change event, with the fields of its `fullDocument`, `fullDocumentBeforeChange` and
+// `updateDescription.updatedFields` documents redacted.
+func (r *redactor) redactEvent(event bson.Raw) (bson.D, error) {
+	doc := bson.D{}
+	if err := bson.Unmarshal(event, &doc); err != nil {
+		return nil, fmt.Errorf("could not unmarshal mongo change event from bson: %v", err)
+	}
+	for i, elem := range doc {
+		switch elem.Key {
+		case "fullDocument", "fullDocumentBeforeChange":
+			if fullDoc, ok := elem.Value.(bson.D); ok {
+				doc[i].Value = r.redact(nil, fullDoc)
+			}
+		case "updateDescription":
+			updateDesc, _ := elem.Value.(bson.D)
+			for j, field := range updateDesc {
+				if updatedFields, ok := field.Value.(bson.D); ok && field.Key == "updatedFields" {
+					updateDesc[j].Value = r.redact(nil, updatedFields)
+				}
+			}
+		}
+	}
+	return doc, nil
+}
+
+// redactDocument returns the given document, with its fields redacted.
+func (r *redactor) redactDocument(document bson.Raw) (bson.D, error) {
+	doc := bson.D{}
+	if err := bson.Unmarshal(document, &doc); err != nil {
+		return nil, fmt.Errorf("could not unmarshal mongo document from bson: %v", err)
+	}
+	return r.redact(nil, doc), nil
+}
+
+func (r *redactor) redact(parentPath []string, doc bson.D) bson.D {
+	redacted := make(bson.D, 0, len(doc))
+	for _, elem := range doc {
+		fieldPath := appendFieldPath(parentPath, elem.Key)
+		if rule := r.matchDotted(len(parentPath), fieldPath); rule != nil {
+			if rule.Action == RedactDrop {
+				continue
+			}
+			elem.Value = rule.apply(elem.Value)
+		} else {
+			elem.Value = r.redactValue(fieldPath, elem.Value)
+		}
+		redacted = append(redacted, elem)
+	}
+	return redacted
+}
+
+func (r *redactor) redactValue(fieldPath []string, value any) any {
+	switch v := value.(type) {
+	case bson.D:
+		return r.redact(fieldPath, v)
+	case bson.A:
+		redacted := make(bson.A, 0, len(v))
+		for _, elem := range v {
+			redacted = append(redacted, r.redactValue(fieldPath, elem))
+		}
+		return redacted
+	}
+	return value
+}
+
+// appendFieldPath appends the given key to the parent path. The key can itself be a dotted path, as the ones of the
+// updated fields, whose array indexes are skipped so that array elements have the same path as their array.
+func appendFieldPath(parentPath []string, key string) []string {
+	fieldPath := slices.Clone(parentPath)
+	for _, name := range strings.Split(key, ".") {
+		if _, err := strconv.Atoi(name); err == nil && len(fieldPath) > 0 {
+			continue
+		}
+		fieldPath = append(fieldPath, name)
+	}
+	return fieldPath
+}
+
+func (r *redactor) match(fieldPath []string) *RedactionRule {
+	for i, pattern := range r.patterns {
+		if matchFieldPath(pattern, fieldPath) {
+			return &r.rules[i]
+		}
+	}
+	return nil
+}
+
+// matchDotted returns the rule matching the given field path or, if the field has a dotted key (e.g. an updated field
+// such as `contact.phone`), any of its parents within that key, so that a rule on a parent also redacts its children.
+func (r *redactor) matchDotted(parentLen int, fieldPath []string) *RedactionRule {
+	for i := parentLen + 1; i < len(fieldPath); i++ {
+		if rule := r.match(fieldPath[:i]); rule != nil {
+			return rule
+		}
+	}
+	return r.match(fieldPath)
+}
+
+func matchFieldPath(pattern, fieldPath []string) bool {
+	if len(pattern) == 0 {
+		return len(fieldPath) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(fieldPath); i++ {
+			if matchFieldPath(pattern[1:], fieldPath[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(fieldPath) == 0 {
+		return false
+	}
+	matched, _ := path.Match(pattern[0], fieldPath[0])
+	return matched && matchFieldPath(pattern[1:], fieldPath[1:])
+}
@@ -0,0 +1,138 @@
+package mongo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestRedactor_redactEvent(t *testing.T) {
+	hash := sha256.Sum256([]byte("salt" + "john@example.com"))
+	hashedEmail := hex.EncodeToString(hash[:])
+
+	tests := []struct {
+		name  string
+		rules []RedactionRule
+		event bson.D
+		want  bson.D
+	}{
+		{
+			name:  "should drop the matching fields",
+			rules: []RedactionRule{{Path: "nationalId", Action: RedactDrop}},
+			event: bson.D{
+				{Key: "operationType", Value: "insert"},
+				{Key: "fullDocument", Value: bson.D{{Key: "name", Value: "john"}, {Key: "nationalId", Value: "X123"}}},
+			},
+			want: bson.D{
+				{Key: "operationType", Value: "insert"},
+				{Key: "fullDocument", Value: bson.D{{Key: "name", Value: "john"}}},
+			},
+		},
+		{
+			name:  "should replace the matching fields with a constant",
+			rules: []RedactionRule{{Path: "contact.*", Action: RedactReplace, Value: "REDACTED"}},
+			event: bson.D{
+				{Key: "fullDocumentBeforeChange", Value: bson.D{{Key: "contact", Value: bson.D{
+					{Key: "email", Value: "john@example.com"}, {Key: "phone", Value: "5551234"}}}}},
+			},
+			want: bson.D{
+				{Key: "fullDocumentBeforeChange", Value: bson.D{{Key: "contact", Value: bson.D{
+					{Key: "email", Value: "REDACTED"}, {Key: "phone", Value: "REDACTED"}}}}},
+			},
+		},
+		{
+			name:  "should hash the matching fields with the salt",
+			rules: []RedactionRule{{Path: "**.email", Action: RedactHash, Salt: "salt"}},
+			event: bson.D{
+				{Key: "fullDocument", Value: bson.D{{Key: "contacts", Value: bson.A{
+					bson.D{{Key: "email", Value: "john@example.com"}}}}}},
+			},
+			want: bson.D{
+				{Key: "fullDocument", Value: bson.D{{Key: "contacts", Value: bson.A{
+					bson.D{{Key: "email", Value: hashedEmail}}}}}},
+			},
+		},
+		{
+			name:  "should keep the last characters of the matching fields",
+			rules: []RedactionRule{{Path: "phone*", Action: RedactKeepLast, KeepLast: 4}},
+			event: bson.D{
+				{Key: "fullDocument", Value: bson.D{{Key: "phone", Value: "5551234"}, {Key: "phoneExt", Value: "12"}}},
+			},
+			want: bson.D{
+				{Key: "fullDocument", Value: bson.D{{Key: "phone", Value: "***1234"}, {Key: "phoneExt", Value: "**"}}},
+			},
+		},
+		{
+			name:  "should redact the updated fields by their dotted path",
+			rules: []RedactionRule{{Path: "contacts.email", Action: RedactReplace, Value: "REDACTED"}},
+			event: bson.D{
+				{Key: "updateDescription", Value: bson.D{
+					{Key: "updatedFields", Value: bson.D{
+						{Key: "contacts.0.email", Value: "john@example.com"},
+						{Key: "contacts.1", Value: bson.D{{Key: "email", Value: "jane@example.com"}}},
+					}},
+					{Key: "removedFields", Value: bson.A{"email"}},
+				}},
+			},
+			want: bson.D{
+				{Key: "updateDescription", Value: bson.D{
+					{Key: "updatedFields", Value: bson.D{
+						{Key: "contacts.0.email", Value: "REDACTED"},
+						{Key: "contacts.1", Value: bson.D{{Key: "email", Value: "REDACTED"}}},
+					}},
+					{Key: "removedFields", Value: bson.A{"email"}},
+				}},
+			},
+		},
+		{
+			name: "should redact the updated fields whose dotted path is within a matching field",
+			rules: []RedactionRule{
+				{Path: "contact", Action: RedactDrop},
+				{Path: "billing", Action: RedactKeepLast, KeepLast: 4},
+			},
+			event: bson.D{
+				{Key: "updateDescription", Value: bson.D{
+					{Key: "updatedFields", Value: bson.D{
+						{Key: "contact.phone", Value: "5551234"},
+						{Key: "billing.card.number", Value: "4111111111111111"},
+						{Key: "name", Value: "john"},
+					}},
+				}},
+			},
+			want: bson.D{
+				{Key: "updateDescription", Value: bson.D{
+					{Key: "updatedFields", Value: bson.D{
+						{Key: "billing.card.number", Value: "************1111"},
+						{Key: "name", Value: "john"},
+					}},
+				}},
+			},
+		},
+		{
+			name:  "should not redact the fields outside the documents",
+			rules: []RedactionRule{{Path: "**", Action: RedactDrop}},
+			event: bson.D{
+				{Key: "operationType", Value: "delete"},
+				{Key: "documentKey", Value: bson.D{{Key: "email", Value: "john@example.com"}}},
+			},
+			want: bson.D{
+				{Key: "operationType", Value: "delete"},
+				{Key: "documentKey", Value: bson.D{{Key: "email", Value: "john@example.com"}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, _ := bson.Marshal(tt.event)
+
+			redacted, err := newRedactor(tt.rules).redactEvent(event)
+
+			require.NoError(t, err)
+			require.Equal(t, tt.want, redacted)
+		})
+	}
+}
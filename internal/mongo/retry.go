@@ -0,0 +1,141 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultRetryInitialDelay = 500 * time.Millisecond
+	defaultRetryMaxDelay     = 30 * time.Second
+)
+
+// ErrPermanent marks the errors that will not go away by retrying, such as a change event that is too big to be
+// published. The change event handlers can wrap their errors with it by means of Permanent.
+var ErrPermanent = errors.New("permanent error")
+
+// Permanent marks the given error as permanent.
+func Permanent(err error) error {
+	return fmt.Errorf("%w: %w", ErrPermanent, err)
+}
+
+// transientServerErrorCodes contains the codes of the server errors that are expected to go away, for example once a
+// new primary has been elected.
+var transientServerErrorCodes = []int{
+	6,     // HostUnreachable
+	7,     // HostNotFound
+	89,    // NetworkTimeout
+	91,    // ShutdownInProgress
+	189,   // PrimarySteppedDown
+	262,   // ExceededTimeLimit
+	9001,  // SocketException
+	10107, // NotWritablePrimary
+	11600, // InterruptedAtShutdown
+	11602, // InterruptedDueToReplStateChange
+	13435, // NotPrimaryNoSecondaryOk
+	13436, // NotPrimaryOrSecondary
+}
+
+// isPermanent tells whether the given error must not be retried. The errors are transient unless they are marked as
+// permanent, or they are server errors that are neither network errors nor labeled as retryable by the server.
+func isPermanent(err error) bool {
+	if errors.Is(err, ErrPermanent) {
+		return true
+	}
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) || mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return false
+	}
+	for _, label := range []string{"ResumableChangeStreamError", "RetryableWriteError", "TransientTransactionError"} {
+		if serverErr.HasErrorLabel(label) {
+			return false
+		}
+	}
+	for _, code := range transientServerErrorCodes {
+		if serverErr.HasErrorCode(code) {
+			return false
+		}
+	}
+	return true
+}
+
+// RetryPolicy tells how long to wait before reopening a change stream that failed with a transient error: the delay
+// starts at InitialDelay, doubles after each consecutive failure up to MaxDelay, and is randomized to avoid retrying
+// in lockstep. If MaxAttempts is greater than 0, the watcher fails after as many consecutive failures.
+type RetryPolicy struct {
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	MaxAttempts  int
+}
+
+// retrier waits between the attempts to reopen a failed change stream, according to a RetryPolicy.
+type retrier struct {
+	policy   RetryPolicy
+	logger   *slog.Logger
+	attempts int
+}
+
+func newRetrier(policy RetryPolicy, logger *slog.Logger) *retrier {
+	if policy.InitialDelay <= 0 {
+		policy.InitialDelay = defaultRetryInitialDelay
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = defaultRetryMaxDelay
+	}
+	if policy.MaxDelay < policy.InitialDelay {
+		policy.MaxDelay = policy.InitialDelay
+	}
+	return &retrier{policy: policy, logger: logger}
+}
+
+// backoff waits before retrying after the given error. It returns an error if the error is permanent, in which case
+// it is marked as such, if the maximum number of attempts has been reached, or if the context is done.
+func (r *retrier) backoff(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if errors.Is(err, ErrPermanent) {
+		return err
+	}
+	if isPermanent(err) {
+		return Permanent(err)
+	}
+	r.attempts++
+	if r.policy.MaxAttempts > 0 && r.attempts >= r.policy.MaxAttempts {
+		return fmt.Errorf("giving up after %d attempts: %w", r.attempts, err)
+	}
+
+	delay := r.delay()
+	r.logger.Warn("retrying mongodb change stream", "attempt", r.attempts, "delay", delay, "err", err)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// delay returns the delay before the next attempt: half of it is fixed, the other half is random.
+func (r *retrier) delay() time.Duration {
+	delay := r.policy.InitialDelay
+	for i := 1; i < r.attempts && delay < r.policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > r.policy.MaxDelay {
+		delay = r.policy.MaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// reset is called once the change stream is working again, so that the delay starts over at the next failure.
+func (r *retrier) reset() {
+	r.attempts = 0
+}
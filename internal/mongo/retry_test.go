@@ -0,0 +1,113 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestIsPermanent(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "should return false if the error is not a server error",
+			err:  errors.New("connection refused"),
+			want: false,
+		},
+		{
+			name: "should return true if the error is marked as permanent",
+			err:  fmt.Errorf("could not publish: %w", Permanent(errors.New("maximum payload exceeded"))),
+			want: true,
+		},
+		{
+			name: "should return false if the server error is labeled as resumable",
+			err:  mongo.CommandError{Code: 43, Labels: []string{"ResumableChangeStreamError"}},
+			want: false,
+		},
+		{
+			name: "should return false if the server error is caused by an election",
+			err:  fmt.Errorf("could not watch: %w", mongo.CommandError{Code: 189, Name: "PrimarySteppedDown"}),
+			want: false,
+		},
+		{
+			name: "should return true if the server error cannot be retried",
+			err:  mongo.CommandError{Code: 13, Name: "Unauthorized"},
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, isPermanent(tt.err))
+		})
+	}
+}
+
+func TestRetrier_delay(t *testing.T) {
+	t.Run("should double the delay up to the max delay", func(t *testing.T) {
+		r := newRetrier(RetryPolicy{InitialDelay: time.Second, MaxDelay: 5 * time.Second}, slog.Default())
+
+		for attempts, want := range []time.Duration{time.Second, time.Second, 2 * time.Second, 4 * time.Second,
+			5 * time.Second, 5 * time.Second} {
+			r.attempts = attempts
+			delay := r.delay()
+			require.GreaterOrEqual(t, delay, want/2)
+			require.LessOrEqual(t, delay, want)
+		}
+	})
+	t.Run("should use the default delays if they are not set", func(t *testing.T) {
+		r := newRetrier(RetryPolicy{}, slog.Default())
+
+		require.Equal(t, RetryPolicy{InitialDelay: defaultRetryInitialDelay, MaxDelay: defaultRetryMaxDelay}, r.policy)
+	})
+}
+
+func TestRetrier_backoff(t *testing.T) {
+	t.Run("should wait and retry transient errors", func(t *testing.T) {
+		r := newRetrier(RetryPolicy{InitialDelay: time.Millisecond}, slog.Default())
+
+		require.NoError(t, r.backoff(context.Background(), errors.New("connection refused")))
+		require.NoError(t, r.backoff(context.Background(), errors.New("connection refused")))
+		require.Equal(t, 2, r.attempts)
+
+		r.reset()
+		require.Equal(t, 0, r.attempts)
+	})
+	t.Run("should return permanent errors without retrying", func(t *testing.T) {
+		r := newRetrier(RetryPolicy{InitialDelay: time.Millisecond}, slog.Default())
+		err := Permanent(errors.New("maximum payload exceeded"))
+
+		require.ErrorIs(t, r.backoff(context.Background(), err), ErrPermanent)
+		require.Equal(t, 0, r.attempts)
+	})
+	t.Run("should mark the server errors that cannot be retried as permanent", func(t *testing.T) {
+		r := newRetrier(RetryPolicy{InitialDelay: time.Millisecond}, slog.Default())
+		err := mongo.CommandError{Code: 286, Name: "ChangeStreamHistoryLost"}
+
+		require.ErrorIs(t, r.backoff(context.Background(), err), ErrPermanent)
+		require.Equal(t, 0, r.attempts)
+	})
+	t.Run("should give up after the max attempts", func(t *testing.T) {
+		r := newRetrier(RetryPolicy{InitialDelay: time.Millisecond, MaxAttempts: 2}, slog.Default())
+		err := errors.New("connection refused")
+
+		require.NoError(t, r.backoff(context.Background(), err))
+		require.EqualError(t, r.backoff(context.Background(), err), "giving up after 2 attempts: connection refused")
+	})
+	t.Run("should stop waiting when the context is done", func(t *testing.T) {
+		r := newRetrier(RetryPolicy{InitialDelay: time.Hour}, slog.Default())
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		require.ErrorIs(t, r.backoff(ctx, errors.New("connection refused")), context.DeadlineExceeded)
+	})
+}
@@ -0,0 +1,194 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	snapshotOperationType = "snapshot"
+
+	// snapshotProgressInterval is the number of published documents after which the snapshot progress is stored.
+	snapshotProgressInterval = 1000
+)
+
+// SnapshotProgress tracks the initial snapshot of a watched collection, so that it can be continued after a restart.
+type SnapshotProgress struct {
+	// ClusterTime is the cluster time recorded right before the snapshot started.
+	ClusterTime primitive.Timestamp `bson:"clusterTime"`
+	// LastId is the id of the last published document, documents are scanned in ascending id order.
+	LastId *bson.RawValue `bson:"lastId,omitempty"`
+	// Done tells whether all the documents of the collection have been published.
+	Done bool `bson:"done"`
+}
+
+// shouldSnapshot tells whether the watched collection must be snapshotted, or its snapshot continued, given the last
+// stored checkpoint. Once the history is lost, a new snapshot is only taken if the policy is to resnapshot, even if the
+// collection is configured to be snapshotted.
+func shouldSnapshot(opts *watchOptions, checkpoint *Checkpoint, historyLost bool) bool {
+	if opts.snapshotColl == nil {
+		return false
+	}
+	if historyLost {
+		return opts.onHistoryLost == ResnapshotOnHistoryLost
+	}
+	return opts.snapshot || checkpoint.Snapshot != nil
+}
+
+// snapshot publishes every document of the watched collection as a snapshot change event, starting after the last
+// published document of the given progress, if any. The progress is stored in the resume tokens collection after
+// each batch of published documents, and once the snapshot is done. The returned progress is nil only if nothing
+// could be stored.
+func (c *DefaultClient) snapshot(ctx context.Context, store CheckpointStore, progress *SnapshotProgress,
+	opts *watchOptions) (*SnapshotProgress, error) {
+	coll := opts.snapshotColl
+
+	if progress == nil {
+		clusterTime, err := c.clusterTime(ctx, coll.Database())
+		if err != nil {
+			return nil, err
+		}
+		progress = &SnapshotProgress{ClusterTime: clusterTime}
+		if err = store.Save(ctx, opts.checkpointKey, &Checkpoint{Snapshot: progress}); err != nil {
+			return nil, err
+		}
+		c.logger.Info("starting mongodb collection snapshot", "collName", coll.Name(), "clusterTime", clusterTime)
+	} else {
+		c.logger.Info("continuing mongodb collection snapshot", "collName", coll.Name(),
+			"clusterTime", progress.ClusterTime, "lastId", progress.LastId)
+	}
+
+	cur, err := coll.Find(ctx, bson.D{}, snapshotFindOptions(progress.LastId))
+	if err != nil {
+		return progress, fmt.Errorf("could not scan mongo collection %v: %v", coll.Name(), err)
+	}
+	defer func() {
+		_ = cur.Close(context.Background())
+	}()
+
+	streamName := opts.streamName(coll.Database().Name(), coll.Name())
+	published := 0
+	for cur.Next(ctx) {
+		id := cur.Current.Lookup("_id")
+		if progress.LastId != nil && id.Equal(*progress.LastId) {
+			// the scan starts from the last published document, which must not be published again
+			continue
+		}
+		msgId, err := snapshotMsgId(progress.ClusterTime, id)
+		if err != nil {
+			return progress, err
+		}
+
+		// the fields are redacted before the document is either logged or published
+		var fullDocument any = cur.Current
+		if opts.redactor != nil {
+			redacted, err := opts.redactor.redactDocument(cur.Current)
+			if err != nil {
+				return progress, Permanent(err)
+			}
+			fullDocument = redacted
+		}
+		data, headers, err := encodeEvent(opts, bson.D{
+			{Key: "_id", Value: bson.D{{Key: "_data", Value: msgId}}},
+			{Key: "operationType", Value: snapshotOperationType},
+			{Key: "clusterTime", Value: progress.ClusterTime},
+			{Key: "fullDocument", Value: fullDocument},
+			{Key: "ns", Value: bson.D{{Key: "db", Value: coll.Database().Name()}, {Key: "coll", Value: coll.Name()}}},
+			{Key: "documentKey", Value: bson.D{{Key: "_id", Value: id}}},
+		})
+		if err != nil {
+			return progress, fmt.Errorf("could not marshal mongo document from bson: %v", err)
+		}
+		c.logger.Debug("received snapshot document", "changeEvent", loggable(headers, data))
+
+		event := &ChangeEvent{
+			StreamName: streamName,
+			Subj:       fmt.Sprintf("%s.%s", streamName, snapshotOperationType),
+			MsgId:      msgId,
+			Data:       data,
+			Headers:    headers,
+		}
+		if err = opts.changeEventHandler(ctx, event); err != nil {
+			return progress, fmt.Errorf("could not publish snapshot document: %w", err)
+		}
+
+		// the id is copied, since the current document buffer can be reused by the cursor
+		progress.LastId = &bson.RawValue{Type: id.Type, Value: slices.Clone(id.Value)}
+		if published++; published%snapshotProgressInterval == 0 {
+			if err = store.Save(ctx, opts.checkpointKey, &Checkpoint{Snapshot: progress}); err != nil {
+				return progress, err
+			}
+		}
+	}
+	if err = cur.Err(); err != nil {
+		return progress, fmt.Errorf("could not scan mongo collection %v: %v", coll.Name(), err)
+	}
+
+	progress.Done = true
+	if err = store.Save(ctx, opts.checkpointKey, &Checkpoint{Snapshot: progress}); err != nil {
+		return progress, err
+	}
+	c.logger.Info("completed mongodb collection snapshot", "collName", coll.Name(), "published", published)
+	return progress, nil
+}
+
+// snapshotFindOptions returns the options of the scan of the watched collection in ascending id order, starting from
+// the last published document, if any. The scan is bounded by the id index rather than by a $gt filter, since a query
+// filter only matches the ids of the same BSON type, and the ids of the other types would be skipped.
+func snapshotFindOptions(lastId *bson.RawValue) *options.FindOptions {
+	findOpts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	if lastId != nil {
+		findOpts.SetMin(bson.D{{Key: "_id", Value: *lastId}}).SetHint(bson.D{{Key: "_id", Value: 1}})
+	}
+	return findOpts
+}
+
+// snapshotMsgId returns the msg id of the given snapshot document. It is derived from the snapshot cluster time and
+// the document id, so that consumers can discard the documents published twice when a snapshot is continued.
+func snapshotMsgId(clusterTime primitive.Timestamp, id bson.RawValue) (string, error) {
+	idJson, err := bson.MarshalExtJSON(bson.D{{Key: "_id", Value: id}}, true, false)
+	if err != nil {
+		return "", fmt.Errorf("could not marshal mongo document id from bson: %v", err)
+	}
+	return fmt.Sprintf("%d.%d-%s", clusterTime.T, clusterTime.I, idJson), nil
+}
+
+// parseSnapshotMsgId returns the snapshot progress up to the document with the given msg id.
+func parseSnapshotMsgId(msgId string) (*SnapshotProgress, error) {
+	clusterTime, idJson, found := strings.Cut(msgId, "-")
+	if !found {
+		return nil, fmt.Errorf("could not parse snapshot msg id %v", msgId)
+	}
+	progress := &SnapshotProgress{}
+	if _, err := fmt.Sscanf(clusterTime, "%d.%d", &progress.ClusterTime.T, &progress.ClusterTime.I); err != nil {
+		return nil, fmt.Errorf("could not parse snapshot msg id %v: %v", msgId, err)
+	}
+	doc := bson.Raw{}
+	if err := bson.UnmarshalExtJSON([]byte(idJson), true, &doc); err != nil {
+		return nil, fmt.Errorf("could not parse snapshot msg id %v: %v", msgId, err)
+	}
+	id := doc.Lookup("_id")
+	progress.LastId = &id
+	return progress, nil
+}
+
+// clusterTime returns the current cluster time, as seen by the operation time of a ping command.
+func (c *DefaultClient) clusterTime(ctx context.Context, db *mongo.Database) (primitive.Timestamp, error) {
+	reply := struct {
+		OperationTime primitive.Timestamp `bson:"operationTime"`
+	}{}
+	if err := db.RunCommand(ctx, bson.D{{Key: "ping", Value: 1}}).Decode(&reply); err != nil {
+		return primitive.Timestamp{}, fmt.Errorf("could not fetch mongo cluster time: %v", err)
+	}
+	if reply.OperationTime.IsZero() {
+		return primitive.Timestamp{}, fmt.Errorf("could not fetch mongo cluster time: not a replica set")
+	}
+	return reply.OperationTime, nil
+}
@@ -0,0 +1,80 @@
+package mongo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestShouldSnapshot(t *testing.T) {
+	coll := &mongo.Collection{}
+	tests := []struct {
+		name        string
+		opts        *watchOptions
+		checkpoint  *Checkpoint
+		historyLost bool
+		want        bool
+	}{
+		{
+			name:       "should snapshot if the collection is configured to be snapshotted",
+			opts:       &watchOptions{snapshotColl: coll, snapshot: true},
+			checkpoint: &Checkpoint{},
+			want:       true,
+		},
+		{
+			name:       "should continue the stored snapshot",
+			opts:       &watchOptions{snapshotColl: coll},
+			checkpoint: &Checkpoint{Snapshot: &SnapshotProgress{}},
+			want:       true,
+		},
+		{
+			name:       "should not snapshot a database or a deployment",
+			opts:       &watchOptions{snapshot: true},
+			checkpoint: &Checkpoint{},
+			want:       false,
+		},
+		{
+			name:        "should resnapshot after the history is lost",
+			opts:        &watchOptions{snapshotColl: coll, onHistoryLost: ResnapshotOnHistoryLost},
+			checkpoint:  &Checkpoint{},
+			historyLost: true,
+			want:        true,
+		},
+		{
+			name: "should not snapshot again after the history is lost when resuming from now",
+			opts: &watchOptions{snapshotColl: coll, snapshot: true,
+				onHistoryLost: ResumeFromNowOnHistoryLost},
+			checkpoint:  &Checkpoint{},
+			historyLost: true,
+			want:        false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, shouldSnapshot(tt.opts, tt.checkpoint, tt.historyLost))
+		})
+	}
+}
+
+func TestSnapshotFindOptions(t *testing.T) {
+	t.Run("should scan the whole collection in id order", func(t *testing.T) {
+		findOpts := snapshotFindOptions(nil)
+
+		require.Equal(t, bson.D{{Key: "_id", Value: 1}}, findOpts.Sort)
+		require.Nil(t, findOpts.Min)
+		require.Nil(t, findOpts.Hint)
+	})
+	t.Run("should scan the id index from the last published document", func(t *testing.T) {
+		_, idValue, _ := bson.MarshalValue("doc1")
+		lastId := bson.RawValue{Type: bson.TypeString, Value: idValue}
+
+		findOpts := snapshotFindOptions(&lastId)
+
+		require.Equal(t, bson.D{{Key: "_id", Value: 1}}, findOpts.Sort)
+		require.Equal(t, bson.D{{Key: "_id", Value: lastId}}, findOpts.Min)
+		require.Equal(t, bson.D{{Key: "_id", Value: 1}}, findOpts.Hint)
+	})
+}
@@ -0,0 +1,199 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Checkpoint tells where a change stream must resume from: either the resume token of the last published change
+// event, or the progress of the initial snapshot of the watched collection.
+type Checkpoint struct {
+	ResumeToken string            `bson:"value"`
+	Snapshot    *SnapshotProgress `bson:"snapshot,omitempty"`
+	CreatedAt   time.Time         `bson:"createdAt,omitempty"`
+}
+
+// CheckpointStore loads and saves the checkpoints of the change streams, each one identified by its watcher key.
+type CheckpointStore interface {
+	// Load returns the last saved checkpoint for the given key, or an empty one if none has been saved.
+	Load(ctx context.Context, key string) (*Checkpoint, error)
+	// Save stores the given checkpoint for the given key.
+	Save(ctx context.Context, key string, checkpoint *Checkpoint) error
+	// Reset deletes the checkpoints for the given key, so that the next Load returns an empty one.
+	Reset(ctx context.Context, key string) error
+}
+
+// KeyValue stores values by key. Get returns a nil value if the key is not found.
+type KeyValue interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Put(ctx context.Context, key string, value []byte) error
+	Delete(ctx context.Context, key string) error
+}
+
+var _ CheckpointStore = &KeyValueCheckpointStore{}
+
+// KeyValueCheckpointStore saves the last checkpoint of each key as extended json in a KeyValue, such as a NATS KV
+// bucket.
+type KeyValueCheckpointStore struct {
+	kv KeyValue
+}
+
+func NewKeyValueCheckpointStore(kv KeyValue) *KeyValueCheckpointStore {
+	return &KeyValueCheckpointStore{kv: kv}
+}
+
+func (s *KeyValueCheckpointStore) Load(ctx context.Context, key string) (*Checkpoint, error) {
+	checkpoint := &Checkpoint{}
+	value, err := s.kv.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch checkpoint %v: %v", key, err)
+	}
+	if value == nil {
+		return checkpoint, nil
+	}
+	if err = bson.UnmarshalExtJSON(value, true, checkpoint); err != nil {
+		return nil, fmt.Errorf("could not unmarshal checkpoint %v from json: %v", key, err)
+	}
+	return checkpoint, nil
+}
+
+func (s *KeyValueCheckpointStore) Save(ctx context.Context, key string, checkpoint *Checkpoint) error {
+	checkpoint.CreatedAt = time.Now()
+	value, err := bson.MarshalExtJSON(checkpoint, true, false)
+	if err != nil {
+		return fmt.Errorf("could not marshal checkpoint %v to json: %v", key, err)
+	}
+	if err = s.kv.Put(ctx, key, value); err != nil {
+		return fmt.Errorf("could not save checkpoint %v: %v", key, err)
+	}
+	return nil
+}
+
+func (s *KeyValueCheckpointStore) Reset(ctx context.Context, key string) error {
+	if err := s.kv.Delete(ctx, key); err != nil {
+		return fmt.Errorf("could not delete checkpoint %v: %v", key, err)
+	}
+	return nil
+}
+
+var _ CheckpointStore = &StreamCheckpointStore{}
+
+// StreamCheckpointStore derives the checkpoints from the msg id of the last message published on the stream named
+// after the key, so that the stream is the single source of truth for what has been published. Nothing is saved.
+type StreamCheckpointStore struct {
+	lastMsgId LastMsgIdFunc
+}
+
+// LastMsgIdFunc returns the msg id of the last message published on the given stream, or an empty string if none
+// has been published.
+type LastMsgIdFunc func(ctx context.Context, streamName string) (string, error)
+
+func NewStreamCheckpointStore(lastMsgId LastMsgIdFunc) *StreamCheckpointStore {
+	return &StreamCheckpointStore{lastMsgId: lastMsgId}
+}
+
+// Load returns the resume token of the last published change event, or the snapshot progress up to the last
+// published snapshot document. If the last message is a gap event, the lost resume token it carries is returned, so
+// that the history is found lost again and the onHistoryLost policy is applied again, rather than the watcher
+// silently starting from its start position.
+func (s *StreamCheckpointStore) Load(ctx context.Context, key string) (*Checkpoint, error) {
+	msgId, err := s.lastMsgId(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch checkpoint %v: %v", key, err)
+	}
+	if lostResumeToken, ok := strings.CutPrefix(msgId, gapOperationType+"-"); ok {
+		if _, err = strconv.ParseInt(lostResumeToken, 10, 64); err == nil {
+			// the gap event has been published without a lost resume token, its msg id holds its time instead
+			return &Checkpoint{}, nil
+		}
+		return &Checkpoint{ResumeToken: lostResumeToken}, nil
+	}
+	switch {
+	case msgId == "":
+		return &Checkpoint{}, nil
+	case strings.Contains(msgId, "-"):
+		// resume tokens are hex strings, whereas snapshot msg ids contain the cluster time and the document id
+		progress, err := parseSnapshotMsgId(msgId)
+		if err != nil {
+			return nil, fmt.Errorf("could not fetch checkpoint %v: %v", key, err)
+		}
+		return &Checkpoint{Snapshot: progress}, nil
+	default:
+		return &Checkpoint{ResumeToken: msgId}, nil
+	}
+}
+
+// Save does nothing, since the checkpoints are derived from the published messages.
+func (s *StreamCheckpointStore) Save(_ context.Context, _ string, _ *Checkpoint) error {
+	return nil
+}
+
+// Reset returns an error, since the published messages are never deleted by the connector.
+func (s *StreamCheckpointStore) Reset(_ context.Context, key string) error {
+	return fmt.Errorf("could not delete checkpoint %v: the stream cannot be reset", key)
+}
+
+// NewFileCheckpointStore returns a store that saves the last checkpoint of each key in its own file, inside the given
+// directory. It is meant for single node setups, where the connector always runs on the same host.
+func NewFileCheckpointStore(dir string) (*KeyValueCheckpointStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create checkpoints directory %v: %v", dir, err)
+	}
+	return NewKeyValueCheckpointStore(&fileKeyValue{dir: dir}), nil
+}
+
+// fileKeyValue stores each value in a file named after its key.
+type fileKeyValue struct {
+	dir string
+}
+
+func (f *fileKeyValue) Get(_ context.Context, key string) ([]byte, error) {
+	value, err := os.ReadFile(f.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return value, err
+}
+
+func (f *fileKeyValue) Put(_ context.Context, key string, value []byte) error {
+	// the value is written to a temporary file first, so that a crash never leaves a partially written file behind
+	tmp, err := os.CreateTemp(f.dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err = tmp.Write(value); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path(key))
+}
+
+func (f *fileKeyValue) Delete(_ context.Context, key string) error {
+	if err := os.Remove(f.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (f *fileKeyValue) path(key string) string {
+	return filepath.Join(f.dir, key+".json")
+}
@@ -0,0 +1,125 @@
+package mongo
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestFileCheckpointStore(t *testing.T) {
+	t.Run("should return an empty checkpoint if none has been saved", func(t *testing.T) {
+		store, _ := NewFileCheckpointStore(t.TempDir())
+
+		checkpoint, err := store.Load(context.Background(), "coll1")
+
+		require.NoError(t, err)
+		require.Equal(t, &Checkpoint{}, checkpoint)
+	})
+	t.Run("should load the last saved checkpoint", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "checkpoints")
+		store, err := NewFileCheckpointStore(dir)
+		require.NoError(t, err)
+		ctx := context.Background()
+		_, idValue, _ := bson.MarshalValue(int32(42))
+		lastId := &bson.RawValue{Type: bson.TypeInt32, Value: idValue}
+
+		require.NoError(t, store.Save(ctx, "coll1", &Checkpoint{ResumeToken: "8264"}))
+		require.NoError(t, store.Save(ctx, "coll1", &Checkpoint{
+			Snapshot: &SnapshotProgress{ClusterTime: primitive.Timestamp{T: 1, I: 2}, LastId: lastId},
+		}))
+		checkpoint, err := store.Load(ctx, "coll1")
+
+		require.NoError(t, err)
+		require.Empty(t, checkpoint.ResumeToken)
+		require.Equal(t, primitive.Timestamp{T: 1, I: 2}, checkpoint.Snapshot.ClusterTime)
+		require.Equal(t, int32(42), checkpoint.Snapshot.LastId.Int32())
+		require.False(t, checkpoint.Snapshot.Done)
+		require.False(t, checkpoint.CreatedAt.IsZero())
+		files, _ := os.ReadDir(dir)
+		require.Len(t, files, 1)
+	})
+	t.Run("should return an empty checkpoint after reset", func(t *testing.T) {
+		store, _ := NewFileCheckpointStore(t.TempDir())
+		ctx := context.Background()
+		_ = store.Save(ctx, "coll1", &Checkpoint{ResumeToken: "8264"})
+		_ = store.Save(ctx, "coll2", &Checkpoint{ResumeToken: "8265"})
+
+		err := store.Reset(ctx, "coll1")
+
+		require.NoError(t, err)
+		checkpoint, _ := store.Load(ctx, "coll1")
+		require.Equal(t, &Checkpoint{}, checkpoint)
+		checkpoint, _ = store.Load(ctx, "coll2")
+		require.Equal(t, "8265", checkpoint.ResumeToken)
+	})
+}
+
+func TestStreamCheckpointStore_Load(t *testing.T) {
+	_, idValue, _ := bson.MarshalValue(int32(42))
+	id := bson.RawValue{Type: bson.TypeInt32, Value: idValue}
+	snapshotMsgId, _ := snapshotMsgId(primitive.Timestamp{T: 1, I: 2}, id)
+
+	tests := []struct {
+		name  string
+		msgId string
+		want  *Checkpoint
+	}{
+		{
+			name:  "should return an empty checkpoint if no message has been published",
+			msgId: "",
+			want:  &Checkpoint{},
+		},
+		{
+			name:  "should return the resume token of the last published change event",
+			msgId: "8264AB",
+			want:  &Checkpoint{ResumeToken: "8264AB"},
+		},
+		{
+			name:  "should return the snapshot progress up to the last published snapshot document",
+			msgId: snapshotMsgId,
+			want:  &Checkpoint{Snapshot: &SnapshotProgress{ClusterTime: primitive.Timestamp{T: 1, I: 2}, LastId: &id}},
+		},
+		{
+			name:  "should return the lost resume token if the last message is a gap event",
+			msgId: "gap-8264AB",
+			want:  &Checkpoint{ResumeToken: "8264AB"},
+		},
+		{
+			name:  "should return an empty checkpoint if the last message is a gap event without a lost resume token",
+			msgId: "gap-1683633600000000000",
+			want:  &Checkpoint{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var streamName string
+			store := NewStreamCheckpointStore(func(_ context.Context, name string) (string, error) {
+				streamName = name
+				return tt.msgId, nil
+			})
+
+			checkpoint, err := store.Load(context.Background(), "COLL1")
+
+			require.NoError(t, err)
+			require.Equal(t, "COLL1", streamName)
+			require.Equal(t, tt.want, checkpoint)
+		})
+	}
+}
+
+func TestCheckpoint_marshal(t *testing.T) {
+	t.Run("should omit the creation time if not set, so that the checkpoint is never expired by a ttl index",
+		func(t *testing.T) {
+			raw, err := bson.Marshal(&Checkpoint{ResumeToken: "8263"})
+			require.NoError(t, err)
+
+			_, err = bson.Raw(raw).LookupErr(createdAtField)
+			require.Error(t, err)
+		})
+}
@@ -0,0 +1,169 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// RetainAllTokens keeps every stored resume token.
+	RetainAllTokens = "all"
+	// RetainLatestToken keeps only the latest resume token, by upserting a single document.
+	RetainLatestToken = "latest"
+	// RetainTokensCount keeps only the latest resume tokens, periodically deleting the older ones.
+	RetainTokensCount = "count"
+	// RetainTokensTtl keeps the resume tokens for a given time, by means of a TTL index, except for the latest one.
+	RetainTokensTtl = "ttl"
+
+	// latestTokenId is the id of the document holding the latest resume token, when retaining only the latest resume
+	// token or when expiring the resume tokens.
+	latestTokenId = "latest"
+	// createdAtField is the field of the resume token documents used by the TTL index.
+	createdAtField = "createdAt"
+)
+
+// TokensRetention tells which resume tokens must be kept in an uncapped resume tokens collection.
+// Count is only used by RetainTokensCount, Ttl is only used by RetainTokensTtl.
+type TokensRetention struct {
+	Mode  string
+	Count int
+	Ttl   time.Duration
+}
+
+var _ CheckpointStore = &resumeTokensStore{}
+
+// resumeTokensStore loads and saves the checkpoints of a change stream in its own resume tokens collection, the key is
+// not used. The last stored document tells where the connector must resume from.
+type resumeTokensStore struct {
+	coll      *mongo.Collection
+	capped    bool
+	retention TokensRetention
+
+	// saved is the number of resume tokens saved since the older ones have been pruned.
+	saved int
+	// pruned tells whether the resume tokens stored before the latest one have been deleted.
+	pruned bool
+}
+
+// Load returns the last stored resume token, or an empty one if none has been stored.
+func (s *resumeTokensStore) Load(ctx context.Context, _ string) (*Checkpoint, error) {
+	token := &Checkpoint{}
+	if s.retention.Mode == RetainLatestToken || s.retention.Mode == RetainTokensTtl {
+		err := s.coll.FindOne(ctx, bson.D{{Key: "_id", Value: latestTokenId}}).Decode(token)
+		if err == nil {
+			return token, nil
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("could not fetch or decode resume token: %v", err)
+		}
+		// the tokens may have been stored with a different retention, fall back to the last inserted one
+	}
+
+	findOneOpts := options.FindOne()
+	if s.capped {
+		// use natural sort for capped collections to get the last inserted resume token
+		findOneOpts.SetSort(bson.D{{Key: "$natural", Value: -1}})
+	} else {
+		// cannot rely on natural sort for uncapped collections, sort by id instead
+		findOneOpts.SetSort(bson.D{{Key: "_id", Value: -1}})
+	}
+	err := s.coll.FindOne(ctx, bson.D{}, findOneOpts).Decode(token)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("could not fetch or decode resume token: %v", err)
+	}
+	return token, nil
+}
+
+// Save stores the given resume token, and deletes the older ones according to the retention.
+func (s *resumeTokensStore) Save(ctx context.Context, _ string, token *Checkpoint) error {
+	token.CreatedAt = time.Now()
+
+	if s.retention.Mode == RetainLatestToken {
+		if err := s.upsertLatest(ctx, token); err != nil {
+			return err
+		}
+		if !s.pruned {
+			// delete once the tokens that may have been stored with a different retention
+			pruneFilter := bson.D{{Key: "_id", Value: bson.D{{Key: "$ne", Value: latestTokenId}}}}
+			if _, err := s.coll.DeleteMany(ctx, pruneFilter); err != nil {
+				return fmt.Errorf("could not delete older resume tokens: %v", err)
+			}
+			s.pruned = true
+		}
+		return nil
+	}
+
+	if _, err := s.coll.InsertOne(ctx, token); err != nil {
+		return fmt.Errorf("could not insert resume token: %v", err)
+	}
+
+	if s.retention.Mode == RetainTokensTtl {
+		// the latest resume token is also stored without its creation time, so that it is never expired by the TTL
+		// index, even if the connector has been stopped for longer than the TTL
+		latest := *token
+		latest.CreatedAt = time.Time{}
+		if err := s.upsertLatest(ctx, &latest); err != nil {
+			return err
+		}
+	}
+
+	if s.retention.Mode == RetainTokensCount {
+		// delete the older tokens every time as many tokens as the ones to be kept have been saved
+		if s.saved++; s.saved >= s.retention.Count {
+			if err := s.prune(ctx); err != nil {
+				return err
+			}
+			s.saved = 0
+		}
+	}
+	return nil
+}
+
+// upsertLatest stores the given resume token in the document holding the latest one.
+func (s *resumeTokensStore) upsertLatest(ctx context.Context, token *Checkpoint) error {
+	replaceOpts := options.Replace().SetUpsert(true)
+	filter := bson.D{{Key: "_id", Value: latestTokenId}}
+	if _, err := s.coll.ReplaceOne(ctx, filter, token, replaceOpts); err != nil {
+		return fmt.Errorf("could not upsert resume token: %v", err)
+	}
+	return nil
+}
+
+// Reset deletes all the stored resume tokens. Since documents cannot be deleted from a capped collection, an empty
+// resume token is stored instead.
+func (s *resumeTokensStore) Reset(ctx context.Context, key string) error {
+	if s.capped {
+		return s.Save(ctx, key, &Checkpoint{})
+	}
+	if _, err := s.coll.DeleteMany(ctx, bson.D{}); err != nil {
+		return fmt.Errorf("could not delete resume tokens: %v", err)
+	}
+	return nil
+}
+
+// prune deletes all the resume tokens but the latest ones, according to the retention count.
+func (s *resumeTokensStore) prune(ctx context.Context) error {
+	findOneOpts := options.FindOne().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetSkip(int64(s.retention.Count - 1)).
+		SetProjection(bson.D{{Key: "_id", Value: 1}})
+	oldest := bson.Raw{}
+	err := s.coll.FindOne(ctx, bson.D{}, findOneOpts).Decode(&oldest)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not fetch oldest resume token to keep: %v", err)
+	}
+	filter := bson.D{{Key: "_id", Value: bson.D{{Key: "$lt", Value: oldest.Lookup("_id")}}}}
+	if _, err = s.coll.DeleteMany(ctx, filter); err != nil {
+		return fmt.Errorf("could not delete older resume tokens: %v", err)
+	}
+	return nil
+}
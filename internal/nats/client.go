@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"time"
 
 	"github.com/nats-io/nats.go"
 
@@ -14,10 +15,24 @@ import (
 
 const (
 	defaultName = "nats"
+
+	// publishAckTimeout is how long an asynchronous publication waits for its acknowledgement.
+	publishAckTimeout = 5 * time.Second
+)
+
+const (
+	// wrongLastSequenceErrCode is the error code returned by JetStream when the expected revision of a KV entry does
+	// not match.
+	wrongLastSequenceErrCode = 10071
+
+	// keyValueStreamPrefix is the prefix of the names of the streams storing the entries of the KV buckets.
+	keyValueStreamPrefix = "KV_"
 )
 
 var (
 	ErrClientDisconnected = errors.New("could not reach nats: connection closed")
+	ErrKeyValueConflict   = errors.New("could not write nats key value entry: revision mismatch")
+	ErrDestructiveChanges = errors.New("destructive changes refused")
 )
 
 type Client interface {
@@ -26,16 +41,56 @@ type Client interface {
 
 	AddStream(ctx context.Context, opts *AddStreamOptions) error
 	Publish(ctx context.Context, opts *PublishOptions) error
+	PublishAsync(ctx context.Context, opts *PublishOptions, acked func(err error)) error
+	KeyValue(ctx context.Context, opts *KeyValueOptions) (KeyValue, error)
+	LastMsgId(ctx context.Context, streamName string) (string, error)
 }
 
+// AddStreamOptions configures the stream where the change events are published, on the `<StreamName>.*` subjects.
+// The zero values of the limits mean unlimited, while the zero values of the other fields use the JetStream defaults.
 type AddStreamOptions struct {
-	StreamName string
+	StreamName        string
+	Description       string
+	Storage           string
+	Replicas          int
+	Retention         string
+	MaxAge            time.Duration
+	MaxBytes          int64
+	MaxMsgs           int64
+	MaxMsgsPerSubject int64
+	MaxMsgSize        int32
+	Discard           string
+	DuplicateWindow   time.Duration
+	PlacementCluster  string
+	PlacementTags     []string
+	DenyDelete        bool
+	DenyPurge         bool
+	Reconcile         string
 }
 
 type PublishOptions struct {
-	Subj  string
-	MsgId string
-	Data  []byte
+	Subj    string
+	MsgId   string
+	Data    []byte
+	Headers map[string]string
+}
+
+type KeyValueOptions struct {
+	Bucket string
+	Ttl    time.Duration
+}
+
+// KeyValue stores values by key in a NATS KV bucket. Get returns a nil value if the key is not found.
+// Create, Update and DeleteRevision only succeed if the key is at the expected revision, otherwise they return
+// ErrKeyValueConflict. Keys returns the keys matching the given filter, which can contain wildcards (e.g. `a.>`).
+type KeyValue interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Put(ctx context.Context, key string, value []byte) error
+	Delete(ctx context.Context, key string) error
+	Create(ctx context.Context, key string, value []byte) (uint64, error)
+	Update(ctx context.Context, key string, value []byte, revision uint64) (uint64, error)
+	DeleteRevision(ctx context.Context, key string, revision uint64) error
+	Keys(ctx context.Context, filter string) ([]string, error)
 }
 
 var _ Client = &DefaultClient{}
@@ -45,6 +100,22 @@ type DefaultClient struct {
 	name   string
 	logger *slog.Logger
 
+	// credsFile, nkeySeedFile, token, user and password are the credentials used to authenticate to NATS.
+	credsFile    string
+	nkeySeedFile string
+	token        string
+	user         string
+	password     string
+
+	// tlsCertFile and tlsKeyFile are the client certificate presented to NATS, tlsCaFile the CA used to verify it.
+	tlsCertFile string
+	tlsKeyFile  string
+	tlsCaFile   string
+
+	// jsDomain and jsApiPrefix select the JetStream domain or account where the messages are published.
+	jsDomain    string
+	jsApiPrefix string
+
 	conn *nats.Conn
 	js   nats.JetStreamContext
 }
@@ -59,7 +130,37 @@ func NewDefaultClient(opts ...ClientOption) (*DefaultClient, error) {
 		opt(c)
 	}
 
-	conn, err := nats.Connect(c.url,
+	connOpts, err := c.connectOptions()
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to nats: %v", err)
+	}
+	conn, err := nats.Connect(c.url, connOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to nats: %v", err)
+	}
+	c.conn = conn
+
+	var jsOpts []nats.JSOpt
+	if c.jsDomain != "" {
+		jsOpts = append(jsOpts, nats.Domain(c.jsDomain))
+	}
+	if c.jsApiPrefix != "" {
+		jsOpts = append(jsOpts, nats.APIPrefix(c.jsApiPrefix))
+	}
+	js, err := conn.JetStream(jsOpts...)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not get nats jetstream context: %v", err)
+	}
+	c.js = js
+
+	c.logger.Info("connected to nats", "url", conn.ConnectedUrlRedacted())
+	return c, nil
+}
+
+// connectOptions returns the options of the NATS connection: its event handlers, credentials and TLS configuration.
+func (c *DefaultClient) connectOptions() ([]nats.Option, error) {
+	opts := []nats.Option{
 		nats.DisconnectErrHandler(func(conn *nats.Conn, err error) {
 			c.logger.Error("disconnected from nats", "err", err)
 		}),
@@ -69,17 +170,30 @@ func NewDefaultClient(opts ...ClientOption) (*DefaultClient, error) {
 		nats.ClosedHandler(func(conn *nats.Conn) {
 			c.logger.Info("nats connection closed")
 		}),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("could not connect to nats: %v", err)
 	}
-	c.conn = conn
-
-	js, _ := conn.JetStream()
-	c.js = js
-
-	c.logger.Info("connected to nats", "url", conn.ConnectedUrlRedacted())
-	return c, nil
+	if c.credsFile != "" {
+		opts = append(opts, nats.UserCredentials(c.credsFile))
+	}
+	if c.nkeySeedFile != "" {
+		nkeyOpt, err := nats.NkeyOptionFromSeed(c.nkeySeedFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read nkey seed: %v", err)
+		}
+		opts = append(opts, nkeyOpt)
+	}
+	if c.token != "" {
+		opts = append(opts, nats.Token(c.token))
+	}
+	if c.user != "" {
+		opts = append(opts, nats.UserInfo(c.user, c.password))
+	}
+	if c.tlsCertFile != "" {
+		opts = append(opts, nats.ClientCert(c.tlsCertFile, c.tlsKeyFile))
+	}
+	if c.tlsCaFile != "" {
+		opts = append(opts, nats.RootCAs(c.tlsCaFile))
+	}
+	return opts, nil
 }
 
 func (c *DefaultClient) Name() string {
@@ -98,27 +212,233 @@ func (c *DefaultClient) Close() error {
 	return nil
 }
 
+// AddStream creates the stream described by the given options if it does not exist. Otherwise, the existing stream is
+// reconciled with the options, according to their reconcile mode.
 func (c *DefaultClient) AddStream(_ context.Context, opts *AddStreamOptions) error {
-	_, err := c.js.AddStream(&nats.StreamConfig{
-		Name:     opts.StreamName,
-		Subjects: []string{fmt.Sprintf("%s.*", opts.StreamName)},
-		Storage:  nats.FileStorage,
-	})
+	desired := streamConfig(opts)
+	info, err := c.js.StreamInfo(opts.StreamName)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		if _, err = c.js.AddStream(desired); err != nil {
+			return fmt.Errorf("could not add nats stream %v: %w", opts.StreamName, err)
+		}
+		c.logger.Debug("added nats stream", "streamName", opts.StreamName)
+		return nil
+	}
 	if err != nil {
-		return fmt.Errorf("could not add nats stream %v: %v", opts.StreamName, err)
+		return fmt.Errorf("could not get nats stream %v: %w", opts.StreamName, err)
+	}
+	if opts.Reconcile == "" || opts.Reconcile == CreateOnlyReconcile {
+		return nil
+	}
+
+	changes := diffStream(&info.Config, desired)
+	if len(changes) == 0 {
+		return nil
+	}
+	if opts.Reconcile == VerifyReconcile {
+		c.logger.Warn("nats stream differs from its configuration", "streamName", opts.StreamName,
+			"changes", fmt.Sprint(changes))
+		return nil
+	}
+	var destructive []streamChange
+	for _, change := range changes {
+		if change.destructive {
+			destructive = append(destructive, change)
+		}
+	}
+	if len(destructive) > 0 {
+		return fmt.Errorf("could not update nats stream %v: %w: %v", opts.StreamName, ErrDestructiveChanges,
+			destructive)
 	}
-	c.logger.Debug("added nats stream", "streamName", opts.StreamName)
+	if _, err = c.js.UpdateStream(desired); err != nil {
+		return fmt.Errorf("could not update nats stream %v: %w", opts.StreamName, err)
+	}
+	c.logger.Info("updated nats stream", "streamName", opts.StreamName, "changes", fmt.Sprint(changes))
 	return nil
 }
 
 func (c *DefaultClient) Publish(_ context.Context, opts *PublishOptions) error {
-	if _, err := c.js.Publish(opts.Subj, opts.Data, nats.MsgId(opts.MsgId)); err != nil {
-		return fmt.Errorf("could not publish message %v to nats stream %v: %v", opts.Data, opts.Subj, err)
+	if _, err := c.js.PublishMsg(newMsg(opts), nats.MsgId(opts.MsgId)); err != nil {
+		return fmt.Errorf("could not publish message %v to nats stream %v: %w", opts.Data, opts.Subj, err)
 	}
 	c.logger.Debug("published message", "subj", opts.Subj, "data", string(opts.Data))
 	return nil
 }
 
+// PublishAsync publishes the given message without waiting for its acknowledgement, then calls acked exactly once,
+// from another goroutine, when the message has been acknowledged, rejected, or not acknowledged in time.
+func (c *DefaultClient) PublishAsync(_ context.Context, opts *PublishOptions, acked func(err error)) error {
+	future, err := c.js.PublishMsgAsync(newMsg(opts), nats.MsgId(opts.MsgId))
+	if err != nil {
+		return fmt.Errorf("could not publish message %v to nats stream %v: %w", opts.Data, opts.Subj, err)
+	}
+	go func() {
+		timer := time.NewTimer(publishAckTimeout)
+		defer timer.Stop()
+		select {
+		case <-future.Ok():
+			c.logger.Debug("published message", "subj", opts.Subj, "data", string(opts.Data))
+			acked(nil)
+		case err := <-future.Err():
+			acked(fmt.Errorf("could not publish message %v to nats stream %v: %w", opts.Data, opts.Subj, err))
+		case <-timer.C:
+			acked(fmt.Errorf("could not publish message %v to nats stream %v: %w", opts.Data, opts.Subj,
+				nats.ErrTimeout))
+		}
+	}()
+	return nil
+}
+
+func newMsg(opts *PublishOptions) *nats.Msg {
+	msg := nats.NewMsg(opts.Subj)
+	msg.Data = opts.Data
+	for key, value := range opts.Headers {
+		msg.Header.Set(key, value)
+	}
+	return msg
+}
+
+// IsPermanent tells whether the given error will occur again if the same request is retried, such as a message that
+// exceeds the maximum payload or that is rejected by the stream, or a stream that cannot be reconciled.
+func IsPermanent(err error) bool {
+	if errors.Is(err, nats.ErrMaxPayload) || errors.Is(err, nats.ErrBadSubject) ||
+		errors.Is(err, ErrDestructiveChanges) {
+		return true
+	}
+	var apiErr *nats.APIError
+	return errors.As(err, &apiErr) && apiErr.Code == 400
+}
+
+// LastMsgId returns the msg id of the last message published on the subjects of the given stream, or an empty string
+// if the stream does not exist or has no messages.
+func (c *DefaultClient) LastMsgId(_ context.Context, streamName string) (string, error) {
+	msg, err := c.js.GetLastMsg(streamName, fmt.Sprintf("%s.*", streamName))
+	if errors.Is(err, nats.ErrStreamNotFound) || errors.Is(err, nats.ErrMsgNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not get last message of nats stream %v: %v", streamName, err)
+	}
+	return msg.Header.Get(nats.MsgIdHdr), nil
+}
+
+// KeyValue binds to the given KV bucket, creating it if it does not exist. The entries of the bucket expire after the
+// given TTL, if any: the TTL of an existing bucket is updated if it differs.
+func (c *DefaultClient) KeyValue(_ context.Context, opts *KeyValueOptions) (KeyValue, error) {
+	kv, err := c.js.KeyValue(opts.Bucket)
+	if err == nil {
+		err = c.updateKeyValueTtl(kv, opts.Ttl)
+	}
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = c.js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket:  opts.Bucket,
+			TTL:     opts.Ttl,
+			Storage: nats.FileStorage,
+		})
+		if err == nil {
+			c.logger.Debug("added nats key value bucket", "bucket", opts.Bucket)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not bind nats key value bucket %v: %v", opts.Bucket, err)
+	}
+	return &keyValue{kv: kv}, nil
+}
+
+// updateKeyValueTtl updates the TTL of the given bucket if it differs from the given one, e.g. if the bucket has been
+// created by someone else without a TTL, in which case the leases it holds would never expire.
+func (c *DefaultClient) updateKeyValueTtl(kv nats.KeyValue, ttl time.Duration) error {
+	status, err := kv.Status()
+	if err != nil {
+		return err
+	}
+	if status.TTL() == ttl {
+		return nil
+	}
+	// the entries of a bucket are stored in a stream named after it, whose max age is the TTL of the bucket
+	info, err := c.js.StreamInfo(keyValueStreamPrefix + kv.Bucket())
+	if err != nil {
+		return err
+	}
+	config := info.Config
+	config.MaxAge = ttl
+	if ttl > 0 && config.Duplicates > ttl {
+		config.Duplicates = ttl
+	}
+	if _, err = c.js.UpdateStream(&config); err != nil {
+		return err
+	}
+	c.logger.Warn("updated ttl of nats key value bucket", "bucket", kv.Bucket(), "ttl", ttl,
+		"previousTtl", status.TTL())
+	return nil
+}
+
+type keyValue struct {
+	kv nats.KeyValue
+}
+
+func (k *keyValue) Get(_ context.Context, key string) ([]byte, error) {
+	entry, err := k.kv.Get(key)
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entry.Value(), nil
+}
+
+func (k *keyValue) Put(_ context.Context, key string, value []byte) error {
+	_, err := k.kv.Put(key, value)
+	return err
+}
+
+func (k *keyValue) Delete(_ context.Context, key string) error {
+	return k.kv.Delete(key)
+}
+
+func (k *keyValue) Create(_ context.Context, key string, value []byte) (uint64, error) {
+	revision, err := k.kv.Create(key, value)
+	return revision, keyValueConflict(err)
+}
+
+func (k *keyValue) Update(_ context.Context, key string, value []byte, revision uint64) (uint64, error) {
+	revision, err := k.kv.Update(key, value, revision)
+	return revision, keyValueConflict(err)
+}
+
+func (k *keyValue) DeleteRevision(_ context.Context, key string, revision uint64) error {
+	return keyValueConflict(k.kv.Delete(key, nats.LastRevision(revision)))
+}
+
+func (k *keyValue) Keys(_ context.Context, filter string) ([]string, error) {
+	watcher, err := k.kv.Watch(filter, nats.IgnoreDeletes(), nats.MetaOnly())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = watcher.Stop()
+	}()
+	var keys []string
+	for entry := range watcher.Updates() {
+		if entry == nil {
+			// all the current keys have been received
+			break
+		}
+		keys = append(keys, entry.Key())
+	}
+	return keys, nil
+}
+
+// keyValueConflict replaces the errors caused by a revision mismatch with ErrKeyValueConflict.
+func keyValueConflict(err error) error {
+	var apiErr *nats.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode == wrongLastSequenceErrCode {
+		return ErrKeyValueConflict
+	}
+	return err
+}
+
 type ClientOption func(*DefaultClient)
 
 func WithNatsUrl(url string) ClientOption {
@@ -136,3 +456,59 @@ func WithLogger(logger *slog.Logger) ClientOption {
 		}
 	}
 }
+
+// WithCredentials authenticates to NATS with the given user credentials file (`.creds`), holding a user JWT and its
+// NKey seed.
+func WithCredentials(credsFile string) ClientOption {
+	return func(c *DefaultClient) {
+		c.credsFile = credsFile
+	}
+}
+
+// WithNKeySeed authenticates to NATS with the NKey seed stored in the given file.
+func WithNKeySeed(seedFile string) ClientOption {
+	return func(c *DefaultClient) {
+		c.nkeySeedFile = seedFile
+	}
+}
+
+// WithToken authenticates to NATS with the given token.
+func WithToken(token string) ClientOption {
+	return func(c *DefaultClient) {
+		c.token = token
+	}
+}
+
+// WithUserPassword authenticates to NATS with the given user and password.
+func WithUserPassword(user, password string) ClientOption {
+	return func(c *DefaultClient) {
+		c.user = user
+		c.password = password
+	}
+}
+
+// WithTLS connects to NATS over TLS, presenting the given client certificate, if any, and verifying the server
+// certificate with the given CA, if any, instead of the system ones.
+func WithTLS(certFile, keyFile, caFile string) ClientOption {
+	return func(c *DefaultClient) {
+		c.tlsCertFile = certFile
+		c.tlsKeyFile = keyFile
+		c.tlsCaFile = caFile
+	}
+}
+
+// WithJetStreamDomain publishes the messages to the JetStream of the given domain, e.g. a hub reached through a leaf
+// node.
+func WithJetStreamDomain(domain string) ClientOption {
+	return func(c *DefaultClient) {
+		c.jsDomain = domain
+	}
+}
+
+// WithJetStreamApiPrefix publishes the messages to the JetStream whose API is imported with the given prefix, e.g.
+// from another account.
+func WithJetStreamApiPrefix(prefix string) ClientOption {
+	return func(c *DefaultClient) {
+		c.jsApiPrefix = prefix
+	}
+}
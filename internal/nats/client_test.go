@@ -2,8 +2,11 @@ package nats
 
 import (
 	"context"
+	"io"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 
@@ -48,6 +51,65 @@ func TestNewDefaultClient(t *testing.T) {
 		require.NotNil(t, client.conn)
 		require.NotNil(t, client.js)
 	})
+	t.Run("should authenticate with the configured token", func(t *testing.T) {
+		opts := natstest.DefaultTestOptions
+		opts.Authorization = "s3cr3t"
+		s := natstest.RunServer(&opts)
+		defer s.Shutdown()
+
+		client, err := NewDefaultClient(WithToken("s3cr3t"))
+
+		require.NoError(t, err)
+		require.True(t, client.conn.IsConnected())
+	})
+	t.Run("should authenticate with the configured user and password", func(t *testing.T) {
+		opts := natstest.DefaultTestOptions
+		opts.Username = "connector"
+		opts.Password = "s3cr3t"
+		s := natstest.RunServer(&opts)
+		defer s.Shutdown()
+
+		client, err := NewDefaultClient(WithUserPassword("connector", "s3cr3t"))
+
+		require.NoError(t, err)
+		require.True(t, client.conn.IsConnected())
+	})
+	t.Run("should publish to the configured jetstream domain", func(t *testing.T) {
+		opts := natstest.DefaultTestOptions
+		opts.JetStream = true
+		opts.JetStreamDomain = "hub"
+		opts.StoreDir = t.TempDir()
+		s := natstest.RunServer(&opts)
+		defer s.Shutdown()
+
+		client, err := NewDefaultClient(WithJetStreamDomain("hub"))
+		require.NoError(t, err)
+		err = client.AddStream(context.Background(), &AddStreamOptions{StreamName: "TEST"})
+		require.NoError(t, err)
+		err = client.Publish(context.Background(), &PublishOptions{Subj: "TEST.insert", MsgId: "123", Data: []byte("test")})
+
+		require.NoError(t, err)
+	})
+	t.Run("should return error cause the credentials are wrong", func(t *testing.T) {
+		opts := natstest.DefaultTestOptions
+		opts.Authorization = "s3cr3t"
+		s := natstest.RunServer(&opts)
+		defer s.Shutdown()
+
+		client, err := NewDefaultClient(WithToken("wrong"))
+
+		require.Nil(t, client)
+		require.ErrorContains(t, err, "could not connect to nats")
+	})
+	t.Run("should return error cause the nkey seed cannot be read", func(t *testing.T) {
+		s := natstest.RunDefaultServer()
+		defer s.Shutdown()
+
+		client, err := NewDefaultClient(WithNKeySeed(filepath.Join(t.TempDir(), "missing.nk")))
+
+		require.Nil(t, client)
+		require.ErrorContains(t, err, "could not read nkey seed")
+	})
 	t.Run("should return error cause nats is not available", func(t *testing.T) {
 		client, err := NewDefaultClient()
 
@@ -123,6 +185,44 @@ func TestClient_AddStream(t *testing.T) {
 		require.Contains(t, stream.Config.Subjects, "TEST.*")
 		require.Equal(t, nats.FileStorage, stream.Config.Storage)
 	})
+	t.Run("should add stream with the given config", func(t *testing.T) {
+		s := natstest.RunDefaultServer()
+		defer s.Shutdown()
+		_ = s.EnableJetStream(&natsserver.JetStreamConfig{StoreDir: t.TempDir()})
+		client, _ := NewDefaultClient()
+
+		err := client.AddStream(context.Background(), &AddStreamOptions{
+			StreamName:        "TEST",
+			Description:       "test stream",
+			Storage:           MemoryStorage,
+			Retention:         InterestRetention,
+			MaxAge:            time.Hour,
+			MaxBytes:          1024 * 1024,
+			MaxMsgs:           1000,
+			MaxMsgsPerSubject: 100,
+			MaxMsgSize:        1024,
+			Discard:           DiscardNew,
+			DuplicateWindow:   5 * time.Minute,
+			DenyDelete:        true,
+			DenyPurge:         true,
+		})
+
+		require.NoError(t, err)
+		stream, err := client.js.StreamInfo("TEST")
+		require.NoError(t, err)
+		require.Equal(t, "test stream", stream.Config.Description)
+		require.Equal(t, nats.MemoryStorage, stream.Config.Storage)
+		require.Equal(t, nats.InterestPolicy, stream.Config.Retention)
+		require.Equal(t, time.Hour, stream.Config.MaxAge)
+		require.Equal(t, int64(1024*1024), stream.Config.MaxBytes)
+		require.Equal(t, int64(1000), stream.Config.MaxMsgs)
+		require.Equal(t, int64(100), stream.Config.MaxMsgsPerSubject)
+		require.Equal(t, int32(1024), stream.Config.MaxMsgSize)
+		require.Equal(t, nats.DiscardNew, stream.Config.Discard)
+		require.Equal(t, 5*time.Minute, stream.Config.Duplicates)
+		require.True(t, stream.Config.DenyDelete)
+		require.True(t, stream.Config.DenyPurge)
+	})
 	t.Run("should return error cause nats is not available", func(t *testing.T) {
 		s := natstest.RunDefaultServer()
 		defer s.Shutdown()
@@ -136,11 +236,80 @@ func TestClient_AddStream(t *testing.T) {
 	})
 }
 
+func TestClient_AddStream_reconcile(t *testing.T) {
+	setup := func(t *testing.T) *DefaultClient {
+		s := natstest.RunDefaultServer()
+		t.Cleanup(s.Shutdown)
+		_ = s.EnableJetStream(&natsserver.JetStreamConfig{StoreDir: t.TempDir()})
+		client, _ := NewDefaultClient()
+		t.Cleanup(func() { _ = client.Close() })
+		err := client.AddStream(context.Background(), &AddStreamOptions{StreamName: "TEST", MaxAge: time.Hour})
+		require.NoError(t, err)
+		return client
+	}
+
+	t.Run("should leave the existing stream as it is by default", func(t *testing.T) {
+		client := setup(t)
+
+		err := client.AddStream(context.Background(), &AddStreamOptions{StreamName: "TEST", Storage: MemoryStorage})
+
+		require.NoError(t, err)
+		stream, _ := client.js.StreamInfo("TEST")
+		require.Equal(t, nats.FileStorage, stream.Config.Storage)
+		require.Equal(t, time.Hour, stream.Config.MaxAge)
+	})
+	t.Run("should apply the safe changes to the existing stream", func(t *testing.T) {
+		client := setup(t)
+
+		err := client.AddStream(context.Background(), &AddStreamOptions{
+			StreamName:      "TEST",
+			MaxAge:          2 * time.Hour,
+			DuplicateWindow: 10 * time.Minute,
+			Reconcile:       UpdateReconcile,
+		})
+
+		require.NoError(t, err)
+		stream, _ := client.js.StreamInfo("TEST")
+		require.Equal(t, 2*time.Hour, stream.Config.MaxAge)
+		require.Equal(t, 10*time.Minute, stream.Config.Duplicates)
+	})
+	t.Run("should refuse the destructive changes to the existing stream", func(t *testing.T) {
+		client := setup(t)
+
+		err := client.AddStream(context.Background(), &AddStreamOptions{
+			StreamName: "TEST",
+			MaxAge:     time.Minute,
+			MaxMsgs:    100,
+			Reconcile:  UpdateReconcile,
+		})
+
+		require.ErrorIs(t, err, ErrDestructiveChanges)
+		require.EqualError(t, err, "could not update nats stream TEST: destructive changes refused: "+
+			"[maxAge: 1h0m0s -> 1m0s maxMsgs: -1 -> 100]")
+		require.True(t, IsPermanent(err))
+		stream, _ := client.js.StreamInfo("TEST")
+		require.Equal(t, time.Hour, stream.Config.MaxAge)
+	})
+	t.Run("should only report how the existing stream differs when verifying", func(t *testing.T) {
+		client := setup(t)
+
+		err := client.AddStream(context.Background(), &AddStreamOptions{
+			StreamName: "TEST",
+			MaxAge:     time.Minute,
+			Reconcile:  VerifyReconcile,
+		})
+
+		require.NoError(t, err)
+		stream, _ := client.js.StreamInfo("TEST")
+		require.Equal(t, time.Hour, stream.Config.MaxAge)
+	})
+}
+
 func TestClient_Publish(t *testing.T) {
 	t.Run("should publish message based on the given options", func(t *testing.T) {
 		s := natstest.RunDefaultServer()
 		defer s.Shutdown()
-		_ = s.EnableJetStream(&natsserver.JetStreamConfig{})
+		_ = s.EnableJetStream(&natsserver.JetStreamConfig{StoreDir: t.TempDir()})
 		client, _ := NewDefaultClient()
 		_, _ = client.js.AddStream(&nats.StreamConfig{
 			Name:     "TEST",
@@ -148,28 +317,348 @@ func TestClient_Publish(t *testing.T) {
 			Storage:  nats.FileStorage,
 		})
 
+		err := client.Publish(context.Background(), &PublishOptions{
+			Subj:    "TEST.insert",
+			MsgId:   "123",
+			Data:    []byte("test"),
+			Headers: map[string]string{"Content-Type": "application/json"},
+		})
+
+		require.NoError(t, err)
+		sub, err := client.js.SubscribeSync("TEST.insert", nats.OrderedConsumer())
+		require.NoError(t, err)
+		msg, err := sub.NextMsg(5 * time.Second)
+		require.NoError(t, err)
+		require.Equal(t, "TEST.insert", msg.Subject)
+		require.Contains(t, msg.Header[nats.MsgIdHdr], "123")
+		require.Equal(t, "application/json", msg.Header.Get("Content-Type"))
+		require.Equal(t, []byte("test"), msg.Data)
+	})
+	t.Run("should return error cause nats is not available", func(t *testing.T) {
+		s := natstest.RunDefaultServer()
+		defer s.Shutdown()
+		_ = s.EnableJetStream(&natsserver.JetStreamConfig{})
+		client, _ := NewDefaultClient()
+		client.conn.Close()
+
 		err := client.Publish(context.Background(), &PublishOptions{
 			Subj:  "TEST.insert",
 			MsgId: "123",
 			Data:  []byte("test"),
 		})
 
+		require.Error(t, err)
+	})
+}
+
+func TestClient_PublishAsync(t *testing.T) {
+	t.Run("should publish message and call acked once acknowledged", func(t *testing.T) {
+		s := natstest.RunDefaultServer()
+		defer s.Shutdown()
+		_ = s.EnableJetStream(&natsserver.JetStreamConfig{StoreDir: t.TempDir()})
+		client, _ := NewDefaultClient()
+		_, _ = client.js.AddStream(&nats.StreamConfig{
+			Name:     "TEST",
+			Subjects: []string{"TEST.*"},
+			Storage:  nats.FileStorage,
+		})
+		acked := make(chan error, 1)
+
+		err := client.PublishAsync(context.Background(), &PublishOptions{
+			Subj:    "TEST.insert",
+			MsgId:   "123",
+			Data:    []byte("test"),
+			Headers: map[string]string{"Content-Type": "application/json"},
+		}, func(err error) { acked <- err })
+
 		require.NoError(t, err)
+		require.NoError(t, <-acked)
 		sub, err := client.js.SubscribeSync("TEST.insert", nats.OrderedConsumer())
 		require.NoError(t, err)
 		msg, err := sub.NextMsg(5 * time.Second)
 		require.NoError(t, err)
-		require.Equal(t, "TEST.insert", msg.Subject)
 		require.Contains(t, msg.Header[nats.MsgIdHdr], "123")
+		require.Equal(t, "application/json", msg.Header.Get("Content-Type"))
 		require.Equal(t, []byte("test"), msg.Data)
 	})
+	t.Run("should call acked with an error cause the message is rejected", func(t *testing.T) {
+		s := natstest.RunDefaultServer()
+		defer s.Shutdown()
+		_ = s.EnableJetStream(&natsserver.JetStreamConfig{StoreDir: t.TempDir()})
+		client, _ := NewDefaultClient()
+		_, _ = client.js.AddStream(&nats.StreamConfig{
+			Name:       "TEST",
+			Subjects:   []string{"TEST.*"},
+			Storage:    nats.FileStorage,
+			MaxMsgSize: 1,
+		})
+		acked := make(chan error, 1)
+
+		err := client.PublishAsync(context.Background(), &PublishOptions{
+			Subj:  "TEST.insert",
+			MsgId: "123",
+			Data:  []byte("test"),
+		}, func(err error) { acked <- err })
+
+		require.NoError(t, err)
+		err = <-acked
+		require.Error(t, err)
+		require.True(t, IsPermanent(err))
+	})
+}
+
+// BenchmarkClient_Publish measures the throughput of the messages published one at a time, waiting for each
+// acknowledgement, to be compared with BenchmarkClient_PublishAsync.
+func BenchmarkClient_Publish(b *testing.B) {
+	client := newBenchmarkClient(b)
+	ctx := context.Background()
+	data := make([]byte, 512)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := client.Publish(ctx, &PublishOptions{
+			Subj:  "BENCH.insert",
+			MsgId: strconv.Itoa(i),
+			Data:  data,
+		}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkClient_PublishAsync measures the throughput of the messages published with at most 256 messages waiting
+// for their acknowledgement, as the connector does with a publish window.
+func BenchmarkClient_PublishAsync(b *testing.B) {
+	client := newBenchmarkClient(b)
+	ctx := context.Background()
+	data := make([]byte, 512)
+	window := make(chan struct{}, 256)
+	errs := make(chan error, 1)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		window <- struct{}{}
+		if err := client.PublishAsync(ctx, &PublishOptions{
+			Subj:  "BENCH.insert",
+			MsgId: strconv.Itoa(i),
+			Data:  data,
+		}, func(err error) {
+			if err != nil {
+				select {
+				case errs <- err:
+				default:
+				}
+			}
+			<-window
+		}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for i := 0; i < cap(window); i++ {
+		window <- struct{}{}
+	}
+	select {
+	case err := <-errs:
+		b.Fatal(err)
+	default:
+	}
+}
+
+func newBenchmarkClient(b *testing.B) *DefaultClient {
+	s := natstest.RunDefaultServer()
+	b.Cleanup(s.Shutdown)
+	_ = s.EnableJetStream(&natsserver.JetStreamConfig{StoreDir: b.TempDir()})
+	client, err := NewDefaultClient(WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	require.NoError(b, err)
+	b.Cleanup(func() { _ = client.Close() })
+	_, err = client.js.AddStream(&nats.StreamConfig{
+		Name:     "BENCH",
+		Subjects: []string{"BENCH.*"},
+		Storage:  nats.FileStorage,
+	})
+	require.NoError(b, err)
+	return client
+}
+
+func TestClient_KeyValue(t *testing.T) {
+	t.Run("should create bucket and store values by key", func(t *testing.T) {
+		s := natstest.RunDefaultServer()
+		defer s.Shutdown()
+		_ = s.EnableJetStream(&natsserver.JetStreamConfig{StoreDir: t.TempDir()})
+		client, _ := NewDefaultClient()
+		ctx := context.Background()
+
+		kv, err := client.KeyValue(ctx, &KeyValueOptions{Bucket: "checkpoints"})
+
+		require.NoError(t, err)
+		_, err = client.js.KeyValue("checkpoints")
+		require.NoError(t, err)
+		value, err := kv.Get(ctx, "coll1")
+		require.NoError(t, err)
+		require.Nil(t, value)
+		require.NoError(t, kv.Put(ctx, "coll1", []byte("test")))
+		value, err = kv.Get(ctx, "coll1")
+		require.NoError(t, err)
+		require.Equal(t, []byte("test"), value)
+		require.NoError(t, kv.Delete(ctx, "coll1"))
+		value, err = kv.Get(ctx, "coll1")
+		require.NoError(t, err)
+		require.Nil(t, value)
+	})
+	t.Run("should write values by key only at the expected revision", func(t *testing.T) {
+		s := natstest.RunDefaultServer()
+		defer s.Shutdown()
+		_ = s.EnableJetStream(&natsserver.JetStreamConfig{StoreDir: t.TempDir()})
+		client, _ := NewDefaultClient()
+		ctx := context.Background()
+
+		kv, err := client.KeyValue(ctx, &KeyValueOptions{Bucket: "leases", Ttl: time.Minute})
+
+		require.NoError(t, err)
+		bucket, _ := client.js.KeyValue("leases")
+		status, _ := bucket.Status()
+		require.Equal(t, time.Minute, status.TTL())
+		revision, err := kv.Create(ctx, "coll1", []byte("instance1"))
+		require.NoError(t, err)
+		_, err = kv.Create(ctx, "coll1", []byte("instance2"))
+		require.ErrorIs(t, err, ErrKeyValueConflict)
+		_, err = kv.Update(ctx, "coll1", []byte("instance2"), revision+1)
+		require.ErrorIs(t, err, ErrKeyValueConflict)
+		revision, err = kv.Update(ctx, "coll1", []byte("instance1"), revision)
+		require.NoError(t, err)
+		require.ErrorIs(t, kv.DeleteRevision(ctx, "coll1", revision-1), ErrKeyValueConflict)
+		require.NoError(t, kv.DeleteRevision(ctx, "coll1", revision))
+		_, err = kv.Create(ctx, "coll1", []byte("instance2"))
+		require.NoError(t, err)
+	})
+	t.Run("should list the keys matching the given filter", func(t *testing.T) {
+		s := natstest.RunDefaultServer()
+		defer s.Shutdown()
+		_ = s.EnableJetStream(&natsserver.JetStreamConfig{StoreDir: t.TempDir()})
+		client, _ := NewDefaultClient()
+		ctx := context.Background()
+		kv, _ := client.KeyValue(ctx, &KeyValueOptions{Bucket: "leases"})
+
+		keys, err := kv.Keys(ctx, "members.>")
+
+		require.NoError(t, err)
+		require.Empty(t, keys)
+		_ = kv.Put(ctx, "members.instance1", []byte("1"))
+		_ = kv.Put(ctx, "members.instance2", []byte("2"))
+		_ = kv.Put(ctx, "watchers.coll1", []byte("instance1"))
+		_ = kv.Delete(ctx, "members.instance2")
+		keys, err = kv.Keys(ctx, "members.>")
+		require.NoError(t, err)
+		require.Equal(t, []string{"members.instance1"}, keys)
+	})
+	t.Run("should bind to an existing bucket", func(t *testing.T) {
+		s := natstest.RunDefaultServer()
+		defer s.Shutdown()
+		_ = s.EnableJetStream(&natsserver.JetStreamConfig{StoreDir: t.TempDir()})
+		client, _ := NewDefaultClient()
+		ctx := context.Background()
+		existing, _ := client.js.CreateKeyValue(&nats.KeyValueConfig{Bucket: "checkpoints"})
+		_, _ = existing.Put("coll1", []byte("test"))
+
+		kv, err := client.KeyValue(ctx, &KeyValueOptions{Bucket: "checkpoints"})
+
+		require.NoError(t, err)
+		value, err := kv.Get(ctx, "coll1")
+		require.NoError(t, err)
+		require.Equal(t, []byte("test"), value)
+	})
+	t.Run("should update the ttl of an existing bucket", func(t *testing.T) {
+		s := natstest.RunDefaultServer()
+		defer s.Shutdown()
+		_ = s.EnableJetStream(&natsserver.JetStreamConfig{StoreDir: t.TempDir()})
+		client, _ := NewDefaultClient()
+		ctx := context.Background()
+		_, _ = client.js.CreateKeyValue(&nats.KeyValueConfig{Bucket: "leases"})
+
+		_, err := client.KeyValue(ctx, &KeyValueOptions{Bucket: "leases", Ttl: 30 * time.Second})
+
+		require.NoError(t, err)
+		bucket, _ := client.js.KeyValue("leases")
+		status, _ := bucket.Status()
+		require.Equal(t, 30*time.Second, status.TTL())
+	})
 	t.Run("should return error cause nats is not available", func(t *testing.T) {
 		s := natstest.RunDefaultServer()
 		defer s.Shutdown()
-		_ = s.EnableJetStream(&natsserver.JetStreamConfig{})
+		_ = s.EnableJetStream(&natsserver.JetStreamConfig{StoreDir: t.TempDir()})
 		client, _ := NewDefaultClient()
 		client.conn.Close()
 
+		kv, err := client.KeyValue(context.Background(), &KeyValueOptions{Bucket: "checkpoints"})
+
+		require.Nil(t, kv)
+		require.Error(t, err)
+	})
+}
+
+func TestClient_LastMsgId(t *testing.T) {
+	t.Run("should return the msg id of the last message of the stream", func(t *testing.T) {
+		s := natstest.RunDefaultServer()
+		defer s.Shutdown()
+		_ = s.EnableJetStream(&natsserver.JetStreamConfig{StoreDir: t.TempDir()})
+		client, _ := NewDefaultClient()
+		_ = client.AddStream(context.Background(), &AddStreamOptions{StreamName: "TEST"})
+		_, _ = client.js.Publish("TEST.insert", []byte("test"), nats.MsgId("123"))
+		_, _ = client.js.Publish("TEST.update", []byte("test"), nats.MsgId("456"))
+
+		msgId, err := client.LastMsgId(context.Background(), "TEST")
+
+		require.NoError(t, err)
+		require.Equal(t, "456", msgId)
+	})
+	t.Run("should return an empty msg id if the stream has no messages", func(t *testing.T) {
+		s := natstest.RunDefaultServer()
+		defer s.Shutdown()
+		_ = s.EnableJetStream(&natsserver.JetStreamConfig{StoreDir: t.TempDir()})
+		client, _ := NewDefaultClient()
+		_ = client.AddStream(context.Background(), &AddStreamOptions{StreamName: "TEST"})
+
+		msgId, err := client.LastMsgId(context.Background(), "TEST")
+
+		require.NoError(t, err)
+		require.Empty(t, msgId)
+	})
+	t.Run("should return an empty msg id if the stream does not exist", func(t *testing.T) {
+		s := natstest.RunDefaultServer()
+		defer s.Shutdown()
+		_ = s.EnableJetStream(&natsserver.JetStreamConfig{StoreDir: t.TempDir()})
+		client, _ := NewDefaultClient()
+
+		msgId, err := client.LastMsgId(context.Background(), "TEST")
+
+		require.NoError(t, err)
+		require.Empty(t, msgId)
+	})
+}
+
+func TestIsPermanent(t *testing.T) {
+	t.Run("should classify a message that exceeds the maximum payload as permanent", func(t *testing.T) {
+		s := natstest.RunDefaultServer()
+		defer s.Shutdown()
+		_ = s.EnableJetStream(&natsserver.JetStreamConfig{StoreDir: t.TempDir()})
+		client, _ := NewDefaultClient()
+		_ = client.AddStream(context.Background(), &AddStreamOptions{StreamName: "TEST"})
+
+		err := client.Publish(context.Background(), &PublishOptions{
+			Subj:  "TEST.insert",
+			MsgId: "123",
+			Data:  make([]byte, client.conn.MaxPayload()+1),
+		})
+
+		require.Error(t, err)
+		require.True(t, IsPermanent(err))
+	})
+	t.Run("should classify a message that is not received by any stream as transient", func(t *testing.T) {
+		s := natstest.RunDefaultServer()
+		defer s.Shutdown()
+		_ = s.EnableJetStream(&natsserver.JetStreamConfig{StoreDir: t.TempDir()})
+		client, _ := NewDefaultClient()
+
 		err := client.Publish(context.Background(), &PublishOptions{
 			Subj:  "TEST.insert",
 			MsgId: "123",
@@ -177,5 +666,6 @@ func TestClient_Publish(t *testing.T) {
 		})
 
 		require.Error(t, err)
+		require.False(t, IsPermanent(err))
 	})
 }
@@ -0,0 +1,128 @@
+package nats
+
+import (
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	FileStorage   = "file"
+	MemoryStorage = "memory"
+
+	LimitsRetention    = "limits"
+	InterestRetention  = "interest"
+	WorkQueueRetention = "workQueue"
+
+	DiscardOld = "old"
+	DiscardNew = "new"
+
+	// CreateOnlyReconcile creates the stream if it does not exist, an existing stream is used as it is.
+	CreateOnlyReconcile = "create-only"
+	// UpdateReconcile creates the stream if it does not exist, or applies the safe changes to an existing stream. The
+	// destructive changes are refused.
+	UpdateReconcile = "update"
+	// VerifyReconcile creates the stream if it does not exist, or reports how an existing stream differs from its
+	// configuration, without changing it.
+	VerifyReconcile = "verify"
+)
+
+// streamConfig returns the JetStream configuration of the stream described by the given options.
+func streamConfig(opts *AddStreamOptions) *nats.StreamConfig {
+	cfg := &nats.StreamConfig{
+		Name:              opts.StreamName,
+		Description:       opts.Description,
+		Subjects:          []string{fmt.Sprintf("%s.*", opts.StreamName)},
+		Storage:           nats.FileStorage,
+		Replicas:          opts.Replicas,
+		Retention:         nats.LimitsPolicy,
+		MaxConsumers:      -1,
+		MaxAge:            opts.MaxAge,
+		MaxBytes:          limit(opts.MaxBytes),
+		MaxMsgs:           limit(opts.MaxMsgs),
+		MaxMsgsPerSubject: limit(opts.MaxMsgsPerSubject),
+		MaxMsgSize:        int32(limit(int64(opts.MaxMsgSize))),
+		Discard:           nats.DiscardOld,
+		Duplicates:        opts.DuplicateWindow,
+		DenyDelete:        opts.DenyDelete,
+		DenyPurge:         opts.DenyPurge,
+	}
+	if opts.Storage == MemoryStorage {
+		cfg.Storage = nats.MemoryStorage
+	}
+	switch opts.Retention {
+	case InterestRetention:
+		cfg.Retention = nats.InterestPolicy
+	case WorkQueueRetention:
+		cfg.Retention = nats.WorkQueuePolicy
+	}
+	if opts.Discard == DiscardNew {
+		cfg.Discard = nats.DiscardNew
+	}
+	if opts.PlacementCluster != "" || len(opts.PlacementTags) > 0 {
+		cfg.Placement = &nats.Placement{Cluster: opts.PlacementCluster, Tags: opts.PlacementTags}
+	}
+	return cfg
+}
+
+// limit returns the given limit, or -1 (unlimited) if it is not set.
+func limit(n int64) int64 {
+	if n <= 0 {
+		return -1
+	}
+	return n
+}
+
+// streamChange is a difference between the configuration of an existing stream and the desired one.
+type streamChange struct {
+	field       string
+	current     any
+	desired     any
+	destructive bool
+}
+
+func (c streamChange) String() string {
+	return fmt.Sprintf("%s: %v -> %v", c.field, c.current, c.desired)
+}
+
+// diffStream returns the differences between the current and the desired configuration of a stream. The changes that
+// JetStream refuses, or that would remove messages from the stream, such as lowering its limits, are destructive.
+func diffStream(current, desired *nats.StreamConfig) []streamChange {
+	var changes []streamChange
+	add := func(field string, current, desired any, destructive bool) {
+		if current != desired {
+			changes = append(changes, streamChange{field: field, current: current, desired: desired,
+				destructive: destructive})
+		}
+	}
+	add("description", current.Description, desired.Description, false)
+	add("storage", current.Storage, desired.Storage, true)
+	add("replicas", max(current.Replicas, 1), max(desired.Replicas, 1), false)
+	add("retention", current.Retention, desired.Retention, true)
+	add("maxAge", current.MaxAge, desired.MaxAge, lowered(int64(current.MaxAge), int64(desired.MaxAge), 0))
+	add("maxBytes", current.MaxBytes, desired.MaxBytes, lowered(current.MaxBytes, desired.MaxBytes, -1))
+	add("maxMsgs", current.MaxMsgs, desired.MaxMsgs, lowered(current.MaxMsgs, desired.MaxMsgs, -1))
+	add("maxMsgsPerSubject", current.MaxMsgsPerSubject, desired.MaxMsgsPerSubject,
+		lowered(current.MaxMsgsPerSubject, desired.MaxMsgsPerSubject, -1))
+	add("maxMsgSize", current.MaxMsgSize, desired.MaxMsgSize, false)
+	add("discard", current.Discard, desired.Discard, false)
+	if desired.Duplicates > 0 {
+		add("duplicateWindow", current.Duplicates, desired.Duplicates, false)
+	}
+	add("placement", placement(current.Placement), placement(desired.Placement), true)
+	add("denyDelete", current.DenyDelete, desired.DenyDelete, true)
+	add("denyPurge", current.DenyPurge, desired.DenyPurge, true)
+	return changes
+}
+
+// lowered tells whether the desired limit is lower than the current one, given the value meaning unlimited.
+func lowered(current, desired, unlimited int64) bool {
+	return desired != unlimited && (current == unlimited || desired < current)
+}
+
+func placement(p *nats.Placement) string {
+	if p == nil {
+		return "none"
+	}
+	return fmt.Sprintf("cluster=%s tags=%v", p.Cluster, p.Tags)
+}
@@ -0,0 +1,51 @@
+package nats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffStream(t *testing.T) {
+	current := streamConfig(&AddStreamOptions{StreamName: "TEST", MaxAge: time.Hour, MaxBytes: 1024,
+		DuplicateWindow: 2 * time.Minute})
+
+	tests := []struct {
+		name    string
+		desired *AddStreamOptions
+		want    []streamChange
+	}{
+		{
+			name:    "should return no change if the configurations match",
+			desired: &AddStreamOptions{StreamName: "TEST", MaxAge: time.Hour, MaxBytes: 1024, DuplicateWindow: 2 * time.Minute},
+			want:    nil,
+		},
+		{
+			name: "should return the raised limits as safe changes",
+			desired: &AddStreamOptions{StreamName: "TEST", MaxAge: 2 * time.Hour, Replicas: 3,
+				DuplicateWindow: 2 * time.Minute},
+			want: []streamChange{
+				{field: "replicas", current: 1, desired: 3},
+				{field: "maxAge", current: time.Hour, desired: 2 * time.Hour},
+				{field: "maxBytes", current: int64(1024), desired: int64(-1)},
+			},
+		},
+		{
+			name: "should return the lowered limits and the immutable fields as destructive changes",
+			desired: &AddStreamOptions{StreamName: "TEST", Storage: MemoryStorage, MaxAge: time.Minute, MaxBytes: 1024,
+				DuplicateWindow: 2 * time.Minute},
+			want: []streamChange{
+				{field: "storage", current: nats.FileStorage, desired: nats.MemoryStorage, destructive: true},
+				{field: "maxAge", current: time.Hour, desired: time.Minute, destructive: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, diffStream(current, streamConfig(tt.desired)))
+		})
+	}
+}
@@ -2,9 +2,14 @@ package server
 
 import (
 	"context"
+	"errors"
 	"net/http"
 )
 
+// ErrStandby is returned by the monitors of the components that are healthy, but waiting to take over from another
+// instance.
+var ErrStandby = errors.New("standing by")
+
 type NamedMonitor interface {
 	Name() string
 	Monitor(ctx context.Context) error
@@ -16,6 +21,8 @@ func healthCheck(monitors ...NamedMonitor) http.HandlerFunc {
 		for _, monitor := range monitors {
 			if err := monitor.Monitor(r.Context()); err == nil {
 				components[monitor.Name()] = monitoredComponents{Status: UP}
+			} else if errors.Is(err, ErrStandby) {
+				components[monitor.Name()] = monitoredComponents{Status: STANDBY}
 			} else {
 				components[monitor.Name()] = monitoredComponents{Status: DOWN}
 			}
@@ -36,8 +43,9 @@ type healthResponse struct {
 type health string
 
 const (
-	UP   health = "UP"
-	DOWN health = "DOWN"
+	UP      health = "UP"
+	DOWN    health = "DOWN"
+	STANDBY health = "STANDBY"
 )
 
 type monitoredComponents struct {
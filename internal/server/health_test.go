@@ -60,6 +60,22 @@ func Test_healthCheck(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:   "should write a json response with component status standby, if it was waiting to take over",
+			fields: fields{monitors: []NamedMonitor{&testComponent{name: "test", err: ErrStandby}}},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodGet, "/healthz", nil),
+			},
+			wantCode:        200,
+			wantContentType: "application/json",
+			wantBody: healthResponse{
+				Status: UP,
+				Components: map[string]monitoredComponents{
+					"test": {Status: STANDBY},
+				},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
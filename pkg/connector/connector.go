@@ -2,14 +2,23 @@ package connector
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"os"
 	"os/signal"
+	"path"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/damianiandrea/mongodb-nats-connector/internal/mongo"
@@ -23,15 +32,104 @@ const (
 	defaultTokensDbName                 = "resume-tokens"
 	defaultTokensCollCapped             = false
 	defaultTokensCollSizeInBytes        = 0
+	defaultFullDocument                 = "updateLookup"
+	defaultFullDocumentBeforeChange     = "whenAvailable"
+	defaultDeploymentTokensCollName     = "deployment"
+	defaultDeploymentStreamName         = "deployment"
+	defaultCheckpointStore              = mongoCheckpointStore
+	defaultCheckpointBucket             = "connector-checkpoints"
+	defaultCheckpointDir                = "checkpoints"
+	defaultRestartDelay                 = 5 * time.Second
+	defaultMaxRestarts                  = -1 // unlimited
+	defaultLeaseBucket                  = "connector-leases"
+	defaultLeaseTtl                     = 15 * time.Second
+	defaultStreamStorage                = nats.FileStorage
+	defaultStreamReplicas               = 1
+	defaultStreamRetention              = nats.LimitsRetention
+	defaultStreamDiscard                = nats.DiscardOld
+	defaultStreamDuplicateWindow        = 2 * time.Minute
+	defaultStreamReconcile              = nats.CreateOnlyReconcile
+
+	mongoCheckpointStore  = "mongo"
+	natsCheckpointStore   = "nats"
+	fileCheckpointStore   = "file"
+	streamCheckpointStore = "stream"
+
+	// instanceHeader is the header of the published messages that holds the id of the Connector instance.
+	instanceHeader = "Connector-Instance"
 )
 
 var (
-	ErrDbNameMissing          = errors.New("invalid option: `dbName` is missing")
-	ErrCollNameMissing        = errors.New("invalid option: `collName` is missing")
-	ErrInvalidCollSizeInBytes = errors.New("invalid option: `collSizeInBytes` must be greater than 0")
-	ErrInvalidDbAndCollNames  = errors.New("invalid option: `dbName` and `tokensDbName` cannot be the same if `collName` and `tokensCollName` are the same")
+	ErrDbNameMissing                    = errors.New("invalid option: `dbName` is missing")
+	ErrCollNameMissing                  = errors.New("invalid option: `collName` is missing")
+	ErrInvalidCollSizeInBytes           = errors.New("invalid option: `collSizeInBytes` must be greater than 0")
+	ErrInvalidDbAndCollNames            = errors.New("invalid option: `dbName` and `tokensDbName` cannot be the same if `collName` and `tokensCollName` are the same")
+	ErrInvalidCollsFilter               = errors.New("invalid option: `includeColls` and `excludeColls` can only be used when watching a database or a deployment")
+	ErrInvalidSnapshot                  = errors.New("invalid option: `snapshot` can only be used when watching a collection")
+	ErrInvalidOperationTime             = errors.New("invalid option: `startAtOperationTime` must be an RFC3339 date or an extended json timestamp")
+	ErrInvalidStartPosition             = errors.New("invalid option: only one of `startAtOperationTime`, `resumeAfter` and `startAfter` can be set")
+	ErrInvalidSnapshotStartPosition     = errors.New("invalid option: `snapshot` cannot be used with `startAtOperationTime`, `resumeAfter` or `startAfter`")
+	ErrInvalidFullDocument              = errors.New("invalid option: `fullDocument` must be one of default, off, updateLookup, whenAvailable or required")
+	ErrInvalidFullDocumentBeforeChange  = errors.New("invalid option: `fullDocumentBeforeChange` must be one of default, off, whenAvailable or required")
+	ErrPreAndPostImagesRequired         = errors.New("invalid option: `changeStreamPreAndPostImages` must be enabled when `fullDocument` is whenAvailable or required, or when `fullDocumentBeforeChange` is required")
+	ErrInvalidCheckpointEvents          = errors.New("invalid option: `checkpointEvents` must be greater than 0")
+	ErrInvalidCheckpointInterval        = errors.New("invalid option: `checkpointIntervalMs` must be greater than 0")
+	ErrInvalidTokensRetentionCount      = errors.New("invalid option: `tokensRetentionCount` must be greater than 0")
+	ErrInvalidTokensRetentionTtl        = errors.New("invalid option: `tokensRetentionTtlSeconds` must be greater than 0")
+	ErrInvalidTokensRetentionInterval   = errors.New("invalid option: `tokensRetentionTtlSeconds` must be longer than the checkpoint interval, which is a minute if `checkpointIntervalMs` is not set")
+	ErrInvalidTokensRetention           = errors.New("invalid option: `tokensRetention` can only be used if `tokensCollCapped` is false")
+	ErrInvalidOnHistoryLost             = errors.New("invalid option: `onHistoryLost` must be one of fail, resumeFromNow or resnapshot")
+	ErrInvalidFormat                    = errors.New("invalid option: `format` must be one of relaxedExtJSON, canonicalExtJSON, bson or json")
+	ErrInvalidEnvelope                  = errors.New("invalid option: `envelope` must be one of none, cloudEventsBinary, cloudEventsStructured or debezium")
+	ErrInvalidDebeziumFormat            = errors.New("invalid option: `format` cannot be bson when `envelope` is debezium")
+	ErrInvalidResnapshot                = errors.New("invalid option: `onHistoryLost` can only be resnapshot when watching a collection")
+	ErrInvalidCheckpointBucket          = errors.New("invalid option: `checkpointBucket` must only contain letters, digits, '-' and '_'")
+	ErrInvalidStreamCheckpointStore     = errors.New("invalid option: `checkpointStore` can only be stream when watching a collection")
+	ErrInvalidFollowRenames             = errors.New("invalid option: `followRenames` can only be used when watching a collection")
+	ErrInvalidRedactionPath             = errors.New("invalid option: redaction `path` must be a dotted field path, whose fields can be glob patterns")
+	ErrInvalidRedactionKeepLast         = errors.New("invalid option: redaction `keepLast` must be greater than 0")
+	ErrInvalidRetryInitialDelay         = errors.New("invalid option: `retryInitialDelayMs` must be greater than 0")
+	ErrInvalidRetryMaxDelay             = errors.New("invalid option: `retryMaxDelayMs` must be greater than 0")
+	ErrInvalidRetryMaxAttempts          = errors.New("invalid option: `retryMaxAttempts` must be greater than 0")
+	ErrInvalidRestartDelay              = errors.New("invalid option: `restartDelayMs` must be greater than 0")
+	ErrInvalidMaxRestarts               = errors.New("invalid option: `maxRestarts` must not be negative")
+	ErrInvalidLeaseBucket               = errors.New("invalid option: leader election `bucket` must only contain letters, digits, '-' and '_'")
+	ErrInvalidLeaseTtl                  = errors.New("invalid option: leader election `leaseTtlMs` must be at least 1s")
+	ErrInvalidInstanceId                = errors.New("invalid option: `instanceId` must only contain letters, digits, '-', '_', '=' and '.'")
+	ErrInvalidDistribution              = errors.New("invalid option: `distribute` can only be used with leader election")
+	ErrInvalidDistributedFileStore      = errors.New("invalid option: `checkpointStore` cannot be file when the watchers are distributed")
+	ErrInvalidStreamStorage             = errors.New("invalid option: stream `storage` must be one of file or memory")
+	ErrInvalidStreamReplicas            = errors.New("invalid option: stream `replicas` must be between 1 and 5")
+	ErrInvalidStreamRetention           = errors.New("invalid option: stream `retention` must be one of limits, interest or workQueue")
+	ErrInvalidStreamDiscard             = errors.New("invalid option: stream `discard` must be one of old or new")
+	ErrInvalidStreamMaxAge              = errors.New("invalid option: stream `maxAgeMs` must be greater than 0")
+	ErrInvalidStreamMaxBytes            = errors.New("invalid option: stream `maxBytes` must be greater than 0")
+	ErrInvalidStreamMaxMsgs             = errors.New("invalid option: stream `maxMsgs` must be greater than 0")
+	ErrInvalidStreamMaxMsgsPerSubject   = errors.New("invalid option: stream `maxMsgsPerSubject` must be greater than 0")
+	ErrInvalidStreamMaxMsgSize          = errors.New("invalid option: stream `maxMsgSize` must be greater than 0")
+	ErrInvalidStreamDuplicateWindow     = errors.New("invalid option: stream `duplicateWindowMs` must be greater than 0 and not greater than `maxAgeMs`")
+	ErrInvalidStreamReconcile           = errors.New("invalid option: stream `reconcile` must be one of create-only, update or verify")
+	ErrInvalidStreamCheckpointRetention = errors.New("invalid option: `checkpointStore` can only be stream when the stream `retention` is limits")
+	ErrInvalidStreamCheckpointMaxAge    = errors.New("invalid option: `checkpointStore` cannot be stream when the stream `maxAgeMs` is set")
+	ErrInvalidPublishWindow             = errors.New("invalid option: `publishWindow` must be greater than 0")
+	ErrInvalidStreamCheckpointWindow    = errors.New("invalid option: `checkpointStore` cannot be stream when `publishWindow` is greater than 1")
+	ErrInvalidMongoTLS                  = errors.New("invalid option: the mongo tls `certFile` and `keyFile` must be set together")
+	ErrInvalidMongoAuthMechanism        = errors.New("invalid option: mongo auth `mechanism` must be one of SCRAM-SHA-1, SCRAM-SHA-256, MONGODB-X509 or MONGODB-AWS")
+	ErrInvalidMongoPoolSize             = errors.New("invalid option: mongo `minPoolSize` must not be greater than `maxPoolSize`")
+	ErrInvalidMongoMaxConnIdleTime      = errors.New("invalid option: mongo `maxConnIdleTimeMs` must not be negative")
+	ErrInvalidMongoReadConcern          = errors.New("invalid option: mongo `readConcern` must be one of local, available, majority, linearizable or snapshot")
+	ErrInvalidMongoCompressors          = errors.New("invalid option: mongo `compressors` must be snappy, zlib or zstd")
+	ErrInvalidNatsAuth                  = errors.New("invalid option: only one of the nats `credsFile`, `nkeySeedFile`, `token` and `user` can be set")
+	ErrInvalidNatsTLS                   = errors.New("invalid option: the nats tls `certFile` and `keyFile` must be set together")
+	ErrInvalidNatsJetStream             = errors.New("invalid option: only one of `jetStreamDomain` and `jetStreamApiPrefix` can be set")
+	ErrInvalidPipeline                  = errors.New("invalid option: `pipeline` must be an extended json array of change stream stages")
 )
 
+// supportedPipelineStages contains the aggregation stages that MongoDB allows in a change stream pipeline.
+var supportedPipelineStages = []string{
+	"$addFields", "$match", "$project", "$replaceRoot", "$replaceWith", "$redact", "$set", "$unset",
+}
+
 // The Connector type represents a connector between MongoDB and NATS.
 type Connector struct {
 
@@ -43,6 +141,18 @@ type Connector struct {
 
 	// server represents the HTTP server used by the Connector.
 	server *server.Server
+
+	// streams contains the names of the NATS streams that have already been created by the Connector.
+	streams sync.Map
+
+	// watchers contains a watcher for each configured collection, database or deployment.
+	watchers []*watcher
+
+	// leases represents the NATS KV bucket holding the leases of the watchers, if leader election is enabled.
+	leases nats.KeyValue
+
+	// members keeps track of the Connector instances the watchers are distributed between, if enabled.
+	members *membership
 }
 
 // New creates a new Connector.
@@ -57,6 +167,15 @@ func New(opts ...Option) (*Connector, error) {
 			return nil, err
 		}
 	}
+	if c.options.distribution && !c.options.leaderElection {
+		return nil, ErrInvalidDistribution
+	}
+	if c.options.distribution && slices.ContainsFunc(c.options.collections, func(coll *collection) bool {
+		return coll.checkpointStore == fileCheckpointStore
+	}) {
+		// a moved watcher could not read the checkpoints stored by its previous owner
+		return nil, ErrInvalidDistributedFileStore
+	}
 
 	loggerOpts := &slog.HandlerOptions{Level: c.options.logLevel}
 	c.logger = slog.New(slog.NewJSONHandler(os.Stdout, loggerOpts))
@@ -65,6 +184,7 @@ func New(opts ...Option) (*Connector, error) {
 		mongoClient, err := mongo.NewDefaultClient(
 			mongo.WithMongoUri(c.options.mongoUri),
 			mongo.WithLogger(c.logger),
+			mongo.WithConnectionOptions(c.options.mongoConnection),
 		)
 		if err != nil {
 			return nil, err
@@ -76,6 +196,13 @@ func New(opts ...Option) (*Connector, error) {
 		natsClient, err := nats.NewDefaultClient(
 			nats.WithNatsUrl(c.options.natsUrl),
 			nats.WithLogger(c.logger),
+			nats.WithCredentials(c.options.natsCredsFile),
+			nats.WithNKeySeed(c.options.natsNKeySeedFile),
+			nats.WithToken(c.options.natsToken),
+			nats.WithUserPassword(c.options.natsUser, c.options.natsPassword),
+			nats.WithTLS(c.options.natsTLSCertFile, c.options.natsTLSKeyFile, c.options.natsTLSCaFile),
+			nats.WithJetStreamDomain(c.options.natsJsDomain),
+			nats.WithJetStreamApiPrefix(c.options.natsJsApiPrefix),
 		)
 		if err != nil {
 			return nil, err
@@ -85,10 +212,17 @@ func New(opts ...Option) (*Connector, error) {
 
 	c.options.ctx, c.options.stop = signal.NotifyContext(c.options.ctx, syscall.SIGINT, syscall.SIGTERM)
 
+	monitors := []server.NamedMonitor{c.options.mongoClient, c.options.natsClient}
+	for _, coll := range c.options.collections {
+		w := newWatcher(coll, c.logger)
+		c.watchers = append(c.watchers, w)
+		monitors = append(monitors, w)
+	}
+
 	c.server = server.New(
 		server.WithAddr(c.options.serverAddr),
 		server.WithContext(c.options.ctx),
-		server.WithNamedMonitors(c.options.mongoClient, c.options.natsClient),
+		server.WithNamedMonitors(monitors...),
 		server.WithLogger(c.logger),
 	)
 
@@ -96,13 +230,21 @@ func New(opts ...Option) (*Connector, error) {
 }
 
 // Run runs the Connector.
-// It performs the following operations:
+// It performs the following operations, each watcher in its own goroutine:
 //
 //	For each configured collection to be watched:
 //		- It creates the given collection on MongoDB, if it does not already exist
-//		- It creates the resume tokens collection for the given collection on MongoDB, if it does not already exist
+//		- It creates the resume tokens collection for the given collection on MongoDB, if it does not already exist,
+//		  or the checkpoints bucket on NATS, or the checkpoints directory, depending on the checkpoint store
 //		- It creates the given stream on NATS, if it does not already exist
-//		- Spins up a goroutine to watch the given collection
+//		- It watches the given collection
+//	For each configured database or deployment to be watched:
+//		- It creates the resume tokens collection on MongoDB, if it does not already exist, or the checkpoints bucket
+//		  on NATS, or the checkpoints directory, depending on the checkpoint store
+//		- It watches the given database or deployment, creating the streams on NATS as the change events of new
+//		  collections are received
+//	A failed watcher is restarted according to the restart policy of its collection, while the others keep running,
+//	unless the Connector has been configured to fail fast.
 //	It runs an HTTP server in its own goroutine.
 //	It runs another goroutine that will perform graceful shutdown once the Connector's context is cancelled.
 func (c *Connector) Run() error {
@@ -110,51 +252,39 @@ func (c *Connector) Run() error {
 
 	group, groupCtx := errgroup.WithContext(c.options.ctx)
 
-	for _, _coll := range c.options.collections {
-		coll := _coll // to avoid unexpected behavior
-
-		createWatchedCollOpts := &mongo.CreateCollectionOptions{
-			DbName:                       coll.dbName,
-			CollName:                     coll.collName,
-			ChangeStreamPreAndPostImages: coll.changeStreamPreAndPostImages,
-		}
-		if err := c.options.mongoClient.CreateCollection(groupCtx, createWatchedCollOpts); err != nil {
-			return err
+	// the resume tokens collections must never be published when watching databases or deployments
+	tokensNamespaces := make([]mongo.Namespace, 0, len(c.options.collections))
+	for _, coll := range c.options.collections {
+		if coll.checkpointStore != mongoCheckpointStore {
+			continue
 		}
+		tokensNamespaces = append(tokensNamespaces, mongo.Namespace{DbName: coll.tokensDbName, CollName: coll.tokensCollName})
+	}
 
-		createResumeTokensCollOpts := &mongo.CreateCollectionOptions{
-			DbName:      coll.tokensDbName,
-			CollName:    coll.tokensCollName,
-			Capped:      coll.tokensCollCapped,
-			SizeInBytes: coll.tokensCollSizeInBytes,
-		}
-		if err := c.options.mongoClient.CreateCollection(groupCtx, createResumeTokensCollOpts); err != nil {
+	if c.options.leaderElection {
+		leasesOpts := &nats.KeyValueOptions{Bucket: c.options.leaseBucket, Ttl: c.options.leaseTtl}
+		leases, err := c.options.natsClient.KeyValue(groupCtx, leasesOpts)
+		if err != nil {
 			return err
 		}
-
-		addStreamOpts := &nats.AddStreamOptions{StreamName: coll.streamName}
-		if err := c.options.natsClient.AddStream(groupCtx, addStreamOpts); err != nil {
-			return err
+		c.leases = leases
+		if c.options.distribution {
+			c.members = newMembership(leases, c.options.instanceId, c.options.leaseTtl, c.logger)
+			// the instances already running are known before assigning the watchers, so that they are not moved
+			// back and forth on startup
+			if err := c.members.refresh(groupCtx); err != nil {
+				return err
+			}
+			group.Go(func() error {
+				return c.members.run(groupCtx)
+			})
 		}
+	}
 
+	for _, _w := range c.watchers {
+		w := _w // to avoid unexpected behavior
 		group.Go(func() error {
-			watchCollOpts := &mongo.WatchCollectionOptions{
-				WatchedDbName:          coll.dbName,
-				WatchedCollName:        coll.collName,
-				ResumeTokensDbName:     coll.tokensDbName,
-				ResumeTokensCollName:   coll.tokensCollName,
-				ResumeTokensCollCapped: coll.tokensCollCapped,
-				StreamName:             coll.streamName,
-				ChangeEventHandler: func(ctx context.Context, subj, msgId string, data []byte) error {
-					publishOpts := &nats.PublishOptions{
-						Subj:  subj,
-						MsgId: msgId,
-						Data:  data,
-					}
-					return c.options.natsClient.Publish(ctx, publishOpts)
-				},
-			}
-			return c.options.mongoClient.WatchCollection(groupCtx, watchCollOpts) // blocking call
+			return c.supervise(groupCtx, w, tokensNamespaces) // blocking call
 		})
 	}
 
@@ -170,6 +300,167 @@ func (c *Connector) Run() error {
 	return group.Wait()
 }
 
+// createCheckpointStore creates the store where the checkpoints of the given collection are saved. A nil store means
+// that they are saved in the resume tokens collection.
+func (c *Connector) createCheckpointStore(ctx context.Context, coll *collection) (mongo.CheckpointStore, error) {
+	switch coll.checkpointStore {
+	case natsCheckpointStore:
+		kv, err := c.options.natsClient.KeyValue(ctx, &nats.KeyValueOptions{Bucket: coll.checkpointBucket})
+		if err != nil {
+			return nil, err
+		}
+		return mongo.NewKeyValueCheckpointStore(kv), nil
+	case fileCheckpointStore:
+		return mongo.NewFileCheckpointStore(coll.checkpointDir)
+	case streamCheckpointStore:
+		return mongo.NewStreamCheckpointStore(c.options.natsClient.LastMsgId), nil
+	}
+
+	createResumeTokensCollOpts := &mongo.CreateCollectionOptions{
+		DbName:      coll.tokensDbName,
+		CollName:    coll.tokensCollName,
+		Capped:      coll.tokensCollCapped,
+		SizeInBytes: coll.tokensCollSizeInBytes,
+	}
+	if coll.tokensRetention.Mode == mongo.RetainTokensTtl {
+		createResumeTokensCollOpts.ExpireAfter = coll.tokensRetention.Ttl
+	}
+	if err := c.options.mongoClient.CreateCollection(ctx, createResumeTokensCollOpts); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (c *Connector) watch(ctx context.Context, w *watcher, checkpointStore mongo.CheckpointStore,
+	tokensNamespaces []mongo.Namespace) error {
+	coll := w.coll
+	changeEventHandler := func(ctx context.Context, event *mongo.ChangeEvent) error {
+		publishOpts, err := c.publishOptions(ctx, coll, event)
+		if err == nil {
+			err = c.options.natsClient.Publish(ctx, publishOpts)
+		}
+		return permanent(err)
+	}
+	var asyncChangeEventHandler mongo.AsyncChangeEventHandler
+	if coll.publishWindow > 1 {
+		asyncChangeEventHandler = func(ctx context.Context, event *mongo.ChangeEvent, acked func(err error)) error {
+			publishOpts, err := c.publishOptions(ctx, coll, event)
+			if err == nil {
+				err = c.options.natsClient.PublishAsync(ctx, publishOpts, func(err error) {
+					acked(permanent(err))
+				})
+			}
+			return permanent(err)
+		}
+	}
+
+	if coll.scope == collectionScope {
+		watchCollOpts := &mongo.WatchCollectionOptions{
+			WatchedDbName:            coll.dbName,
+			WatchedCollName:          coll.collName,
+			ResumeTokensDbName:       coll.tokensDbName,
+			ResumeTokensCollName:     coll.tokensCollName,
+			ResumeTokensCollCapped:   coll.tokensCollCapped,
+			ResumeTokensRetention:    coll.tokensRetention,
+			CheckpointStore:          checkpointStore,
+			CheckpointKey:            coll.checkpointKey(),
+			StreamName:               coll.streamName,
+			Pipeline:                 coll.pipeline,
+			Snapshot:                 coll.snapshot,
+			StartPosition:            w.startPosition(),
+			FullDocument:             coll.fullDocument,
+			FullDocumentBeforeChange: coll.fullDocumentBeforeChange,
+			CheckpointPolicy:         coll.checkpointPolicy,
+			OnHistoryLost:            coll.onHistoryLost,
+			ShowExpandedEvents:       coll.showExpandedEvents,
+			FollowRenames:            coll.followRenames,
+			Redaction:                coll.redaction,
+			RetryPolicy:              coll.retryPolicy,
+			Format:                   coll.format,
+			Envelope:                 coll.envelope,
+			PublishWindow:            coll.publishWindow,
+			ChangeEventHandler:       changeEventHandler,
+			AsyncChangeEventHandler:  asyncChangeEventHandler,
+			StoredTokenReplaced:      w.setStoredTokenReplaced,
+		}
+		return c.options.mongoClient.WatchCollection(ctx, watchCollOpts)
+	}
+
+	watchDbOpts := &mongo.WatchDatabaseOptions{
+		WatchedDbName:            coll.dbName,
+		IncludedCollNames:        coll.includedColls,
+		ExcludedCollNames:        coll.excludedColls,
+		ExcludedNamespaces:       tokensNamespaces,
+		ResumeTokensDbName:       coll.tokensDbName,
+		ResumeTokensCollName:     coll.tokensCollName,
+		ResumeTokensCollCapped:   coll.tokensCollCapped,
+		ResumeTokensRetention:    coll.tokensRetention,
+		CheckpointStore:          checkpointStore,
+		CheckpointKey:            coll.checkpointKey(),
+		StreamNameFunc:           coll.routeStreamName,
+		Pipeline:                 coll.pipeline,
+		StartPosition:            w.startPosition(),
+		FullDocument:             coll.fullDocument,
+		FullDocumentBeforeChange: coll.fullDocumentBeforeChange,
+		CheckpointPolicy:         coll.checkpointPolicy,
+		OnHistoryLost:            coll.onHistoryLost,
+		ShowExpandedEvents:       coll.showExpandedEvents,
+		Redaction:                coll.redaction,
+		RetryPolicy:              coll.retryPolicy,
+		Format:                   coll.format,
+		Envelope:                 coll.envelope,
+		PublishWindow:            coll.publishWindow,
+		ChangeEventHandler:       changeEventHandler,
+		AsyncChangeEventHandler:  asyncChangeEventHandler,
+		StoredTokenReplaced:      w.setStoredTokenReplaced,
+	}
+	return c.options.mongoClient.WatchDatabase(ctx, watchDbOpts)
+}
+
+// publishOptions returns the options to publish the given change event of the given collection, after creating its
+// stream if needed. The id of the Connector instance is added to the headers of the change event.
+func (c *Connector) publishOptions(ctx context.Context, coll *collection, event *mongo.ChangeEvent) (
+	*nats.PublishOptions, error) {
+	if err := c.addStream(ctx, coll, event.StreamName); err != nil {
+		return nil, err
+	}
+	headers := make(map[string]string, len(event.Headers)+1)
+	for key, value := range event.Headers {
+		headers[key] = value
+	}
+	headers[instanceHeader] = c.options.instanceId
+	return &nats.PublishOptions{
+		Subj:    event.Subj,
+		MsgId:   event.MsgId,
+		Data:    event.Data,
+		Headers: headers,
+	}, nil
+}
+
+// permanent marks the given publication error as permanent if retrying would not help, e.g. the change event exceeds
+// the maximum payload.
+func permanent(err error) error {
+	if nats.IsPermanent(err) {
+		return mongo.Permanent(err)
+	}
+	return err
+}
+
+// addStream creates the given stream on NATS, with the stream options of the given collection, unless it has already
+// been created by the Connector.
+func (c *Connector) addStream(ctx context.Context, coll *collection, streamName string) error {
+	if _, found := c.streams.Load(streamName); found {
+		return nil
+	}
+	addStreamOpts := coll.stream
+	addStreamOpts.StreamName = streamName
+	if err := c.options.natsClient.AddStream(ctx, &addStreamOpts); err != nil {
+		return err
+	}
+	c.streams.Store(streamName, struct{}{})
+	return nil
+}
+
 func (c *Connector) cleanup() {
 	c.closeClient(c.options.mongoClient)
 	c.closeClient(c.options.natsClient)
@@ -178,7 +469,7 @@ func (c *Connector) cleanup() {
 
 func (c *Connector) closeClient(closer io.Closer) {
 	if err := closer.Close(); err != nil {
-		c.logger.Error("could not close client", err)
+		c.logger.Error("could not close client", "err", err)
 	}
 }
 
@@ -192,12 +483,32 @@ type Options struct {
 	// mongoUri represents the Connector's MongoDB URI.
 	mongoUri string
 
+	// mongoConnection represents the options of the Connector's MongoDB connection, overriding those of its URI.
+	mongoConnection mongo.ConnectionOptions
+
 	// mongoClient represents the MongoDB client used by the Connector to connect to MongoDB.
 	mongoClient mongo.Client
 
 	// natsUrl represents the Connector's NATS URL.
 	natsUrl string
 
+	// natsCredsFile, natsNKeySeedFile, natsToken, natsUser and natsPassword represent the credentials used by the
+	// Connector to authenticate to NATS, at most one kind of which can be set.
+	natsCredsFile    string
+	natsNKeySeedFile string
+	natsToken        string
+	natsUser         string
+	natsPassword     string
+
+	// natsTLSCertFile, natsTLSKeyFile and natsTLSCaFile represent the TLS configuration of the NATS connection.
+	natsTLSCertFile string
+	natsTLSKeyFile  string
+	natsTLSCaFile   string
+
+	// natsJsDomain and natsJsApiPrefix represent the JetStream domain or API prefix where the Connector publishes.
+	natsJsDomain    string
+	natsJsApiPrefix string
+
 	// natsClient represents the NATS client used by the Connector to connect to NATS.
 	natsClient nats.Client
 
@@ -208,6 +519,22 @@ type Options struct {
 	// serverAddr represents the Connector's HTTP server address.
 	serverAddr string
 
+	// failFast tells whether the Connector must stop as soon as a watcher fails, instead of restarting it.
+	failFast bool
+
+	// leaderElection tells whether each watcher must only run on the Connector instance holding its lease, which is
+	// stored in the leaseBucket NATS KV bucket and expires after leaseTtl unless renewed.
+	leaderElection bool
+	leaseBucket    string
+	leaseTtl       time.Duration
+
+	// instanceId identifies the Connector instance holding a lease.
+	instanceId string
+
+	// distribution tells whether the watchers must be divided between the Connector instances sharing the leases
+	// bucket, instead of all being run by the first instance acquiring their leases.
+	distribution bool
+
 	// collections represents a slice containing the collections to be watched, with their own configuration.
 	collections []*collection
 }
@@ -216,10 +543,23 @@ func getDefaultOptions() Options {
 	return Options{
 		logLevel:    defaultLogLevel,
 		ctx:         context.Background(),
+		instanceId:  defaultInstanceId(),
 		collections: make([]*collection, 0),
 	}
 }
 
+// defaultInstanceId returns the hostname followed by a random suffix, so that the instances of the Connector running
+// on the same host can still be told apart.
+func defaultInstanceId() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "connector"
+	}
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return invalidCheckpointKeyChars.ReplaceAllString(fmt.Sprintf("%s-%x", hostname, suffix), "_")
+}
+
 // Option is used to configure the Connector.
 type Option func(*Options) error
 
@@ -250,6 +590,107 @@ func WithMongoUri(mongoUri string) Option {
 	}
 }
 
+// WithMongoAppName sets the application name of the Connector's MongoDB connection, as shown in the MongoDB logs.
+func WithMongoAppName(appName string) Option {
+	return func(o *Options) error {
+		if appName != "" {
+			o.mongoConnection.AppName = appName
+		}
+		return nil
+	}
+}
+
+// WithMongoTLS makes the Connector connect to MongoDB over TLS, presenting the given client certificate and key, if
+// set, and verifying the server certificate with the given CA file, if set, instead of the system ones. The TLS
+// settings of the MongoDB URI are replaced.
+func WithMongoTLS(certFile, keyFile, caFile string) Option {
+	return func(o *Options) error {
+		if (certFile == "") != (keyFile == "") {
+			return ErrInvalidMongoTLS
+		}
+		o.mongoConnection.TLSCertFile = certFile
+		o.mongoConnection.TLSKeyFile = keyFile
+		o.mongoConnection.TLSCaFile = caFile
+		return nil
+	}
+}
+
+// WithMongoAuth sets how the Connector authenticates to MongoDB, overriding the credentials of the MongoDB URI with
+// the given non-empty values. The mechanism can be one of the following:
+//   - 'SCRAM-SHA-1' or 'SCRAM-SHA-256', with a username and a password
+//   - 'MONGODB-X509', with the client certificate set by WithMongoTLS
+//   - 'MONGODB-AWS', with the AWS credentials found in the environment, or the given access key id and secret key
+func WithMongoAuth(mechanism, source, username, password string) Option {
+	return func(o *Options) error {
+		switch mechanism {
+		case "", mongo.ScramSha1AuthMechanism, mongo.ScramSha256AuthMechanism, mongo.X509AuthMechanism,
+			mongo.AwsAuthMechanism:
+		default:
+			return ErrInvalidMongoAuthMechanism
+		}
+		o.mongoConnection.AuthMechanism = mechanism
+		o.mongoConnection.AuthSource = source
+		o.mongoConnection.Username = username
+		o.mongoConnection.Password = password
+		return nil
+	}
+}
+
+// WithMongoPoolSize sets the minimum and maximum number of connections of the Connector's MongoDB connection pool.
+// A value of 0 keeps the setting of the MongoDB URI, or the driver default.
+func WithMongoPoolSize(minSize, maxSize uint64) Option {
+	return func(o *Options) error {
+		if maxSize > 0 && minSize > maxSize {
+			return ErrInvalidMongoPoolSize
+		}
+		o.mongoConnection.MinPoolSize = minSize
+		o.mongoConnection.MaxPoolSize = maxSize
+		return nil
+	}
+}
+
+// WithMongoMaxConnIdleTime sets how long a connection of the Connector's MongoDB connection pool can stay idle before
+// being closed.
+func WithMongoMaxConnIdleTime(maxConnIdleTime time.Duration) Option {
+	return func(o *Options) error {
+		if maxConnIdleTime < 0 {
+			return ErrInvalidMongoMaxConnIdleTime
+		}
+		o.mongoConnection.MaxConnIdleTime = maxConnIdleTime
+		return nil
+	}
+}
+
+// WithMongoReadConcern sets the read concern of the Connector's MongoDB connection. It can be one of 'local',
+// 'available', 'majority', 'linearizable' or 'snapshot'.
+func WithMongoReadConcern(level string) Option {
+	return func(o *Options) error {
+		switch level {
+		case "", "local", "available", "majority", "linearizable", "snapshot":
+			o.mongoConnection.ReadConcern = level
+		default:
+			return ErrInvalidMongoReadConcern
+		}
+		return nil
+	}
+}
+
+// WithMongoCompressors sets the compressors of the Connector's MongoDB connection, in order of preference, among
+// 'snappy', 'zlib' and 'zstd'.
+func WithMongoCompressors(compressors ...string) Option {
+	return func(o *Options) error {
+		for _, compressor := range compressors {
+			if compressor != "snappy" && compressor != "zlib" && compressor != "zstd" {
+				return ErrInvalidMongoCompressors
+			}
+		}
+		if len(compressors) > 0 {
+			o.mongoConnection.Compressors = compressors
+		}
+		return nil
+	}
+}
+
 // withMongoClient sets the Connector's MongoDB client implementation.
 // Used for testing.
 func withMongoClient(mongoClient mongo.Client) Option {
@@ -271,6 +712,106 @@ func WithNatsUrl(natsUrl string) Option {
 	}
 }
 
+// WithNatsCredentials makes the Connector authenticate to NATS with the given user credentials file (`.creds`).
+func WithNatsCredentials(credsFile string) Option {
+	return func(o *Options) error {
+		if credsFile != "" {
+			if o.hasNatsAuth() {
+				return ErrInvalidNatsAuth
+			}
+			o.natsCredsFile = credsFile
+		}
+		return nil
+	}
+}
+
+// WithNatsNKeySeed makes the Connector authenticate to NATS with the NKey seed stored in the given file.
+func WithNatsNKeySeed(seedFile string) Option {
+	return func(o *Options) error {
+		if seedFile != "" {
+			if o.hasNatsAuth() {
+				return ErrInvalidNatsAuth
+			}
+			o.natsNKeySeedFile = seedFile
+		}
+		return nil
+	}
+}
+
+// WithNatsToken makes the Connector authenticate to NATS with the given token.
+func WithNatsToken(token string) Option {
+	return func(o *Options) error {
+		if token != "" {
+			if o.hasNatsAuth() {
+				return ErrInvalidNatsAuth
+			}
+			o.natsToken = token
+		}
+		return nil
+	}
+}
+
+// WithNatsUserPassword makes the Connector authenticate to NATS with the given user and password.
+func WithNatsUserPassword(user, password string) Option {
+	return func(o *Options) error {
+		if user != "" {
+			if o.hasNatsAuth() {
+				return ErrInvalidNatsAuth
+			}
+			o.natsUser = user
+			o.natsPassword = password
+		}
+		return nil
+	}
+}
+
+// WithNatsTLS makes the Connector connect to NATS over TLS, presenting the given client certificate and key, if set,
+// and verifying the server certificate with the given CA file, if set, instead of the system ones.
+func WithNatsTLS(certFile, keyFile, caFile string) Option {
+	return func(o *Options) error {
+		if (certFile == "") != (keyFile == "") {
+			return ErrInvalidNatsTLS
+		}
+		o.natsTLSCertFile = certFile
+		o.natsTLSKeyFile = keyFile
+		o.natsTLSCaFile = caFile
+		return nil
+	}
+}
+
+// WithNatsJetStreamDomain makes the Connector publish to the JetStream of the given domain, e.g. a hub reached through
+// a leaf node. It cannot be used together with WithNatsJetStreamApiPrefix.
+func WithNatsJetStreamDomain(domain string) Option {
+	return func(o *Options) error {
+		if domain != "" {
+			if o.natsJsApiPrefix != "" {
+				return ErrInvalidNatsJetStream
+			}
+			o.natsJsDomain = domain
+		}
+		return nil
+	}
+}
+
+// WithNatsJetStreamApiPrefix makes the Connector publish to the JetStream whose API is imported with the given prefix,
+// e.g. from another account. It cannot be used together with WithNatsJetStreamDomain.
+func WithNatsJetStreamApiPrefix(prefix string) Option {
+	return func(o *Options) error {
+		if prefix != "" {
+			if o.natsJsDomain != "" {
+				return ErrInvalidNatsJetStream
+			}
+			o.natsJsApiPrefix = prefix
+		}
+		return nil
+	}
+}
+
+// hasNatsAuth tells whether the credentials used to authenticate to NATS have already been set.
+func (o *Options) hasNatsAuth() bool {
+	return o.natsCredsFile != "" || o.natsNKeySeedFile != "" || o.natsToken != "" || o.natsUser != ""
+}
+
 // withNatsClient sets the Connector's NATS client implementation.
 // Used for testing.
 func withNatsClient(natsClient nats.Client) Option {
@@ -302,6 +843,64 @@ func WithServerAddr(serverAddr string) Option {
 	}
 }
 
+// WithFailFast makes the Connector stop all the watchers, and return an error, as soon as one of them fails, instead
+// of restarting it while the others keep running.
+func WithFailFast() Option {
+	return func(o *Options) error {
+		o.failFast = true
+		return nil
+	}
+}
+
+// WithLeaderElection makes each watcher run on a single Connector instance at a time, among those sharing the given
+// NATS KV bucket: the instance holding the lease of a watcher renews it periodically, while the other instances stand
+// by and take over once the lease is released or expires after the given TTL.
+// Default values are 'connector-leases' and 15s.
+func WithLeaderElection(bucket string, ttl time.Duration) Option {
+	return func(o *Options) error {
+		bucket = defaultIfEmpty(bucket, defaultLeaseBucket)
+		if !validCheckpointBucketPattern.MatchString(bucket) {
+			return ErrInvalidLeaseBucket
+		}
+		if ttl == 0 {
+			ttl = defaultLeaseTtl
+		}
+		if ttl < time.Second {
+			return ErrInvalidLeaseTtl
+		}
+		o.leaderElection = true
+		o.leaseBucket = bucket
+		o.leaseTtl = ttl
+		return nil
+	}
+}
+
+// WithInstanceId sets the id of the Connector instance, as shown in the leases of its watchers.
+// Default value is the hostname followed by a random suffix.
+func WithInstanceId(instanceId string) Option {
+	return func(o *Options) error {
+		if invalidCheckpointKeyChars.MatchString(instanceId) {
+			return ErrInvalidInstanceId
+		}
+		if instanceId != "" {
+			o.instanceId = instanceId
+		}
+		return nil
+	}
+}
+
+// WithDistribution divides the watchers between the Connector instances sharing the leader election bucket, so that
+// each instance only runs some of them. The instances announce themselves in the bucket, and the watchers are
+// reassigned as soon as an instance joins or leaves, or after the lease TTL if it crashes. A reassigned watcher
+// continues from its last checkpoint, which must therefore be stored in a shared checkpoint store.
+// Requires WithLeaderElection.
+func WithDistribution() Option {
+	return func(o *Options) error {
+		o.distribution = true
+		return nil
+	}
+}
+
 // WithCollection configures a collection to be watched by the Connector, with the given options.
 func WithCollection(dbName, collName string, opts ...CollectionOption) Option {
 	return func(o *Options) error {
@@ -312,6 +911,9 @@ func WithCollection(dbName, collName string, opts ...CollectionOption) Option {
 			return ErrCollNameMissing
 		}
 		coll := &collection{
+			scope:                        collectionScope,
+			fullDocument:                 defaultFullDocument,
+			fullDocumentBeforeChange:     defaultFullDocumentBeforeChange,
 			dbName:                       dbName,
 			collName:                     collName,
 			changeStreamPreAndPostImages: defaultChangeStreamPreAndPostImages,
@@ -319,47 +921,267 @@ func WithCollection(dbName, collName string, opts ...CollectionOption) Option {
 			tokensCollName:               collName,
 			tokensCollCapped:             defaultTokensCollCapped,
 			tokensCollSizeInBytes:        defaultTokensCollSizeInBytes,
+			checkpointStore:              defaultCheckpointStore,
+			restartDelay:                 defaultRestartDelay,
+			maxRestarts:                  defaultMaxRestarts,
+			stream:                       defaultStreamOptions(),
 			streamName:                   strings.ToUpper(collName),
 		}
-		for _, opt := range opts {
-			if err := opt(coll); err != nil {
-				return err
-			}
+		return o.addCollection(coll, opts...)
+	}
+}
+
+// WithDatabase configures a whole database to be watched by the Connector through a single change stream, with the
+// given options.
+// The change events of each collection are published to a stream named after the collection, in upper case, which
+// is created on NATS as soon as its first change event is received. If a stream name is configured, it is used as a
+// prefix for the names of those streams.
+func WithDatabase(dbName string, opts ...CollectionOption) Option {
+	return func(o *Options) error {
+		if dbName == "" {
+			return ErrDbNameMissing
 		}
-		if strings.EqualFold(coll.dbName, coll.tokensDbName) &&
-			strings.EqualFold(coll.collName, coll.tokensCollName) {
-			return ErrInvalidDbAndCollNames
+		coll := &collection{
+			scope:                        databaseScope,
+			fullDocument:                 defaultFullDocument,
+			fullDocumentBeforeChange:     defaultFullDocumentBeforeChange,
+			dbName:                       dbName,
+			changeStreamPreAndPostImages: defaultChangeStreamPreAndPostImages,
+			tokensDbName:                 defaultTokensDbName,
+			tokensCollName:               dbName,
+			tokensCollCapped:             defaultTokensCollCapped,
+			tokensCollSizeInBytes:        defaultTokensCollSizeInBytes,
+			checkpointStore:              defaultCheckpointStore,
+			restartDelay:                 defaultRestartDelay,
+			maxRestarts:                  defaultMaxRestarts,
+			stream:                       defaultStreamOptions(),
 		}
-		o.collections = append(o.collections, coll)
-		return nil
+		return o.addCollection(coll, opts...)
+	}
+}
+
+// WithDeployment configures the whole deployment to be watched by the Connector through a single change stream, with
+// the given options.
+// The change events of each collection are published to a stream named after the database and the collection, in
+// upper case and separated by an underscore, which is created on NATS as soon as its first change event is received.
+// If a stream name is configured, it is used as a prefix for the names of those streams.
+func WithDeployment(opts ...CollectionOption) Option {
+	return func(o *Options) error {
+		coll := &collection{
+			scope:                        deploymentScope,
+			fullDocument:                 defaultFullDocument,
+			fullDocumentBeforeChange:     defaultFullDocumentBeforeChange,
+			changeStreamPreAndPostImages: defaultChangeStreamPreAndPostImages,
+			tokensDbName:                 defaultTokensDbName,
+			tokensCollName:               defaultDeploymentTokensCollName,
+			tokensCollCapped:             defaultTokensCollCapped,
+			tokensCollSizeInBytes:        defaultTokensCollSizeInBytes,
+			checkpointStore:              defaultCheckpointStore,
+			restartDelay:                 defaultRestartDelay,
+			maxRestarts:                  defaultMaxRestarts,
+			stream:                       defaultStreamOptions(),
+		}
+		return o.addCollection(coll, opts...)
+	}
+}
+
+func (o *Options) addCollection(coll *collection, opts ...CollectionOption) error {
+	for _, opt := range opts {
+		if err := opt(coll); err != nil {
+			return err
+		}
+	}
+	if strings.EqualFold(coll.dbName, coll.tokensDbName) &&
+		strings.EqualFold(coll.collName, coll.tokensCollName) {
+		return ErrInvalidDbAndCollNames
+	}
+	if coll.scope == collectionScope && (len(coll.includedColls) > 0 || len(coll.excludedColls) > 0) {
+		return ErrInvalidCollsFilter
+	}
+	if coll.scope != collectionScope && coll.snapshot {
+		return ErrInvalidSnapshot
+	}
+	if coll.scope != collectionScope && coll.followRenames {
+		return ErrInvalidFollowRenames
+	}
+	if coll.scope != collectionScope && coll.checkpointStore == streamCheckpointStore {
+		return ErrInvalidStreamCheckpointStore
 	}
+	if coll.scope != collectionScope && coll.onHistoryLost == mongo.ResnapshotOnHistoryLost {
+		return ErrInvalidResnapshot
+	}
+	if coll.envelope == mongo.DebeziumEnvelope && coll.format == mongo.BsonFormat {
+		return ErrInvalidDebeziumFormat
+	}
+	if coll.stream.MaxAge > 0 && coll.stream.DuplicateWindow > coll.stream.MaxAge {
+		if coll.duplicateWindowSet {
+			return ErrInvalidStreamDuplicateWindow
+		}
+		// the default duplicate window cannot be longer than the max age either
+		coll.stream.DuplicateWindow = coll.stream.MaxAge
+	}
+	// the stream checkpoint store needs the last published message, which is removed once consumed otherwise
+	if coll.checkpointStore == streamCheckpointStore && coll.stream.Retention != nats.LimitsRetention {
+		return ErrInvalidStreamCheckpointRetention
+	}
+	// the last published message would also be removed once expired, for example while the connector is stopped
+	if coll.checkpointStore == streamCheckpointStore && coll.stream.MaxAge > 0 {
+		return ErrInvalidStreamCheckpointMaxAge
+	}
+	// the last published message may follow a change event that could not be published with a publish window
+	if coll.checkpointStore == streamCheckpointStore && coll.publishWindow > 1 {
+		return ErrInvalidStreamCheckpointWindow
+	}
+	positions := 0
+	for _, set := range []bool{coll.startPosition.AtOperationTime != nil, coll.startPosition.ResumeAfter != "",
+		coll.startPosition.StartAfter != ""} {
+		if set {
+			positions++
+		}
+	}
+	if positions > 1 {
+		return ErrInvalidStartPosition
+	}
+	if coll.snapshot && positions > 0 {
+		return ErrInvalidSnapshotStartPosition
+	}
+	if coll.tokensCollCapped && coll.tokensRetention.Mode != "" && coll.tokensRetention.Mode != mongo.RetainAllTokens {
+		return ErrInvalidTokensRetention
+	}
+	// the resume tokens must not expire before a newer one is stored
+	if coll.tokensRetention.Mode == mongo.RetainTokensTtl &&
+		coll.tokensRetention.Ttl <= coll.checkpointPolicy.IdleInterval() {
+		return ErrInvalidTokensRetentionInterval
+	}
+	// the pre and post images of a watched database or deployment are not managed by the connector
+	if coll.scope == collectionScope && !coll.changeStreamPreAndPostImages &&
+		(coll.fullDocument == "whenAvailable" || coll.fullDocument == "required" ||
+			coll.fullDocumentBeforeChange == "required") {
+		return ErrPreAndPostImagesRequired
+	}
+	o.collections = append(o.collections, coll)
+	return nil
 }
 
+// watchScope represents what is watched by a single change stream.
+type watchScope int
+
+const (
+	collectionScope watchScope = iota
+	databaseScope
+	deploymentScope
+)
+
 type collection struct {
+	scope                        watchScope
 	dbName                       string
 	collName                     string
+	includedColls                []string
+	excludedColls                []string
 	changeStreamPreAndPostImages bool
 	tokensDbName                 string
 	tokensCollName               string
 	tokensCollCapped             bool
 	tokensCollSizeInBytes        int64
 	streamName                   string
+	pipeline                     []bson.D
+	snapshot                     bool
+	startPosition                mongo.StartPosition
+	fullDocument                 string
+	fullDocumentBeforeChange     string
+	checkpointPolicy             mongo.CheckpointPolicy
+	tokensRetention              mongo.TokensRetention
+	onHistoryLost                string
+	checkpointStore              string
+	checkpointBucket             string
+	checkpointDir                string
+	showExpandedEvents           bool
+	followRenames                bool
+	redaction                    []mongo.RedactionRule
+	retryPolicy                  mongo.RetryPolicy
+	format                       string
+	envelope                     string
+	publishWindow                int
+	restartDelay                 time.Duration
+	maxRestarts                  int
+	stream                       nats.AddStreamOptions
+	duplicateWindowSet           bool
 }
 
-// CollectionOption is used to configure a MongoDB collection to be watched.
-type CollectionOption func(*collection) error
+// addRedactionRule adds the given rule, after validating its path. The rules are applied in the order they are added,
+// the first one matching a field is used.
+func (c *collection) addRedactionRule(rule mongo.RedactionRule) error {
+	if rule.Path == "" {
+		return ErrInvalidRedactionPath
+	}
+	for _, field := range strings.Split(rule.Path, ".") {
+		if _, err := path.Match(field, ""); field == "" || err != nil {
+			return ErrInvalidRedactionPath
+		}
+	}
+	c.redaction = append(c.redaction, rule)
+	return nil
+}
 
-// WithChangeStreamPreAndPostImages enables MongoDB's changeStreamPreAndPostImages configuration.
-func WithChangeStreamPreAndPostImages() CollectionOption {
-	return func(c *collection) error {
-		c.changeStreamPreAndPostImages = true
-		return nil
+// checkpointKey returns the key of the checkpoints of the collection, derived from its resume tokens namespace, so
+// that it is unique among the watched collections and safe to be used as a NATS KV key or a file name.
+// The stream checkpoint store derives the checkpoints from the stream of the collection instead.
+func (c *collection) checkpointKey() string {
+	if c.checkpointStore == streamCheckpointStore {
+		return c.streamName
 	}
+	return invalidCheckpointKeyChars.ReplaceAllString(c.tokensDbName+"."+c.tokensCollName, "_")
 }
 
-// WithTokensDbName sets the name of the MongoDB database that will store the resume tokens collection for the
-// collection to be watched.
-func WithTokensDbName(tokensDbName string) CollectionOption {
+var (
+	invalidCheckpointKeyChars    = regexp.MustCompile(`[^a-zA-Z0-9_=.-]`)
+	validCheckpointBucketPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+)
+
+// routeStreamName returns the name of the stream where the change events of the given namespace are published.
+func (c *collection) routeStreamName(dbName, collName string) string {
+	if c.scope == collectionScope {
+		return c.streamName
+	}
+	parts := make([]string, 0, 2)
+	if c.scope == deploymentScope && dbName != "" {
+		parts = append(parts, dbName)
+	}
+	if collName != "" {
+		parts = append(parts, collName)
+	}
+	if len(parts) == 0 {
+		// events that do not belong to a collection, such as dropDatabase, are routed to the watcher's stream
+		parts = append(parts, defaultIfEmpty(c.dbName, defaultDeploymentStreamName))
+	}
+	return c.streamName + invalidStreamNameChars.Replace(strings.ToUpper(strings.Join(parts, "_")))
+}
+
+// invalidStreamNameChars replaces the characters that are not allowed in NATS stream names.
+var invalidStreamNameChars = strings.NewReplacer(".", "_", "*", "_", ">", "_", "/", "_", "\\", "_", " ", "_",
+	"\t", "_", "\n", "_")
+
+func defaultIfEmpty(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// CollectionOption is used to configure a MongoDB collection to be watched.
+type CollectionOption func(*collection) error
+
+// WithChangeStreamPreAndPostImages enables MongoDB's changeStreamPreAndPostImages configuration.
+func WithChangeStreamPreAndPostImages() CollectionOption {
+	return func(c *collection) error {
+		c.changeStreamPreAndPostImages = true
+		return nil
+	}
+}
+
+// WithTokensDbName sets the name of the MongoDB database that will store the resume tokens collection for the
+// collection to be watched.
+func WithTokensDbName(tokensDbName string) CollectionOption {
 	return func(c *collection) error {
 		if tokensDbName != "" {
 			c.tokensDbName = tokensDbName
@@ -392,6 +1214,40 @@ func WithTokensCollCapped(collSizeInBytes int64) CollectionOption {
 	}
 }
 
+// WithTokensRetentionLatest keeps only the latest resume token in the uncapped MongoDB collection that stores the
+// resume tokens for the collection to be watched, by upserting a single document.
+func WithTokensRetentionLatest() CollectionOption {
+	return func(c *collection) error {
+		c.tokensRetention = mongo.TokensRetention{Mode: mongo.RetainLatestToken}
+		return nil
+	}
+}
+
+// WithTokensRetentionCount keeps only the given number of latest resume tokens in the uncapped MongoDB collection that
+// stores the resume tokens for the collection to be watched, by periodically deleting the older ones.
+func WithTokensRetentionCount(count int) CollectionOption {
+	return func(c *collection) error {
+		if count <= 0 {
+			return ErrInvalidTokensRetentionCount
+		}
+		c.tokensRetention = mongo.TokensRetention{Mode: mongo.RetainTokensCount, Count: count}
+		return nil
+	}
+}
+
+// WithTokensRetentionTtl keeps the resume tokens for the given time in the uncapped MongoDB collection that stores the
+// resume tokens for the collection to be watched, by means of a TTL index on their `createdAt` field. The latest resume
+// token never expires. The time must be longer than the checkpoint interval, which is a minute by default.
+func WithTokensRetentionTtl(ttl time.Duration) CollectionOption {
+	return func(c *collection) error {
+		if ttl < time.Second {
+			return ErrInvalidTokensRetentionTtl
+		}
+		c.tokensRetention = mongo.TokensRetention{Mode: mongo.RetainTokensTtl, Ttl: ttl}
+		return nil
+	}
+}
+
 // WithStreamName sets the NATS stream name, where the MongoDB change events will be published for the collection to be
 // watched.
 func WithStreamName(streamName string) CollectionOption {
@@ -402,3 +1258,652 @@ func WithStreamName(streamName string) CollectionOption {
 		return nil
 	}
 }
+
+// WithStreamDescription sets the description of the NATS stream of the collection to be watched.
+func WithStreamDescription(description string) CollectionOption {
+	return func(c *collection) error {
+		c.stream.Description = description
+		return nil
+	}
+}
+
+// WithStreamStorage sets where the NATS stream of the collection to be watched stores its messages: 'file' or
+// 'memory'. Default value is 'file'.
+func WithStreamStorage(storage string) CollectionOption {
+	return func(c *collection) error {
+		switch storage {
+		case "":
+		case nats.FileStorage, nats.MemoryStorage:
+			c.stream.Storage = storage
+		default:
+			return ErrInvalidStreamStorage
+		}
+		return nil
+	}
+}
+
+// WithStreamReplicas sets how many replicas of the NATS stream of the collection to be watched are kept in a clustered
+// JetStream, between 1 and 5. Default value is 1.
+func WithStreamReplicas(replicas int) CollectionOption {
+	return func(c *collection) error {
+		if replicas < 1 || replicas > 5 {
+			return ErrInvalidStreamReplicas
+		}
+		c.stream.Replicas = replicas
+		return nil
+	}
+}
+
+// WithStreamRetention sets when the messages of the NATS stream of the collection to be watched are removed:
+//   - 'limits', when the limits of the stream are reached
+//   - 'interest', once acknowledged by all the consumers of the stream
+//   - 'workQueue', once acknowledged by a consumer of the stream
+//
+// Default value is 'limits', the only one that can be used with the stream checkpoint store.
+func WithStreamRetention(retention string) CollectionOption {
+	return func(c *collection) error {
+		switch retention {
+		case "":
+		case nats.LimitsRetention, nats.InterestRetention, nats.WorkQueueRetention:
+			c.stream.Retention = retention
+		default:
+			return ErrInvalidStreamRetention
+		}
+		return nil
+	}
+}
+
+// WithStreamMaxAge sets how long the messages are kept in the NATS stream of the collection to be watched. By default,
+// they are kept forever.
+func WithStreamMaxAge(maxAge time.Duration) CollectionOption {
+	return func(c *collection) error {
+		if maxAge <= 0 {
+			return ErrInvalidStreamMaxAge
+		}
+		c.stream.MaxAge = maxAge
+		return nil
+	}
+}
+
+// WithStreamMaxBytes sets the maximum size of the NATS stream of the collection to be watched. By default, it is
+// unlimited.
+func WithStreamMaxBytes(maxBytes int64) CollectionOption {
+	return func(c *collection) error {
+		if maxBytes <= 0 {
+			return ErrInvalidStreamMaxBytes
+		}
+		c.stream.MaxBytes = maxBytes
+		return nil
+	}
+}
+
+// WithStreamMaxMsgs sets the maximum number of messages in the NATS stream of the collection to be watched. By
+// default, it is unlimited.
+func WithStreamMaxMsgs(maxMsgs int64) CollectionOption {
+	return func(c *collection) error {
+		if maxMsgs <= 0 {
+			return ErrInvalidStreamMaxMsgs
+		}
+		c.stream.MaxMsgs = maxMsgs
+		return nil
+	}
+}
+
+// WithStreamMaxMsgsPerSubject sets the maximum number of messages for each subject (i.e. operation type) of the NATS
+// stream of the collection to be watched. By default, it is unlimited.
+func WithStreamMaxMsgsPerSubject(maxMsgs int64) CollectionOption {
+	return func(c *collection) error {
+		if maxMsgs <= 0 {
+			return ErrInvalidStreamMaxMsgsPerSubject
+		}
+		c.stream.MaxMsgsPerSubject = maxMsgs
+		return nil
+	}
+}
+
+// WithStreamMaxMsgSize sets the maximum size of a message of the NATS stream of the collection to be watched. The
+// change events exceeding it cannot be published. By default, it is limited by the maximum payload of the server.
+func WithStreamMaxMsgSize(maxMsgSize int32) CollectionOption {
+	return func(c *collection) error {
+		if maxMsgSize <= 0 {
+			return ErrInvalidStreamMaxMsgSize
+		}
+		c.stream.MaxMsgSize = maxMsgSize
+		return nil
+	}
+}
+
+// WithStreamDiscard sets which messages are discarded when the limits of the NATS stream of the collection to be
+// watched are reached: 'old', the oldest messages are removed, or 'new', the new messages are refused, which makes the
+// watcher fail. Default value is 'old'.
+func WithStreamDiscard(discard string) CollectionOption {
+	return func(c *collection) error {
+		switch discard {
+		case "":
+		case nats.DiscardOld, nats.DiscardNew:
+			c.stream.Discard = discard
+		default:
+			return ErrInvalidStreamDiscard
+		}
+		return nil
+	}
+}
+
+// WithStreamDuplicateWindow sets how long the msg ids of the messages published on the NATS stream of the collection
+// to be watched are tracked, so that the change events published again after a failure are discarded by NATS.
+// It should be longer than the time needed to recover from a failure, and cannot be longer than the max age of the
+// stream. Default value is 2 minutes, or the max age of the stream if shorter.
+func WithStreamDuplicateWindow(window time.Duration) CollectionOption {
+	return func(c *collection) error {
+		if window <= 0 {
+			return ErrInvalidStreamDuplicateWindow
+		}
+		c.stream.DuplicateWindow = window
+		c.duplicateWindowSet = true
+		return nil
+	}
+}
+
+// WithStreamPlacement places the NATS stream of the collection to be watched in the given cluster, on the servers with
+// all the given tags.
+func WithStreamPlacement(cluster string, tags ...string) CollectionOption {
+	return func(c *collection) error {
+		c.stream.PlacementCluster = cluster
+		c.stream.PlacementTags = tags
+		return nil
+	}
+}
+
+// WithStreamDenyDelete forbids deleting single messages from the NATS stream of the collection to be watched.
+func WithStreamDenyDelete() CollectionOption {
+	return func(c *collection) error {
+		c.stream.DenyDelete = true
+		return nil
+	}
+}
+
+// WithStreamDenyPurge forbids purging the NATS stream of the collection to be watched.
+func WithStreamDenyPurge() CollectionOption {
+	return func(c *collection) error {
+		c.stream.DenyPurge = true
+		return nil
+	}
+}
+
+// WithStreamReconcile sets what the connector does when the NATS stream of the collection to be watched already
+// exists when it starts. It can be one of the following:
+//   - 'create-only', the existing stream is used as it is
+//   - 'update', the safe changes are applied to the existing stream, while the destructive ones, such as lowering its
+//     limits or changing its storage, are refused and make the watcher fail
+//   - 'verify', the differences between the existing stream and its configuration are only logged
+//
+// Default value is 'create-only'.
+func WithStreamReconcile(reconcile string) CollectionOption {
+	return func(c *collection) error {
+		switch reconcile {
+		case "":
+		case nats.CreateOnlyReconcile, nats.UpdateReconcile, nats.VerifyReconcile:
+			c.stream.Reconcile = reconcile
+		default:
+			return ErrInvalidStreamReconcile
+		}
+		return nil
+	}
+}
+
+// WithIncludedColls restricts the change events published for a watched database or deployment to the given
+// collection names.
+func WithIncludedColls(collNames ...string) CollectionOption {
+	return func(c *collection) error {
+		c.includedColls = append(c.includedColls, collNames...)
+		return nil
+	}
+}
+
+// WithExcludedColls discards the change events of the given collection names, for a watched database or deployment.
+func WithExcludedColls(collNames ...string) CollectionOption {
+	return func(c *collection) error {
+		c.excludedColls = append(c.excludedColls, collNames...)
+		return nil
+	}
+}
+
+// WithPipeline sets the aggregation pipeline used to filter and transform the change events of the collection to be
+// watched, before they are published. The pipeline must be an extended json array of stages, such as `$match`,
+// `$project` or `$addFields`.
+func WithPipeline(pipeline string) CollectionOption {
+	return func(c *collection) error {
+		if pipeline == "" {
+			return nil
+		}
+		stages, err := parsePipeline(pipeline)
+		if err != nil {
+			return err
+		}
+		c.pipeline = stages
+		return nil
+	}
+}
+
+func parsePipeline(pipeline string) ([]bson.D, error) {
+	// wrap the pipeline in a document, since extended json cannot be unmarshalled into a top-level array
+	wrapper := struct {
+		Stages []bson.D `bson:"stages"`
+	}{}
+	if err := bson.UnmarshalExtJSON([]byte(`{"stages":`+pipeline+`}`), false, &wrapper); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPipeline, err)
+	}
+	for _, stage := range wrapper.Stages {
+		if len(stage) != 1 {
+			return nil, fmt.Errorf("%w: each stage must contain exactly one field", ErrInvalidPipeline)
+		}
+		if !slices.Contains(supportedPipelineStages, stage[0].Key) {
+			return nil, fmt.Errorf("%w: unsupported stage %v", ErrInvalidPipeline, stage[0].Key)
+		}
+	}
+	return wrapper.Stages, nil
+}
+
+// WithSnapshot publishes all the documents of the collection to be watched, as `snapshot` change events, before
+// starting to watch it. The snapshot is only performed when no resume token has been stored yet, and it is continued
+// after a restart, starting from the last published document.
+func WithSnapshot() CollectionOption {
+	return func(c *collection) error {
+		c.snapshot = true
+		return nil
+	}
+}
+
+// WithStartAtOperationTime starts watching from the given operation time, when no resume token has been stored yet or
+// when the stored resume tokens are ignored. The operation time can be either an RFC3339 date, or an extended json
+// timestamp such as `{"$timestamp": {"t": 1683637178, "i": 1}}`, and it must still be within the oplog window.
+func WithStartAtOperationTime(operationTime string) CollectionOption {
+	return func(c *collection) error {
+		if operationTime == "" {
+			return nil
+		}
+		ts, err := parseOperationTime(operationTime)
+		if err != nil {
+			return err
+		}
+		c.startPosition.AtOperationTime = ts
+		return nil
+	}
+}
+
+func parseOperationTime(operationTime string) (*primitive.Timestamp, error) {
+	if t, err := time.Parse(time.RFC3339, operationTime); err == nil {
+		return &primitive.Timestamp{T: uint32(t.Unix())}, nil
+	}
+	// wrap the timestamp in a document, since extended json cannot be unmarshalled into a top-level value
+	wrapper := struct {
+		Ts primitive.Timestamp `bson:"ts"`
+	}{}
+	if err := bson.UnmarshalExtJSON([]byte(`{"ts":`+operationTime+`}`), false, &wrapper); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidOperationTime, err)
+	}
+	return &wrapper.Ts, nil
+}
+
+// WithResumeAfter resumes watching after the given resume token (the `_data` field of a change event id), when no
+// resume token has been stored yet or when the stored resume tokens are ignored.
+func WithResumeAfter(token string) CollectionOption {
+	return func(c *collection) error {
+		if token != "" {
+			c.startPosition.ResumeAfter = token
+		}
+		return nil
+	}
+}
+
+// WithStartAfter starts watching after the given resume token (the `_data` field of a change event id), when no
+// resume token has been stored yet or when the stored resume tokens are ignored. Unlike WithResumeAfter, the token
+// can belong to an invalidate event.
+func WithStartAfter(token string) CollectionOption {
+	return func(c *collection) error {
+		if token != "" {
+			c.startPosition.StartAfter = token
+		}
+		return nil
+	}
+}
+
+// WithIgnoreStoredToken ignores the resume tokens stored before the Connector started, so that the collection to be
+// watched is watched from the configured start position, or from now if none is configured. Once a new resume token
+// has been stored, the watcher resumes from it when restarted.
+func WithIgnoreStoredToken() CollectionOption {
+	return func(c *collection) error {
+		c.startPosition.IgnoreStoredToken = true
+		return nil
+	}
+}
+
+// WithFullDocument sets what the change events of the collection to be watched contain in their `fullDocument` field.
+// It can be one of the following:
+//   - 'default' or 'off', the full document is only included by insert and replace events
+//   - 'updateLookup', update events include the current version of the document, at the cost of an extra lookup
+//   - 'whenAvailable', update events include the post-image of the document, if available
+//   - 'required', update events include the post-image of the document, or fail if it is not available
+//
+// The last two modes require changeStreamPreAndPostImages to be enabled. Default value is 'updateLookup'.
+func WithFullDocument(fullDocument string) CollectionOption {
+	return func(c *collection) error {
+		switch fullDocument {
+		case "":
+		case "default", "off":
+			c.fullDocument = "default"
+		case "updateLookup", "whenAvailable", "required":
+			c.fullDocument = fullDocument
+		default:
+			return ErrInvalidFullDocument
+		}
+		return nil
+	}
+}
+
+// WithFullDocumentBeforeChange sets what the change events of the collection to be watched contain in their
+// `fullDocumentBeforeChange` field. It can be one of the following:
+//   - 'default' or 'off', the pre-image of the document is never included
+//   - 'whenAvailable', update, replace and delete events include the pre-image of the document, if available
+//   - 'required', update, replace and delete events include the pre-image of the document, or fail if it is not
+//     available
+//
+// The last mode requires changeStreamPreAndPostImages to be enabled. Default value is 'whenAvailable'.
+func WithFullDocumentBeforeChange(fullDocumentBeforeChange string) CollectionOption {
+	return func(c *collection) error {
+		switch fullDocumentBeforeChange {
+		case "":
+		case "default", "off":
+			c.fullDocumentBeforeChange = "off"
+		case "whenAvailable", "required":
+			c.fullDocumentBeforeChange = fullDocumentBeforeChange
+		default:
+			return ErrInvalidFullDocumentBeforeChange
+		}
+		return nil
+	}
+}
+
+// WithCheckpointEvents stores the resume token of the collection to be watched once the given number of change events
+// has been published, instead of after every change event. It can be combined with WithCheckpointInterval, in which
+// case the resume token is stored as soon as either condition is met.
+// A wider checkpoint window reduces the load on MongoDB, but it also increases the number of duplicate change events
+// published after a crash.
+func WithCheckpointEvents(events int) CollectionOption {
+	return func(c *collection) error {
+		if events <= 0 {
+			return ErrInvalidCheckpointEvents
+		}
+		c.checkpointPolicy.Events = events
+		return nil
+	}
+}
+
+// WithCheckpointInterval stores the resume token of the collection to be watched once the given interval has elapsed
+// since it was last stored, instead of after every change event. It can be combined with WithCheckpointEvents, in which
+// case the resume token is stored as soon as either condition is met.
+// The interval also sets how often the resume token of a quiet collection is moved forward, which is every minute
+// by default.
+func WithCheckpointInterval(interval time.Duration) CollectionOption {
+	return func(c *collection) error {
+		if interval <= 0 {
+			return ErrInvalidCheckpointInterval
+		}
+		c.checkpointPolicy.Interval = interval
+		return nil
+	}
+}
+
+// WithRetryInitialDelay sets how long to wait before reopening the change stream of the collection to be watched, after
+// it failed with a transient error, such as a primary election or NATS being unavailable. The delay doubles after each
+// consecutive failure, and it is randomized to avoid reconnecting many watchers at once. Default value is 500ms.
+func WithRetryInitialDelay(delay time.Duration) CollectionOption {
+	return func(c *collection) error {
+		if delay <= 0 {
+			return ErrInvalidRetryInitialDelay
+		}
+		c.retryPolicy.InitialDelay = delay
+		return nil
+	}
+}
+
+// WithRetryMaxDelay sets the maximum delay before reopening the change stream of the collection to be watched.
+// Default value is 30s.
+func WithRetryMaxDelay(delay time.Duration) CollectionOption {
+	return func(c *collection) error {
+		if delay <= 0 {
+			return ErrInvalidRetryMaxDelay
+		}
+		c.retryPolicy.MaxDelay = delay
+		return nil
+	}
+}
+
+// WithRetryMaxAttempts makes the watcher of the collection fail after the given number of consecutive failures,
+// instead of retrying forever. Permanent errors, such as a change event exceeding the maximum NATS payload, are never
+// retried.
+func WithRetryMaxAttempts(attempts int) CollectionOption {
+	return func(c *collection) error {
+		if attempts <= 0 {
+			return ErrInvalidRetryMaxAttempts
+		}
+		c.retryPolicy.MaxAttempts = attempts
+		return nil
+	}
+}
+
+// WithRestartDelay sets how long to wait before restarting the watcher of the collection to be watched, after it
+// failed, for example because its stream could not be created or its retry policy gave up. Default value is 5s.
+func WithRestartDelay(delay time.Duration) CollectionOption {
+	return func(c *collection) error {
+		if delay <= 0 {
+			return ErrInvalidRestartDelay
+		}
+		c.restartDelay = delay
+		return nil
+	}
+}
+
+// WithMaxRestarts sets how many times the watcher of the collection to be watched is restarted after failing, after
+// which it is reported as down by the health check, while the other watchers keep running. A value of 0 means that
+// the watcher is never restarted. By default, it is restarted indefinitely.
+func WithMaxRestarts(restarts int) CollectionOption {
+	return func(c *collection) error {
+		if restarts < 0 {
+			return ErrInvalidMaxRestarts
+		}
+		c.maxRestarts = restarts
+		return nil
+	}
+}
+
+// WithShowExpandedEvents makes the change stream of the collection to be watched also include the DDL events, such as
+// 'create', 'createIndexes', 'dropIndexes', 'modify' and 'shardCollection', which are published on their own subjects
+// (e.g. `STREAM.createIndexes`). Requires MongoDB 6.0 or later.
+func WithShowExpandedEvents() CollectionOption {
+	return func(c *collection) error {
+		c.showExpandedEvents = true
+		return nil
+	}
+}
+
+// WithFollowRenames makes the connector keep watching the collection under its new name when it is renamed, publishing
+// its change events on the same stream. Otherwise, the connector waits for a new collection with the watched name.
+// The rename is followed until the connector restarts, so the configured collection name should be updated.
+// It can only be used when watching a collection.
+func WithFollowRenames() CollectionOption {
+	return func(c *collection) error {
+		c.followRenames = true
+		return nil
+	}
+}
+
+// WithDroppedField removes the fields matching the given path from the documents contained by the change events of
+// the collection to be watched, before they are published or logged. The documents are `fullDocument`,
+// `fullDocumentBeforeChange` and `updateDescription.updatedFields`.
+// The path is made of dot separated field names, each of them can be a glob pattern (e.g. `contact.phone*`), and `**`
+// matches any number of fields (e.g. `**.ssn`).
+func WithDroppedField(path string) CollectionOption {
+	return func(c *collection) error {
+		return c.addRedactionRule(mongo.RedactionRule{Path: path, Action: mongo.RedactDrop})
+	}
+}
+
+// WithReplacedField replaces the value of the fields matching the given path with the given constant, see
+// WithDroppedField.
+func WithReplacedField(path, value string) CollectionOption {
+	return func(c *collection) error {
+		return c.addRedactionRule(mongo.RedactionRule{Path: path, Action: mongo.RedactReplace, Value: value})
+	}
+}
+
+// WithHashedField replaces the value of the fields matching the given path with the hex encoded SHA-256 hash of the
+// given salt followed by the value, see WithDroppedField.
+func WithHashedField(path, salt string) CollectionOption {
+	return func(c *collection) error {
+		return c.addRedactionRule(mongo.RedactionRule{Path: path, Action: mongo.RedactHash, Salt: salt})
+	}
+}
+
+// WithMaskedField masks all the characters of the value of the fields matching the given path with '*', but the given
+// number of last ones, see WithDroppedField. Values that are not longer than that are masked entirely.
+func WithMaskedField(path string, keepLast int) CollectionOption {
+	return func(c *collection) error {
+		if keepLast <= 0 {
+			return ErrInvalidRedactionKeepLast
+		}
+		return c.addRedactionRule(mongo.RedactionRule{Path: path, Action: mongo.RedactKeepLast, KeepLast: keepLast})
+	}
+}
+
+// WithNatsCheckpointStore saves the checkpoints of the collection to be watched in the given NATS KV bucket, instead of
+// the resume tokens collection, so that they are kept side by side with the published change events.
+// The bucket is created if it does not exist, default value is 'connector-checkpoints'.
+func WithNatsCheckpointStore(bucket string) CollectionOption {
+	return func(c *collection) error {
+		bucket = defaultIfEmpty(bucket, defaultCheckpointBucket)
+		if !validCheckpointBucketPattern.MatchString(bucket) {
+			return ErrInvalidCheckpointBucket
+		}
+		c.checkpointStore = natsCheckpointStore
+		c.checkpointBucket = bucket
+		return nil
+	}
+}
+
+// WithFileCheckpointStore saves the checkpoints of the collection to be watched in a file inside the given directory,
+// instead of the resume tokens collection. It is meant for single node setups.
+// The directory is created if it does not exist, default value is 'checkpoints'.
+func WithFileCheckpointStore(dir string) CollectionOption {
+	return func(c *collection) error {
+		c.checkpointStore = fileCheckpointStore
+		c.checkpointDir = defaultIfEmpty(dir, defaultCheckpointDir)
+		return nil
+	}
+}
+
+// WithStreamCheckpointStore derives the checkpoints of the collection to be watched from the msg id of the last message
+// published on its NATS stream, instead of saving them anywhere: the stream becomes the single source of truth for
+// what has been published, and no duplicate is published after a crash. It can only be used when watching a
+// collection, whose stream keeps its last message: with the limits retention, and without a max age.
+func WithStreamCheckpointStore() CollectionOption {
+	return func(c *collection) error {
+		c.checkpointStore = streamCheckpointStore
+		return nil
+	}
+}
+
+// WithOnHistoryLost sets what to do when the change stream of the collection to be watched cannot be resumed, because
+// the last stored resume token is no longer in the oplog. It can be one of the following:
+//   - 'fail', the connector stops
+//   - 'resumeFromNow', the change stream is reopened from the current time, the missed change events are skipped
+//   - 'resnapshot', the documents of the collection are published again, then the change stream is reopened from the
+//     time the new snapshot started
+//
+// In any case, a gap event is published on the `STREAM.gap` subject, so that consumers know they may have missed some
+// change events. The last mode can only be used when watching a collection. Default value is 'fail'.
+func WithOnHistoryLost(onHistoryLost string) CollectionOption {
+	return func(c *collection) error {
+		switch onHistoryLost {
+		case "":
+		case mongo.FailOnHistoryLost, mongo.ResumeFromNowOnHistoryLost, mongo.ResnapshotOnHistoryLost:
+			c.onHistoryLost = onHistoryLost
+		default:
+			return ErrInvalidOnHistoryLost
+		}
+		return nil
+	}
+}
+
+// WithFormat sets how the change events of the collection to be watched are serialized. It can be one of the
+// following:
+//   - 'relaxedExtJSON', relaxed Extended JSON
+//   - 'canonicalExtJSON', canonical Extended JSON, so that every BSON type round-trips exactly
+//   - 'bson', raw BSON documents
+//   - 'json', plain JSON, with the object ids as hex strings and the dates as RFC3339 strings
+//
+// The format is recorded in the `Content-Type` header of the published messages. Default value is 'relaxedExtJSON'.
+func WithFormat(format string) CollectionOption {
+	return func(c *collection) error {
+		switch format {
+		case "":
+		case mongo.RelaxedExtJSONFormat, mongo.CanonicalExtJSONFormat, mongo.BsonFormat, mongo.JsonFormat:
+			c.format = format
+		default:
+			return ErrInvalidFormat
+		}
+		return nil
+	}
+}
+
+// WithEnvelope sets how the change events of the collection to be watched are wrapped before being published. It can
+// be one of the following:
+//   - 'none', the change events are published as they are
+//   - 'cloudEventsBinary', the change events are published as CloudEvents 1.0 in binary mode, with the attributes set
+//     as `ce-*` headers
+//   - 'cloudEventsStructured', the change events are published as CloudEvents 1.0 in structured mode, as the data of a
+//     JSON CloudEvent
+//   - 'debezium', the change events are published in the envelope of the Debezium MongoDB connector, with the
+//     documents serialized as strings according to the format, which cannot be 'bson'
+//
+// Default value is 'none'.
+func WithEnvelope(envelope string) CollectionOption {
+	return func(c *collection) error {
+		switch envelope {
+		case "":
+		case mongo.NoEnvelope, mongo.CloudEventsBinaryEnvelope, mongo.CloudEventsStructuredEnvelope, mongo.DebeziumEnvelope:
+			c.envelope = envelope
+		default:
+			return ErrInvalidEnvelope
+		}
+		return nil
+	}
+}
+
+// WithPublishWindow sets how many change events of the collection to be watched can be published to NATS at once,
+// without waiting for the previous ones to be acknowledged. The resume token of a change event is stored only once
+// it has been acknowledged together with all the previous ones, and the pending change events are waited for when
+// the Connector stops. If a publication fails, the change events published after it may be stored out of order in
+// the stream before the connector resumes, which is why it cannot be used with the stream checkpoint store.
+// Default value is 1, which publishes the change events one at a time.
+func WithPublishWindow(size int) CollectionOption {
+	return func(c *collection) error {
+		if size <= 0 {
+			return ErrInvalidPublishWindow
+		}
+		c.publishWindow = size
+		return nil
+	}
+}
+
+// defaultStreamOptions returns the options of the NATS stream of a collection, unless configured otherwise.
+func defaultStreamOptions() nats.AddStreamOptions {
+	return nats.AddStreamOptions{
+		Storage:         defaultStreamStorage,
+		Replicas:        defaultStreamReplicas,
+		Retention:       defaultStreamRetention,
+		Discard:         defaultStreamDiscard,
+		DuplicateWindow: defaultStreamDuplicateWindow,
+		Reconcile:       defaultStreamReconcile,
+	}
+}
@@ -3,17 +3,23 @@ package connector
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	natsgo "github.com/nats-io/nats.go"
 	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"github.com/damianiandrea/mongodb-nats-connector/internal/mongo"
 	"github.com/damianiandrea/mongodb-nats-connector/internal/nats"
+	"github.com/damianiandrea/mongodb-nats-connector/internal/server"
 )
 
 func TestNew(t *testing.T) {
@@ -37,6 +43,9 @@ func TestNew(t *testing.T) {
 		require.NotNil(t, conn.options.ctx)
 		require.NotNil(t, conn.options.stop)
 		require.Empty(t, conn.options.serverAddr)
+		require.False(t, conn.options.failFast)
+		require.False(t, conn.options.leaderElection)
+		require.NotEmpty(t, conn.options.instanceId)
 		require.NotNil(t, conn.logger)
 		require.NotNil(t, conn.server)
 		require.Empty(t, conn.options.collections)
@@ -83,6 +92,10 @@ func TestNew(t *testing.T) {
 			withNatsClient(natsClient),
 			WithContext(context.TODO()),
 			WithServerAddr(serverAddr),
+			WithFailFast(),
+			WithLeaderElection("leases", time.Minute),
+			WithInstanceId("instance1"),
+			WithDistribution(),
 		)
 
 		require.NoError(t, err)
@@ -94,10 +107,143 @@ func TestNew(t *testing.T) {
 		require.NotNil(t, conn.options.ctx)
 		require.NotNil(t, conn.options.stop)
 		require.Equal(t, serverAddr, conn.options.serverAddr)
+		require.True(t, conn.options.failFast)
+		require.True(t, conn.options.leaderElection)
+		require.Equal(t, "leases", conn.options.leaseBucket)
+		require.Equal(t, time.Minute, conn.options.leaseTtl)
+		require.Equal(t, "instance1", conn.options.instanceId)
+		require.True(t, conn.options.distribution)
 		require.NotNil(t, conn.logger)
 		require.NotNil(t, conn.server)
 		require.Empty(t, conn.options.collections)
 	})
+	t.Run("should create connector with mongo connection options", func(t *testing.T) {
+		conn, err := New(
+			withMongoClient(&mockMongoClient{}), // avoid connecting to a real mongo instance
+			withNatsClient(&mockNatsClient{}),   // avoid connecting to a real nats instance
+			WithMongoAppName("connector"),
+			WithMongoTLS("client.pem", "client.key", "ca.pem"),
+			WithMongoAuth("MONGODB-X509", "$external", "", ""),
+			WithMongoPoolSize(5, 20),
+			WithMongoMaxConnIdleTime(time.Minute),
+			WithMongoReadConcern("majority"),
+			WithMongoCompressors("zstd", "snappy"),
+		)
+
+		require.NoError(t, err)
+		require.Equal(t, mongo.ConnectionOptions{
+			AppName:         "connector",
+			TLSCertFile:     "client.pem",
+			TLSKeyFile:      "client.key",
+			TLSCaFile:       "ca.pem",
+			AuthMechanism:   "MONGODB-X509",
+			AuthSource:      "$external",
+			MaxPoolSize:     20,
+			MinPoolSize:     5,
+			MaxConnIdleTime: time.Minute,
+			ReadConcern:     "majority",
+			Compressors:     []string{"zstd", "snappy"},
+		}, conn.options.mongoConnection)
+	})
+	t.Run("should return error cause mongo connection options are not valid", func(t *testing.T) {
+		tests := []struct {
+			opt     Option
+			wantErr error
+		}{
+			{opt: WithMongoTLS("", "client.key", ""), wantErr: ErrInvalidMongoTLS},
+			{opt: WithMongoAuth("PLAIN", "", "connector", "s3cr3t"), wantErr: ErrInvalidMongoAuthMechanism},
+			{opt: WithMongoPoolSize(20, 5), wantErr: ErrInvalidMongoPoolSize},
+			{opt: WithMongoMaxConnIdleTime(-time.Second), wantErr: ErrInvalidMongoMaxConnIdleTime},
+			{opt: WithMongoReadConcern("strong"), wantErr: ErrInvalidMongoReadConcern},
+			{opt: WithMongoCompressors("gzip"), wantErr: ErrInvalidMongoCompressors},
+		}
+		for _, tt := range tests {
+			conn, err := New(tt.opt)
+
+			require.Nil(t, conn)
+			require.EqualError(t, err, tt.wantErr.Error())
+		}
+	})
+	t.Run("should create connector with nats auth and tls options", func(t *testing.T) {
+		conn, err := New(
+			withMongoClient(&mockMongoClient{}), // avoid connecting to a real mongo instance
+			withNatsClient(&mockNatsClient{}),   // avoid connecting to a real nats instance
+			WithNatsUserPassword("connector", "s3cr3t"),
+			WithNatsTLS("client.crt", "client.key", "ca.crt"),
+			WithNatsJetStreamDomain("hub"),
+		)
+
+		require.NoError(t, err)
+		require.Equal(t, "connector", conn.options.natsUser)
+		require.Equal(t, "s3cr3t", conn.options.natsPassword)
+		require.Equal(t, "client.crt", conn.options.natsTLSCertFile)
+		require.Equal(t, "client.key", conn.options.natsTLSKeyFile)
+		require.Equal(t, "ca.crt", conn.options.natsTLSCaFile)
+		require.Equal(t, "hub", conn.options.natsJsDomain)
+	})
+	t.Run("should return error cause nats options are not valid", func(t *testing.T) {
+		tests := []struct {
+			opts    []Option
+			wantErr error
+		}{
+			{opts: []Option{WithNatsCredentials("user.creds"), WithNatsToken("s3cr3t")}, wantErr: ErrInvalidNatsAuth},
+			{opts: []Option{WithNatsNKeySeed("user.nk"), WithNatsUserPassword("connector", "")},
+				wantErr: ErrInvalidNatsAuth},
+			{opts: []Option{WithNatsTLS("client.crt", "", "")}, wantErr: ErrInvalidNatsTLS},
+			{opts: []Option{WithNatsJetStreamDomain("hub"), WithNatsJetStreamApiPrefix("$JS.hub.API")},
+				wantErr: ErrInvalidNatsJetStream},
+		}
+		for _, tt := range tests {
+			conn, err := New(tt.opts...)
+
+			require.Nil(t, conn)
+			require.EqualError(t, err, tt.wantErr.Error())
+		}
+	})
+	t.Run("should create connector with leader election defaults", func(t *testing.T) {
+		conn, err := New(
+			withMongoClient(&mockMongoClient{}), // avoid connecting to a real mongo instance
+			withNatsClient(&mockNatsClient{}),   // avoid connecting to a real nats instance
+			WithLeaderElection("", 0),
+		)
+
+		require.NoError(t, err)
+		require.True(t, conn.options.leaderElection)
+		require.Equal(t, "connector-leases", conn.options.leaseBucket)
+		require.Equal(t, 15*time.Second, conn.options.leaseTtl)
+	})
+	t.Run("should return error cause leader election is not valid", func(t *testing.T) {
+		conn, err := New(WithLeaderElection("connector.leases", 0))
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidLeaseBucket.Error())
+
+		conn, err = New(WithLeaderElection("", time.Millisecond))
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidLeaseTtl.Error())
+
+		conn, err = New(WithLeaderElection("", 0), WithInstanceId("instance:1"))
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidInstanceId.Error())
+	})
+	t.Run("should return error cause distribution requires leader election", func(t *testing.T) {
+		conn, err := New(WithDistribution())
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidDistribution.Error())
+	})
+	t.Run("should return error cause distributed watchers cannot use the file checkpoint store", func(t *testing.T) {
+		conn, err := New(
+			WithLeaderElection("", 0),
+			WithDistribution(),
+			WithCollection("test-db", "test-coll", WithFileCheckpointStore("")),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidDistributedFileStore.Error())
+	})
 	t.Run("should create connector with collection defaults", func(t *testing.T) {
 		var (
 			mongoClient = &mockMongoClient{}
@@ -122,103 +268,1222 @@ func TestNew(t *testing.T) {
 			tokensCollCapped:             false,
 			tokensCollSizeInBytes:        0,
 			streamName:                   strings.ToUpper(collName),
+			fullDocument:                 "updateLookup",
+			fullDocumentBeforeChange:     "whenAvailable",
+			checkpointStore:              "mongo",
+			restartDelay:                 5 * time.Second,
+			maxRestarts:                  -1,
+			stream:                       defaultStreamOptions(),
+		})
+	})
+	t.Run("should create connector with given collection options", func(t *testing.T) {
+		var (
+			mongoClient     = &mockMongoClient{}
+			natsClient      = &mockNatsClient{}
+			dbName          = "connector-db"
+			collName        = "coll1"
+			tokensDbName    = "tokens-db"
+			tokensCollName  = "coll1-tokens"
+			collSizeInBytes = int64(2048)
+			streamName      = "coll1-stream"
+		)
+
+		conn, err := New(
+			withMongoClient(mongoClient), // avoid connecting to a real mongo instance
+			withNatsClient(natsClient),   // avoid connecting to a real nats instance
+			WithCollection(dbName, collName,
+				WithChangeStreamPreAndPostImages(),
+				WithTokensDbName(tokensDbName),
+				WithTokensCollName(tokensCollName),
+				WithTokensCollCapped(collSizeInBytes),
+				WithStreamName(streamName),
+				WithSnapshot(),
+				WithFullDocument("required"),
+				WithFullDocumentBeforeChange("off"),
+				WithCheckpointEvents(100),
+				WithCheckpointInterval(5*time.Second),
+				WithOnHistoryLost("resnapshot"),
+				WithFormat("canonicalExtJSON"),
+				WithEnvelope("cloudEventsStructured"),
+				WithPublishWindow(64),
+				WithNatsCheckpointStore("coll1-checkpoints"),
+				WithShowExpandedEvents(),
+				WithFollowRenames(),
+				WithRetryInitialDelay(time.Second),
+				WithRetryMaxDelay(time.Minute),
+				WithRetryMaxAttempts(10),
+				WithRestartDelay(time.Second),
+				WithMaxRestarts(3),
+				WithStreamDescription("coll1 change events"),
+				WithStreamStorage("memory"),
+				WithStreamReplicas(3),
+				WithStreamRetention("limits"),
+				WithStreamMaxAge(24*time.Hour),
+				WithStreamMaxBytes(1<<30),
+				WithStreamMaxMsgs(1000000),
+				WithStreamMaxMsgsPerSubject(500000),
+				WithStreamMaxMsgSize(1<<20),
+				WithStreamDiscard("new"),
+				WithStreamDuplicateWindow(10*time.Minute),
+				WithStreamPlacement("east", "ssd"),
+				WithStreamDenyDelete(),
+				WithStreamDenyPurge(),
+				WithStreamReconcile("update"),
+			),
+		)
+
+		require.NoError(t, err)
+		require.Contains(t, conn.options.collections, &collection{
+			dbName:                       dbName,
+			collName:                     collName,
+			changeStreamPreAndPostImages: true,
+			tokensDbName:                 tokensDbName,
+			tokensCollName:               tokensCollName,
+			tokensCollCapped:             true,
+			tokensCollSizeInBytes:        collSizeInBytes,
+			streamName:                   streamName,
+			snapshot:                     true,
+			fullDocument:                 "required",
+			fullDocumentBeforeChange:     "off",
+			checkpointPolicy:             mongo.CheckpointPolicy{Events: 100, Interval: 5 * time.Second},
+			onHistoryLost:                "resnapshot",
+			format:                       "canonicalExtJSON",
+			envelope:                     "cloudEventsStructured",
+			publishWindow:                64,
+			checkpointStore:              "nats",
+			checkpointBucket:             "coll1-checkpoints",
+			showExpandedEvents:           true,
+			followRenames:                true,
+			retryPolicy:                  mongo.RetryPolicy{InitialDelay: time.Second, MaxDelay: time.Minute, MaxAttempts: 10},
+			restartDelay:                 time.Second,
+			maxRestarts:                  3,
+			stream: nats.AddStreamOptions{
+				Description:       "coll1 change events",
+				Storage:           "memory",
+				Replicas:          3,
+				Retention:         "limits",
+				MaxAge:            24 * time.Hour,
+				MaxBytes:          1 << 30,
+				MaxMsgs:           1000000,
+				MaxMsgsPerSubject: 500000,
+				MaxMsgSize:        1 << 20,
+				Discard:           "new",
+				DuplicateWindow:   10 * time.Minute,
+				PlacementCluster:  "east",
+				PlacementTags:     []string{"ssd"},
+				DenyDelete:        true,
+				DenyPurge:         true,
+				Reconcile:         "update",
+			},
+			duplicateWindowSet: true,
+		})
+	})
+	t.Run("should create connector with the default duplicate window shortened to the stream max age", func(t *testing.T) {
+		conn, err := New(
+			withMongoClient(&mockMongoClient{}), // avoid connecting to a real mongo instance
+			withNatsClient(&mockNatsClient{}),   // avoid connecting to a real nats instance
+			WithCollection("test-db", "test-coll", WithStreamMaxAge(time.Minute)),
+		)
+
+		require.NoError(t, err)
+		require.Equal(t, time.Minute, conn.options.collections[0].stream.DuplicateWindow)
+	})
+	t.Run("should create connector with given collection pipeline", func(t *testing.T) {
+		var (
+			mongoClient = &mockMongoClient{}
+			natsClient  = &mockNatsClient{}
+			dbName      = "connector-db"
+			collName    = "coll1"
+			pipeline    = `[{"$match": {"operationType": "insert"}}, {"$project": {"fullDocument.secret": 0}}]`
+		)
+
+		conn, err := New(
+			withMongoClient(mongoClient), // avoid connecting to a real mongo instance
+			withNatsClient(natsClient),   // avoid connecting to a real nats instance
+			WithCollection(dbName, collName, WithPipeline(pipeline)),
+		)
+
+		require.NoError(t, err)
+		require.Len(t, conn.options.collections, 1)
+		require.Equal(t, []bson.D{
+			{{Key: "$match", Value: bson.D{{Key: "operationType", Value: "insert"}}}},
+			{{Key: "$project", Value: bson.D{{Key: "fullDocument.secret", Value: int32(0)}}}},
+		}, conn.options.collections[0].pipeline)
+	})
+	t.Run("should return error cause pipeline is not valid extended json", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("test-db", "test-coll", WithPipeline(`[{"$match": `)),
+		)
+
+		require.Nil(t, conn)
+		require.ErrorIs(t, err, ErrInvalidPipeline)
+	})
+	t.Run("should return error cause pipeline contains an unsupported stage", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("test-db", "test-coll", WithPipeline(`[{"$group": {"_id": "$operationType"}}]`)),
+		)
+
+		require.Nil(t, conn)
+		require.ErrorIs(t, err, ErrInvalidPipeline)
+	})
+	t.Run("should return error cause pipeline stage contains more than one field", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("test-db", "test-coll",
+				WithPipeline(`[{"$match": {"operationType": "insert"}, "$project": {"ns": 0}}]`)),
+		)
+
+		require.Nil(t, conn)
+		require.ErrorIs(t, err, ErrInvalidPipeline)
+	})
+	t.Run("should create connector with database defaults", func(t *testing.T) {
+		var (
+			mongoClient = &mockMongoClient{}
+			natsClient  = &mockNatsClient{}
+			dbName      = "connector-db"
+		)
+
+		conn, err := New(
+			withMongoClient(mongoClient), // avoid connecting to a real mongo instance
+			withNatsClient(natsClient),   // avoid connecting to a real nats instance
+			WithDatabase(dbName),
+		)
+
+		require.NoError(t, err)
+		require.Contains(t, conn.options.collections, &collection{
+			scope:                    databaseScope,
+			dbName:                   dbName,
+			tokensDbName:             "resume-tokens",
+			tokensCollName:           dbName,
+			fullDocument:             "updateLookup",
+			fullDocumentBeforeChange: "whenAvailable",
+			checkpointStore:          "mongo",
+			restartDelay:             5 * time.Second,
+			maxRestarts:              -1,
+			stream:                   defaultStreamOptions(),
+		})
+	})
+	t.Run("should create connector with given deployment options", func(t *testing.T) {
+		var (
+			mongoClient = &mockMongoClient{}
+			natsClient  = &mockNatsClient{}
+		)
+
+		conn, err := New(
+			withMongoClient(mongoClient), // avoid connecting to a real mongo instance
+			withNatsClient(natsClient),   // avoid connecting to a real nats instance
+			WithDeployment(
+				WithIncludedColls("coll1", "coll2"),
+				WithExcludedColls("coll3"),
+				WithTokensCollName("cluster"),
+				WithStreamName("CDC_"),
+			),
+		)
+
+		require.NoError(t, err)
+		require.Contains(t, conn.options.collections, &collection{
+			scope:                    deploymentScope,
+			includedColls:            []string{"coll1", "coll2"},
+			excludedColls:            []string{"coll3"},
+			tokensDbName:             "resume-tokens",
+			tokensCollName:           "cluster",
+			streamName:               "CDC_",
+			fullDocument:             "updateLookup",
+			fullDocumentBeforeChange: "whenAvailable",
+			checkpointStore:          "mongo",
+			restartDelay:             5 * time.Second,
+			maxRestarts:              -1,
+			stream:                   defaultStreamOptions(),
 		})
 	})
-	t.Run("should create connector with given collection options", func(t *testing.T) {
+	t.Run("should return error cause collections filter is used when watching a collection", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("test-db", "test-coll", WithExcludedColls("other-coll")),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidCollsFilter.Error())
+	})
+	t.Run("should return error cause snapshot is used when watching a database", func(t *testing.T) {
+		conn, err := New(
+			WithDatabase("test-db", WithSnapshot()),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidSnapshot.Error())
+	})
+	t.Run("should return error cause snapshot is used with a start position", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("test-db", "coll1", WithSnapshot(), WithStartAtOperationTime("2023-05-09T12:00:00Z")),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidSnapshotStartPosition.Error())
+	})
+	t.Run("should create connector with given collection start position", func(t *testing.T) {
+		tests := []struct {
+			name string
+			opts []CollectionOption
+			want mongo.StartPosition
+		}{
+			{
+				name: "rfc3339 operation time",
+				opts: []CollectionOption{WithStartAtOperationTime("2023-05-09T12:59:38Z")},
+				want: mongo.StartPosition{AtOperationTime: &primitive.Timestamp{T: 1683637178}},
+			},
+			{
+				name: "extended json operation time",
+				opts: []CollectionOption{
+					WithStartAtOperationTime(`{"$timestamp": {"t": 1683637178, "i": 3}}`),
+					WithIgnoreStoredToken(),
+				},
+				want: mongo.StartPosition{AtOperationTime: &primitive.Timestamp{T: 1683637178, I: 3},
+					IgnoreStoredToken: true},
+			},
+			{
+				name: "resume after token",
+				opts: []CollectionOption{WithResumeAfter("8264")},
+				want: mongo.StartPosition{ResumeAfter: "8264"},
+			},
+			{
+				name: "start after token",
+				opts: []CollectionOption{WithStartAfter("8264")},
+				want: mongo.StartPosition{StartAfter: "8264"},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				conn, err := New(
+					withMongoClient(&mockMongoClient{}), // avoid connecting to a real mongo instance
+					withNatsClient(&mockNatsClient{}),   // avoid connecting to a real nats instance
+					WithCollection("connector-db", "coll1", tt.opts...),
+				)
+
+				require.NoError(t, err)
+				require.Equal(t, tt.want, conn.options.collections[0].startPosition)
+			})
+		}
+	})
+	t.Run("should return error cause operation time is not valid", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("test-db", "test-coll", WithStartAtOperationTime("yesterday")),
+		)
+
+		require.Nil(t, conn)
+		require.ErrorIs(t, err, ErrInvalidOperationTime)
+	})
+	t.Run("should return error cause more than one start position is set", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("test-db", "test-coll", WithStartAtOperationTime("2023-05-09T12:59:38Z"),
+				WithResumeAfter("8264")),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidStartPosition.Error())
+	})
+	t.Run("should return error cause full document mode is not valid", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("test-db", "test-coll", WithFullDocument("always")),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidFullDocument.Error())
+	})
+	t.Run("should return error cause full document before change mode is not valid", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("test-db", "test-coll", WithFullDocumentBeforeChange("updateLookup")),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidFullDocumentBeforeChange.Error())
+	})
+	t.Run("should return error cause full document modes require pre and post images", func(t *testing.T) {
+		for _, opt := range []CollectionOption{
+			WithFullDocument("whenAvailable"),
+			WithFullDocument("required"),
+			WithFullDocumentBeforeChange("required"),
+		} {
+			conn, err := New(
+				WithCollection("test-db", "test-coll", opt),
+			)
+
+			require.Nil(t, conn)
+			require.EqualError(t, err, ErrPreAndPostImagesRequired.Error())
+		}
+	})
+	t.Run("should return error cause checkpoint events are not greater than 0", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("test-db", "test-coll", WithCheckpointEvents(0)),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidCheckpointEvents.Error())
+	})
+	t.Run("should return error cause checkpoint interval is not greater than 0", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("test-db", "test-coll", WithCheckpointInterval(-time.Second)),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidCheckpointInterval.Error())
+	})
+	t.Run("should return error cause retry policy is not valid", func(t *testing.T) {
+		tests := []struct {
+			name string
+			opt  CollectionOption
+			want error
+		}{
+			{name: "initial delay", opt: WithRetryInitialDelay(0), want: ErrInvalidRetryInitialDelay},
+			{name: "max delay", opt: WithRetryMaxDelay(-time.Second), want: ErrInvalidRetryMaxDelay},
+			{name: "max attempts", opt: WithRetryMaxAttempts(0), want: ErrInvalidRetryMaxAttempts},
+			{name: "restart delay", opt: WithRestartDelay(0), want: ErrInvalidRestartDelay},
+			{name: "max restarts", opt: WithMaxRestarts(-1), want: ErrInvalidMaxRestarts},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				conn, err := New(
+					WithCollection("test-db", "test-coll", tt.opt),
+				)
+
+				require.Nil(t, conn)
+				require.EqualError(t, err, tt.want.Error())
+			})
+		}
+	})
+	t.Run("should create connector with given tokens retention", func(t *testing.T) {
+		tests := []struct {
+			name string
+			opt  CollectionOption
+			want mongo.TokensRetention
+		}{
+			{
+				name: "latest",
+				opt:  WithTokensRetentionLatest(),
+				want: mongo.TokensRetention{Mode: mongo.RetainLatestToken},
+			},
+			{
+				name: "count",
+				opt:  WithTokensRetentionCount(10),
+				want: mongo.TokensRetention{Mode: mongo.RetainTokensCount, Count: 10},
+			},
+			{
+				name: "ttl",
+				opt:  WithTokensRetentionTtl(time.Hour),
+				want: mongo.TokensRetention{Mode: mongo.RetainTokensTtl, Ttl: time.Hour},
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				conn, err := New(
+					withMongoClient(&mockMongoClient{}), // avoid connecting to a real mongo instance
+					withNatsClient(&mockNatsClient{}),   // avoid connecting to a real nats instance
+					WithCollection("test-db", "test-coll", tt.opt),
+				)
+
+				require.NoError(t, err)
+				require.Equal(t, tt.want, conn.options.collections[0].tokensRetention)
+			})
+		}
+	})
+	t.Run("should return error cause tokens retention count is not greater than 0", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("test-db", "test-coll", WithTokensRetentionCount(0)),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidTokensRetentionCount.Error())
+	})
+	t.Run("should return error cause tokens retention ttl is less than a second", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("test-db", "test-coll", WithTokensRetentionTtl(time.Millisecond)),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidTokensRetentionTtl.Error())
+	})
+	t.Run("should return error cause tokens retention ttl is not longer than the checkpoint interval", func(t *testing.T) {
+		tests := []struct {
+			name string
+			opts []CollectionOption
+		}{
+			{
+				name: "default interval",
+				opts: []CollectionOption{WithTokensRetentionTtl(30 * time.Second)},
+			},
+			{
+				name: "given interval",
+				opts: []CollectionOption{WithTokensRetentionTtl(time.Hour), WithCheckpointInterval(2 * time.Hour)},
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				conn, err := New(
+					WithCollection("test-db", "test-coll", tt.opts...),
+				)
+
+				require.Nil(t, conn)
+				require.EqualError(t, err, ErrInvalidTokensRetentionInterval.Error())
+			})
+		}
+	})
+	t.Run("should return error cause tokens retention is used with a capped collection", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("test-db", "test-coll", WithTokensCollCapped(4096), WithTokensRetentionLatest()),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidTokensRetention.Error())
+	})
+	t.Run("should create connector with file checkpoint store", func(t *testing.T) {
+		conn, err := New(
+			withMongoClient(&mockMongoClient{}), // avoid connecting to a real mongo instance
+			withNatsClient(&mockNatsClient{}),   // avoid connecting to a real nats instance
+			WithCollection("test-db", "test-coll", WithFileCheckpointStore("")),
+		)
+
+		require.NoError(t, err)
+		require.Equal(t, "file", conn.options.collections[0].checkpointStore)
+		require.Equal(t, "checkpoints", conn.options.collections[0].checkpointDir)
+	})
+	t.Run("should create connector with stream checkpoint store", func(t *testing.T) {
+		conn, err := New(
+			withMongoClient(&mockMongoClient{}), // avoid connecting to a real mongo instance
+			withNatsClient(&mockNatsClient{}),   // avoid connecting to a real nats instance
+			WithCollection("test-db", "test-coll", WithStreamCheckpointStore()),
+		)
+
+		require.NoError(t, err)
+		require.Equal(t, "stream", conn.options.collections[0].checkpointStore)
+		require.Equal(t, "TEST-COLL", conn.options.collections[0].checkpointKey())
+	})
+	t.Run("should return error cause stream checkpoint store is used when watching a database", func(t *testing.T) {
+		conn, err := New(
+			WithDatabase("test-db", WithStreamCheckpointStore()),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidStreamCheckpointStore.Error())
+	})
+	t.Run("should return error cause checkpoint bucket is not valid", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("test-db", "test-coll", WithNatsCheckpointStore("coll1.checkpoints")),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidCheckpointBucket.Error())
+	})
+	t.Run("should create connector with given redaction rules", func(t *testing.T) {
+		conn, err := New(
+			withMongoClient(&mockMongoClient{}), // avoid connecting to a real mongo instance
+			withNatsClient(&mockNatsClient{}),   // avoid connecting to a real nats instance
+			WithCollection("test-db", "test-coll",
+				WithDroppedField("nationalId"),
+				WithReplacedField("contact.*", "REDACTED"),
+				WithHashedField("**.email", "pepper"),
+				WithMaskedField("phone", 4),
+			),
+		)
+
+		require.NoError(t, err)
+		require.Equal(t, []mongo.RedactionRule{
+			{Path: "nationalId", Action: mongo.RedactDrop},
+			{Path: "contact.*", Action: mongo.RedactReplace, Value: "REDACTED"},
+			{Path: "**.email", Action: mongo.RedactHash, Salt: "pepper"},
+			{Path: "phone", Action: mongo.RedactKeepLast, KeepLast: 4},
+		}, conn.options.collections[0].redaction)
+	})
+	t.Run("should return error cause redaction path is not valid", func(t *testing.T) {
+		for _, path := range []string{"", "contact..email", "contact.[email"} {
+			conn, err := New(
+				WithCollection("test-db", "test-coll", WithDroppedField(path)),
+			)
+
+			require.Nil(t, conn)
+			require.EqualError(t, err, ErrInvalidRedactionPath.Error())
+		}
+	})
+	t.Run("should return error cause redaction keep last is not greater than 0", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("test-db", "test-coll", WithMaskedField("phone", 0)),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidRedactionKeepLast.Error())
+	})
+	t.Run("should return error cause follow renames is used when watching a database", func(t *testing.T) {
+		conn, err := New(
+			WithDatabase("test-db", WithFollowRenames()),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidFollowRenames.Error())
+	})
+	t.Run("should return error cause on history lost policy is not valid", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("test-db", "test-coll", WithOnHistoryLost("ignore")),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidOnHistoryLost.Error())
+	})
+	t.Run("should return error cause format is not valid", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("test-db", "test-coll", WithFormat("xml")),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidFormat.Error())
+	})
+	t.Run("should return error cause envelope is not valid", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("test-db", "test-coll", WithEnvelope("avro")),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidEnvelope.Error())
+	})
+	t.Run("should return error cause publish window is not valid", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("test-db", "test-coll", WithPublishWindow(0)),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidPublishWindow.Error())
+	})
+	t.Run("should return error cause publish window is used with the stream checkpoint store", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("test-db", "test-coll", WithStreamCheckpointStore(), WithPublishWindow(64)),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidStreamCheckpointWindow.Error())
+	})
+	t.Run("should return error cause bson format is used with debezium envelope", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("test-db", "test-coll", WithFormat("bson"), WithEnvelope("debezium")),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidDebeziumFormat.Error())
+	})
+	t.Run("should return error cause stream options are not valid", func(t *testing.T) {
+		tests := []struct {
+			opts    []CollectionOption
+			wantErr error
+		}{
+			{opts: []CollectionOption{WithStreamStorage("disk")}, wantErr: ErrInvalidStreamStorage},
+			{opts: []CollectionOption{WithStreamReplicas(6)}, wantErr: ErrInvalidStreamReplicas},
+			{opts: []CollectionOption{WithStreamRetention("forever")}, wantErr: ErrInvalidStreamRetention},
+			{opts: []CollectionOption{WithStreamDiscard("oldest")}, wantErr: ErrInvalidStreamDiscard},
+			{opts: []CollectionOption{WithStreamMaxAge(0)}, wantErr: ErrInvalidStreamMaxAge},
+			{opts: []CollectionOption{WithStreamMaxBytes(0)}, wantErr: ErrInvalidStreamMaxBytes},
+			{opts: []CollectionOption{WithStreamMaxMsgs(-1)}, wantErr: ErrInvalidStreamMaxMsgs},
+			{opts: []CollectionOption{WithStreamMaxMsgsPerSubject(0)}, wantErr: ErrInvalidStreamMaxMsgsPerSubject},
+			{opts: []CollectionOption{WithStreamMaxMsgSize(0)}, wantErr: ErrInvalidStreamMaxMsgSize},
+			{opts: []CollectionOption{WithStreamDuplicateWindow(0)}, wantErr: ErrInvalidStreamDuplicateWindow},
+			{opts: []CollectionOption{WithStreamReconcile("replace")}, wantErr: ErrInvalidStreamReconcile},
+			{
+				opts:    []CollectionOption{WithStreamMaxAge(time.Minute), WithStreamDuplicateWindow(time.Hour)},
+				wantErr: ErrInvalidStreamDuplicateWindow,
+			},
+			{
+				opts:    []CollectionOption{WithStreamCheckpointStore(), WithStreamRetention("workQueue")},
+				wantErr: ErrInvalidStreamCheckpointRetention,
+			},
+			{
+				opts:    []CollectionOption{WithStreamCheckpointStore(), WithStreamMaxAge(24 * time.Hour)},
+				wantErr: ErrInvalidStreamCheckpointMaxAge,
+			},
+		}
+
+		for _, tt := range tests {
+			conn, err := New(
+				WithCollection("test-db", "test-coll", tt.opts...),
+			)
+
+			require.Nil(t, conn)
+			require.EqualError(t, err, tt.wantErr.Error())
+		}
+	})
+	t.Run("should return error cause resnapshot is used when watching a database", func(t *testing.T) {
+		conn, err := New(
+			WithDatabase("test-db", WithOnHistoryLost("resnapshot")),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidResnapshot.Error())
+	})
+	t.Run("should return error cause dbName is missing when watching a database", func(t *testing.T) {
+		conn, err := New(
+			WithDatabase(""),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrDbNameMissing.Error())
+	})
+	t.Run("should return error cause dbName is missing", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("", "test-coll"),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrDbNameMissing.Error())
+	})
+	t.Run("should return error cause collName is missing", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("test-db", ""),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrCollNameMissing.Error())
+	})
+	t.Run("should return error cause collSizeInBytes is less than 0", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("test-db", "test-coll", WithTokensCollCapped(-1)),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidCollSizeInBytes.Error())
+	})
+	t.Run("should return error cause collSizeInBytes is 0", func(t *testing.T) {
+		conn, err := New(
+			WithCollection("test-db", "test-coll", WithTokensCollCapped(0)),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidCollSizeInBytes.Error())
+	})
+	t.Run("should return error cause tokens cannot be stored in the collection to be watched", func(t *testing.T) {
+		var (
+			dbName   = "test-db"
+			collName = "test-coll"
+		)
+
+		conn, err := New(
+			WithCollection(dbName, collName, WithTokensDbName(dbName), WithTokensCollName(collName)),
+		)
+
+		require.Nil(t, conn)
+		require.EqualError(t, err, ErrInvalidDbAndCollNames.Error())
+	})
+}
+
+func TestCollection_routeStreamName(t *testing.T) {
+	tests := []struct {
+		name     string
+		coll     *collection
+		dbName   string
+		collName string
+		want     string
+	}{
+		{
+			name:     "should route collection events to the configured stream",
+			coll:     &collection{scope: collectionScope, streamName: "COLL1"},
+			dbName:   "db",
+			collName: "coll1",
+			want:     "COLL1",
+		},
+		{
+			name:     "should route database events to a stream named after the collection",
+			coll:     &collection{scope: databaseScope, dbName: "db"},
+			dbName:   "db",
+			collName: "tenant.orders",
+			want:     "TENANT_ORDERS",
+		},
+		{
+			name:     "should route database events without a collection to a stream named after the database",
+			coll:     &collection{scope: databaseScope, dbName: "db"},
+			dbName:   "db",
+			collName: "",
+			want:     "DB",
+		},
+		{
+			name:     "should route deployment events to a prefixed stream named after the namespace",
+			coll:     &collection{scope: deploymentScope, streamName: "CDC_"},
+			dbName:   "db",
+			collName: "coll1",
+			want:     "CDC_DB_COLL1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.coll.routeStreamName(tt.dbName, tt.collName))
+		})
+	}
+}
+
+func TestConnector_Run(t *testing.T) {
+	t.Run("should run connector and ", func(t *testing.T) {
+		var (
+			mongoClient     = &mockMongoClient{}
+			natsClient      = &mockNatsClient{}
+			ctx, cancel     = context.WithCancel(context.Background())
+			dbName          = "connector-db"
+			collName        = "coll1"
+			tokensDbName    = "tokens-db"
+			tokensCollName  = "coll1-tokens"
+			collSizeInBytes = int64(2048)
+			streamName      = "coll1-stream"
+		)
+		defer cancel()
+
+		conn, _ := New(
+			withMongoClient(mongoClient), // avoid connecting to a real mongo instance
+			withNatsClient(natsClient),   // avoid connecting to a real nats instance
+			WithContext(ctx),
+			WithCollection(dbName, collName,
+				WithChangeStreamPreAndPostImages(),
+				WithTokensDbName(tokensDbName),
+				WithTokensCollName(tokensCollName),
+				WithTokensCollCapped(collSizeInBytes),
+				WithStreamName(streamName),
+				WithPipeline(`[{"$match": {"operationType": "insert"}}]`),
+				WithSnapshot(),
+				WithShowExpandedEvents(),
+				WithFollowRenames(),
+			),
+		)
+
+		errCh := make(chan error)
+		go func() {
+			errCh <- conn.Run()
+		}()
+
+		t.Run("create watchable collections", func(t *testing.T) {
+			require.Eventually(t, func() bool {
+				return slices.Contains(mongoClient.getCreateCollectionOpts(), mongo.CreateCollectionOptions{
+					DbName:                       dbName,
+					CollName:                     collName,
+					Capped:                       false,
+					SizeInBytes:                  0,
+					ChangeStreamPreAndPostImages: true,
+				})
+			}, 1*time.Second, 100*time.Millisecond)
+		})
+
+		t.Run("create resume tokens collections", func(t *testing.T) {
+			require.Eventually(t, func() bool {
+				return slices.Contains(mongoClient.getCreateCollectionOpts(), mongo.CreateCollectionOptions{
+					DbName:                       tokensDbName,
+					CollName:                     tokensCollName,
+					Capped:                       true,
+					SizeInBytes:                  collSizeInBytes,
+					ChangeStreamPreAndPostImages: false,
+				})
+			}, 1*time.Second, 100*time.Millisecond)
+		})
+
+		t.Run("add nats streams", func(t *testing.T) {
+			require.Eventually(t, func() bool {
+				return slices.ContainsFunc(natsClient.getAddStreamOpts(), func(opts nats.AddStreamOptions) bool {
+					return opts.StreamName == streamName && opts.DuplicateWindow == defaultStreamDuplicateWindow
+				})
+			}, 1*time.Second, 100*time.Millisecond)
+		})
+
+		t.Run("watch collections", func(t *testing.T) {
+			require.Eventually(t, func() bool {
+				return slices.ContainsFunc(mongoClient.getWatchCollectionOpts(), func(o mongo.WatchCollectionOptions) bool {
+					return o.WatchedDbName == dbName &&
+						o.WatchedCollName == collName &&
+						o.ResumeTokensDbName == tokensDbName &&
+						o.ResumeTokensCollName == tokensCollName &&
+						o.ResumeTokensCollCapped == true &&
+						o.CheckpointStore == nil &&
+						o.CheckpointKey == "tokens-db.coll1-tokens" &&
+						o.StreamName == streamName &&
+						len(o.Pipeline) == 1 &&
+						o.Snapshot == true &&
+						o.FullDocument == "updateLookup" &&
+						o.FullDocumentBeforeChange == "whenAvailable" &&
+						o.ShowExpandedEvents == true &&
+						o.FollowRenames == true &&
+						o.ChangeEventHandler != nil
+				})
+			}, 1*time.Second, 100*time.Millisecond)
+		})
+
+		t.Run("shut down and close clients when context is cancelled", func(t *testing.T) {
+			cancel() // stop the connector by canceling context
+			err := <-errCh
+			require.ErrorIs(t, err, http.ErrServerClosed)
+			require.True(t, mongoClient.closed)
+			require.True(t, natsClient.closed)
+		})
+	})
+	t.Run("should run connector and save checkpoints in nats kv bucket", func(t *testing.T) {
+		var (
+			mongoClient = &mockMongoClient{}
+			natsClient  = &mockNatsClient{}
+			ctx, cancel = context.WithCancel(context.Background())
+			dbName      = "connector-db"
+			collName    = "coll1"
+		)
+		defer cancel()
+
+		conn, _ := New(
+			withMongoClient(mongoClient), // avoid connecting to a real mongo instance
+			withNatsClient(natsClient),   // avoid connecting to a real nats instance
+			WithContext(ctx),
+			WithCollection(dbName, collName, WithNatsCheckpointStore("")),
+		)
+
+		errCh := make(chan error)
+		go func() {
+			errCh <- conn.Run()
+		}()
+
+		require.Eventually(t, func() bool {
+			return len(natsClient.getAddStreamOpts()) == 1
+		}, 1*time.Second, 100*time.Millisecond)
+
+		cancel() // stop the connector by canceling context
+		require.ErrorIs(t, <-errCh, http.ErrServerClosed)
+		require.Equal(t, []nats.KeyValueOptions{{Bucket: "connector-checkpoints"}}, natsClient.keyValueOpts)
+		// the resume tokens collection is not created
+		require.Equal(t, []mongo.CreateCollectionOptions{{DbName: dbName, CollName: collName}},
+			mongoClient.createCollectionOpts)
+		require.Len(t, mongoClient.watchCollectionOpts, 1)
+		require.NotNil(t, mongoClient.watchCollectionOpts[0].CheckpointStore)
+		require.Equal(t, "resume-tokens.coll1", mongoClient.watchCollectionOpts[0].CheckpointKey)
+	})
+	t.Run("should run connector and mark the publish errors that cannot be retried as permanent", func(t *testing.T) {
+		var (
+			mongoClient = &mockMongoClient{}
+			natsClient  = &mockNatsClient{}
+			ctx, cancel = context.WithCancel(context.Background())
+		)
+		defer cancel()
+
+		conn, _ := New(
+			withMongoClient(mongoClient), // avoid connecting to a real mongo instance
+			withNatsClient(natsClient),   // avoid connecting to a real nats instance
+			WithContext(ctx),
+			WithCollection("connector-db", "coll1", WithRetryMaxAttempts(3)),
+		)
+
+		errCh := make(chan error)
+		go func() {
+			errCh <- conn.Run()
+		}()
+
+		require.Eventually(t, func() bool {
+			return len(mongoClient.getWatchCollectionOpts()) == 1
+		}, 1*time.Second, 100*time.Millisecond)
+		cancel() // stop the connector by canceling context
+		require.ErrorIs(t, <-errCh, http.ErrServerClosed)
+
+		watchCollOpts := mongoClient.watchCollectionOpts[0]
+		require.Equal(t, mongo.RetryPolicy{MaxAttempts: 3}, watchCollOpts.RetryPolicy)
+		event := &mongo.ChangeEvent{StreamName: "COLL1", Subj: "COLL1.insert", MsgId: "123", Data: []byte("{}")}
+
+		natsClient.publishErr = fmt.Errorf("could not publish message: %w", natsgo.ErrMaxPayload)
+		require.ErrorIs(t, watchCollOpts.ChangeEventHandler(context.Background(), event), mongo.ErrPermanent)
+
+		natsClient.publishErr = fmt.Errorf("could not publish message: %w", natsgo.ErrTimeout)
+		err := watchCollOpts.ChangeEventHandler(context.Background(), event)
+		require.ErrorIs(t, err, natsgo.ErrTimeout)
+		require.NotErrorIs(t, err, mongo.ErrPermanent)
+	})
+	t.Run("should run connector and publish the change events asynchronously with a publish window", func(t *testing.T) {
+		var (
+			mongoClient = &mockMongoClient{}
+			natsClient  = &mockNatsClient{}
+			ctx, cancel = context.WithCancel(context.Background())
+		)
+		defer cancel()
+
+		conn, _ := New(
+			withMongoClient(mongoClient), // avoid connecting to a real mongo instance
+			withNatsClient(natsClient),   // avoid connecting to a real nats instance
+			WithContext(ctx),
+			WithCollection("connector-db", "coll1", WithPublishWindow(64)),
+			WithCollection("connector-db", "coll2"),
+		)
+
+		errCh := make(chan error)
+		go func() {
+			errCh <- conn.Run()
+		}()
+
+		require.Eventually(t, func() bool {
+			return len(mongoClient.getWatchCollectionOpts()) == 2
+		}, 1*time.Second, 100*time.Millisecond)
+		cancel() // stop the connector by canceling context
+		require.ErrorIs(t, <-errCh, http.ErrServerClosed)
+
+		watchCollOpts := mongoClient.getWatchCollectionOpts()
+		slices.SortFunc(watchCollOpts, func(a, b mongo.WatchCollectionOptions) int {
+			return strings.Compare(a.WatchedCollName, b.WatchedCollName)
+		})
+		require.Equal(t, 64, watchCollOpts[0].PublishWindow)
+		require.NotNil(t, watchCollOpts[0].AsyncChangeEventHandler)
+		require.Nil(t, watchCollOpts[1].AsyncChangeEventHandler)
+
+		event := &mongo.ChangeEvent{StreamName: "COLL1", Subj: "COLL1.insert", MsgId: "123", Data: []byte("{}"),
+			Headers: map[string]string{"Content-Type": "application/json"}}
+		acked := make(chan error, 1)
+		err := watchCollOpts[0].AsyncChangeEventHandler(context.Background(), event, func(err error) { acked <- err })
+		require.NoError(t, err)
+		require.NoError(t, <-acked)
+		require.Equal(t, []nats.PublishOptions{{
+			Subj:    "COLL1.insert",
+			MsgId:   "123",
+			Data:    []byte("{}"),
+			Headers: map[string]string{"Content-Type": "application/json", "Connector-Instance": conn.options.instanceId},
+		}}, natsClient.publishOpts)
+
+		natsClient.publishErr = fmt.Errorf("could not publish message: %w", natsgo.ErrMaxPayload)
+		err = watchCollOpts[0].AsyncChangeEventHandler(context.Background(), event, func(err error) { acked <- err })
+		require.NoError(t, err)
+		require.ErrorIs(t, <-acked, mongo.ErrPermanent)
+	})
+	t.Run("should run connector and keep the other watchers running when one fails", func(t *testing.T) {
+		var (
+			watchErr    = errors.New("watch error")
+			mongoClient = &mockMongoClient{watchCollectionErr: watchErr}
+			natsClient  = &mockNatsClient{}
+			ctx, cancel = context.WithCancel(context.Background())
+		)
+		defer cancel()
+
+		conn, _ := New(
+			withMongoClient(mongoClient), // avoid connecting to a real mongo instance
+			withNatsClient(natsClient),   // avoid connecting to a real nats instance
+			WithContext(ctx),
+			WithCollection("connector-db", "coll1", WithRestartDelay(10*time.Millisecond), WithMaxRestarts(2)),
+			WithDatabase("tenants"),
+		)
+
+		errCh := make(chan error)
+		go func() {
+			errCh <- conn.Run()
+		}()
+
+		require.Eventually(t, func() bool {
+			// the watched collection is created again at each restart, until the max restarts are reached
+			restarts := slices.DeleteFunc(mongoClient.getCreateCollectionOpts(), func(o mongo.CreateCollectionOptions) bool {
+				return o.DbName != "connector-db"
+			})
+			return len(restarts) == 3 && len(mongoClient.getWatchDatabaseOpts()) == 1
+		}, 1*time.Second, 10*time.Millisecond)
+		require.Len(t, conn.watchers, 2)
+		require.Equal(t, "watcher:connector-db.coll1", conn.watchers[0].Name())
+		require.ErrorIs(t, conn.watchers[0].Monitor(ctx), watchErr)
+		require.Equal(t, "watcher:tenants", conn.watchers[1].Name())
+		require.NoError(t, conn.watchers[1].Monitor(ctx))
+
+		cancel() // stop the connector by canceling context
+		require.ErrorIs(t, <-errCh, http.ErrServerClosed)
+	})
+	t.Run("should run connector and not restart a watcher that failed with a permanent error", func(t *testing.T) {
 		var (
-			mongoClient     = &mockMongoClient{}
-			natsClient      = &mockNatsClient{}
-			dbName          = "connector-db"
-			collName        = "coll1"
-			tokensDbName    = "tokens-db"
-			tokensCollName  = "coll1-tokens"
-			collSizeInBytes = int64(2048)
-			streamName      = "coll1-stream"
+			watchErr    = mongo.Permanent(errors.New("history lost"))
+			mongoClient = &mockMongoClient{watchCollectionErr: watchErr}
+			natsClient  = &mockNatsClient{}
+			ctx, cancel = context.WithCancel(context.Background())
 		)
+		defer cancel()
 
-		conn, err := New(
+		conn, _ := New(
 			withMongoClient(mongoClient), // avoid connecting to a real mongo instance
 			withNatsClient(natsClient),   // avoid connecting to a real nats instance
-			WithCollection(dbName, collName,
-				WithChangeStreamPreAndPostImages(),
-				WithTokensDbName(tokensDbName),
-				WithTokensCollName(tokensCollName),
-				WithTokensCollCapped(collSizeInBytes),
-				WithStreamName(streamName),
-			),
+			WithContext(ctx),
+			WithCollection("connector-db", "coll1", WithRestartDelay(10*time.Millisecond)),
 		)
 
-		require.NoError(t, err)
-		require.Contains(t, conn.options.collections, &collection{
-			dbName:                       dbName,
-			collName:                     collName,
-			changeStreamPreAndPostImages: true,
-			tokensDbName:                 tokensDbName,
-			tokensCollName:               tokensCollName,
-			tokensCollCapped:             true,
-			tokensCollSizeInBytes:        collSizeInBytes,
-			streamName:                   streamName,
+		errCh := make(chan error)
+		go func() {
+			errCh <- conn.Run()
+		}()
+
+		require.Eventually(t, func() bool {
+			return errors.Is(conn.watchers[0].Monitor(ctx), watchErr)
+		}, 1*time.Second, 10*time.Millisecond)
+		time.Sleep(100 * time.Millisecond)
+		// the watched collection is only created once, since the watcher is never restarted
+		watched := slices.DeleteFunc(mongoClient.getCreateCollectionOpts(), func(o mongo.CreateCollectionOptions) bool {
+			return o.DbName != "connector-db"
 		})
-	})
-	t.Run("should return error cause dbName is missing", func(t *testing.T) {
-		conn, err := New(
-			WithCollection("", "test-coll"),
-		)
+		require.Len(t, watched, 1)
+		require.ErrorIs(t, conn.watchers[0].Monitor(ctx), mongo.ErrPermanent)
 
-		require.Nil(t, conn)
-		require.EqualError(t, err, ErrDbNameMissing.Error())
+		cancel() // stop the connector by canceling context
+		require.ErrorIs(t, <-errCh, http.ErrServerClosed)
 	})
-	t.Run("should return error cause collName is missing", func(t *testing.T) {
-		conn, err := New(
-			WithCollection("test-db", ""),
+	t.Run("should run connector and only ignore the stored resume tokens until a new one is stored", func(t *testing.T) {
+		var (
+			mongoClient = &mockMongoClient{watchCollectionFunc: func(opts *mongo.WatchCollectionOptions) error {
+				opts.StoredTokenReplaced()
+				return errors.New("watch error")
+			}}
+			natsClient  = &mockNatsClient{}
+			ctx, cancel = context.WithCancel(context.Background())
 		)
+		defer cancel()
 
-		require.Nil(t, conn)
-		require.EqualError(t, err, ErrCollNameMissing.Error())
-	})
-	t.Run("should return error cause collSizeInBytes is less than 0", func(t *testing.T) {
-		conn, err := New(
-			WithCollection("test-db", "test-coll", WithTokensCollCapped(-1)),
+		conn, _ := New(
+			withMongoClient(mongoClient), // avoid connecting to a real mongo instance
+			withNatsClient(natsClient),   // avoid connecting to a real nats instance
+			WithContext(ctx),
+			WithCollection("connector-db", "coll1", WithIgnoreStoredToken(), WithRestartDelay(10*time.Millisecond),
+				WithMaxRestarts(1)),
 		)
 
-		require.Nil(t, conn)
-		require.EqualError(t, err, ErrInvalidCollSizeInBytes.Error())
+		errCh := make(chan error)
+		go func() {
+			errCh <- conn.Run()
+		}()
+
+		require.Eventually(t, func() bool {
+			return len(mongoClient.getWatchCollectionOpts()) == 2
+		}, 1*time.Second, 10*time.Millisecond)
+		watchCollOpts := mongoClient.getWatchCollectionOpts()
+		require.True(t, watchCollOpts[0].StartPosition.IgnoreStoredToken)
+		require.False(t, watchCollOpts[1].StartPosition.IgnoreStoredToken)
+
+		cancel() // stop the connector by canceling context
+		require.ErrorIs(t, <-errCh, http.ErrServerClosed)
 	})
-	t.Run("should return error cause collSizeInBytes is 0", func(t *testing.T) {
-		conn, err := New(
-			WithCollection("test-db", "test-coll", WithTokensCollCapped(0)),
+	t.Run("should run connector and only watch the collections whose lease is held", func(t *testing.T) {
+		var (
+			leases        = newMockKeyValue()
+			mongoClient1  = &mockMongoClient{watchUntilDone: true}
+			mongoClient2  = &mockMongoClient{watchUntilDone: true}
+			ctx1, cancel1 = context.WithCancel(context.Background())
+			ctx2, cancel2 = context.WithCancel(context.Background())
 		)
+		defer cancel1()
+		defer cancel2()
 
-		require.Nil(t, conn)
-		require.EqualError(t, err, ErrInvalidCollSizeInBytes.Error())
+		newConnector := func(ctx context.Context, instanceId string, mongoClient *mockMongoClient) *Connector {
+			conn, _ := New(
+				withMongoClient(mongoClient),                      // avoid connecting to a real mongo instance
+				withNatsClient(&mockNatsClient{keyValue: leases}), // avoid connecting to a real nats instance
+				WithContext(ctx),
+				WithServerAddr("127.0.0.1:0"),
+				WithLeaderElection("", time.Second),
+				WithInstanceId(instanceId),
+				WithCollection("connector-db", "coll1"),
+			)
+			return conn
+		}
+		conn1 := newConnector(ctx1, "instance1", mongoClient1)
+		conn2 := newConnector(ctx2, "instance2", mongoClient2)
+
+		errCh1 := make(chan error)
+		go func() {
+			errCh1 <- conn1.Run()
+		}()
+		require.Eventually(t, func() bool {
+			return len(mongoClient1.getWatchCollectionOpts()) == 1
+		}, 1*time.Second, 10*time.Millisecond)
+		value, _ := leases.Get(context.Background(), "watchers.connector-db.coll1")
+		require.Equal(t, []byte("instance1"), value)
+		require.NoError(t, conn1.watchers[0].Monitor(ctx1))
+
+		errCh2 := make(chan error)
+		go func() {
+			errCh2 <- conn2.Run()
+		}()
+		require.Eventually(t, func() bool {
+			return errors.Is(conn2.watchers[0].Monitor(ctx2), server.ErrStandby)
+		}, 1*time.Second, 10*time.Millisecond)
+		require.Empty(t, mongoClient2.getWatchCollectionOpts())
+
+		// the lease is released on shutdown, so that the standby instance takes over
+		cancel1()
+		require.ErrorIs(t, <-errCh1, http.ErrServerClosed)
+		require.Eventually(t, func() bool {
+			return len(mongoClient2.getWatchCollectionOpts()) == 1
+		}, 2*time.Second, 10*time.Millisecond)
+		value, _ = leases.Get(context.Background(), "watchers.connector-db.coll1")
+		require.Equal(t, []byte("instance2"), value)
+		require.NoError(t, conn2.watchers[0].Monitor(ctx2))
+
+		cancel2()
+		require.ErrorIs(t, <-errCh2, http.ErrServerClosed)
 	})
-	t.Run("should return error cause tokens cannot be stored in the collection to be watched", func(t *testing.T) {
+	t.Run("should run connector and divide the collections between the instances", func(t *testing.T) {
 		var (
-			dbName   = "test-db"
-			collName = "test-coll"
+			leases        = newMockKeyValue()
+			mongoClient1  = &mockMongoClient{watchUntilDone: true}
+			mongoClient2  = &mockMongoClient{watchUntilDone: true}
+			ctx1, cancel1 = context.WithCancel(context.Background())
+			ctx2, cancel2 = context.WithCancel(context.Background())
 		)
+		defer cancel1()
+		defer cancel2()
 
-		conn, err := New(
-			WithCollection(dbName, collName, WithTokensDbName(dbName), WithTokensCollName(collName)),
-		)
+		newConnector := func(ctx context.Context, instanceId string, mongoClient *mockMongoClient) *Connector {
+			conn, _ := New(
+				withMongoClient(mongoClient),                      // avoid connecting to a real mongo instance
+				withNatsClient(&mockNatsClient{keyValue: leases}), // avoid connecting to a real nats instance
+				WithContext(ctx),
+				WithServerAddr("127.0.0.1:0"),
+				WithLeaderElection("", time.Second),
+				WithInstanceId(instanceId),
+				WithDistribution(),
+				WithCollection("connector-db", "coll1"),
+				WithCollection("connector-db", "coll2"),
+				WithCollection("connector-db", "coll3"),
+				WithCollection("connector-db", "coll4"),
+			)
+			return conn
+		}
+		watchedColls := func(mongoClient *mockMongoClient) []string {
+			var collNames []string
+			for _, o := range mongoClient.getWatchCollectionOpts() {
+				collNames = append(collNames, o.WatchedCollName)
+			}
+			slices.Sort(collNames)
+			return collNames
+		}
+		conn1 := newConnector(ctx1, "instance1", mongoClient1)
+		conn2 := newConnector(ctx2, "instance2", mongoClient2)
 
-		require.Nil(t, conn)
-		require.EqualError(t, err, ErrInvalidDbAndCollNames.Error())
-	})
-}
+		errCh1 := make(chan error)
+		go func() {
+			errCh1 <- conn1.Run()
+		}()
+		require.Eventually(t, func() bool {
+			return len(watchedColls(mongoClient1)) == 4
+		}, 1*time.Second, 10*time.Millisecond)
 
-func TestConnector_Run(t *testing.T) {
-	t.Run("should run connector and ", func(t *testing.T) {
+		errCh2 := make(chan error)
+		go func() {
+			errCh2 <- conn2.Run()
+		}()
+		// the collections assigned to the new instance are released by the first one, and watched by the new one
+		require.Eventually(t, func() bool {
+			return slices.Equal([]string{"coll1"}, watchedColls(mongoClient2))
+		}, 3*time.Second, 10*time.Millisecond)
+		for _, w := range conn1.watchers {
+			if w.coll.collName == "coll1" {
+				require.ErrorIs(t, w.Monitor(ctx1), server.ErrStandby)
+			} else {
+				require.NoError(t, w.Monitor(ctx1))
+			}
+		}
+
+		// the collections of the instance that leaves are reassigned to the remaining one
+		cancel2()
+		require.ErrorIs(t, <-errCh2, http.ErrServerClosed)
+		require.Eventually(t, func() bool {
+			return slices.Equal([]string{"coll1", "coll1", "coll2", "coll3", "coll4"}, watchedColls(mongoClient1))
+		}, 3*time.Second, 10*time.Millisecond)
+
+		cancel1()
+		require.ErrorIs(t, <-errCh1, http.ErrServerClosed)
+	})
+	t.Run("should run connector and watch database", func(t *testing.T) {
 		var (
-			mongoClient     = &mockMongoClient{}
-			natsClient      = &mockNatsClient{}
-			ctx, cancel     = context.WithCancel(context.Background())
-			dbName          = "connector-db"
-			collName        = "coll1"
-			tokensDbName    = "tokens-db"
-			tokensCollName  = "coll1-tokens"
-			collSizeInBytes = int64(2048)
-			streamName      = "coll1-stream"
+			mongoClient  = &mockMongoClient{}
+			natsClient   = &mockNatsClient{}
+			ctx, cancel  = context.WithCancel(context.Background())
+			dbName       = "connector-db"
+			tokensDbName = "tokens-db"
 		)
 		defer cancel()
 
@@ -226,12 +1491,11 @@ func TestConnector_Run(t *testing.T) {
 			withMongoClient(mongoClient), // avoid connecting to a real mongo instance
 			withNatsClient(natsClient),   // avoid connecting to a real nats instance
 			WithContext(ctx),
-			WithCollection(dbName, collName,
-				WithChangeStreamPreAndPostImages(),
+			WithInstanceId("connector-0"),
+			WithDatabase(dbName,
 				WithTokensDbName(tokensDbName),
-				WithTokensCollName(tokensCollName),
-				WithTokensCollCapped(collSizeInBytes),
-				WithStreamName(streamName),
+				WithTokensRetentionTtl(time.Hour),
+				WithExcludedColls("coll2"),
 			),
 		)
 
@@ -240,59 +1504,48 @@ func TestConnector_Run(t *testing.T) {
 			errCh <- conn.Run()
 		}()
 
-		t.Run("create watchable collections", func(t *testing.T) {
-			require.Eventually(t, func() bool {
-				return slices.Contains(mongoClient.createCollectionOpts, mongo.CreateCollectionOptions{
-					DbName:                       dbName,
-					CollName:                     collName,
-					Capped:                       false,
-					SizeInBytes:                  0,
-					ChangeStreamPreAndPostImages: true,
-				})
-			}, 1*time.Second, 100*time.Millisecond)
-		})
-
-		t.Run("create resume tokens collections", func(t *testing.T) {
-			require.Eventually(t, func() bool {
-				return slices.Contains(mongoClient.createCollectionOpts, mongo.CreateCollectionOptions{
-					DbName:                       tokensDbName,
-					CollName:                     tokensCollName,
-					Capped:                       true,
-					SizeInBytes:                  collSizeInBytes,
-					ChangeStreamPreAndPostImages: false,
-				})
-			}, 1*time.Second, 100*time.Millisecond)
-		})
+		require.Eventually(t, func() bool {
+			return len(mongoClient.getWatchDatabaseOpts()) == 1
+		}, 1*time.Second, 100*time.Millisecond)
 
-		t.Run("add nats streams", func(t *testing.T) {
-			require.Eventually(t, func() bool {
-				return slices.Contains(natsClient.addStreamOpts, nats.AddStreamOptions{
-					StreamName: streamName,
-				})
-			}, 1*time.Second, 100*time.Millisecond)
-		})
+		watchDbOpts := mongoClient.getWatchDatabaseOpts()[0]
+		require.Equal(t, dbName, watchDbOpts.WatchedDbName)
+		require.Equal(t, []string{"coll2"}, watchDbOpts.ExcludedCollNames)
+		require.Contains(t, watchDbOpts.ExcludedNamespaces, mongo.Namespace{DbName: tokensDbName, CollName: dbName})
+		require.Equal(t, tokensDbName, watchDbOpts.ResumeTokensDbName)
+		require.Equal(t, dbName, watchDbOpts.ResumeTokensCollName)
+		require.Equal(t, "COLL1", watchDbOpts.StreamNameFunc(dbName, "coll1"))
+		require.Equal(t, mongo.TokensRetention{Mode: mongo.RetainTokensTtl, Ttl: time.Hour}, watchDbOpts.ResumeTokensRetention)
+		require.Equal(t, []mongo.CreateCollectionOptions{{DbName: tokensDbName, CollName: dbName, ExpireAfter: time.Hour}},
+			mongoClient.createCollectionOpts)
+		require.Empty(t, natsClient.addStreamOpts)
 
-		t.Run("watch collections", func(t *testing.T) {
-			require.Eventually(t, func() bool {
-				return slices.ContainsFunc(mongoClient.watchCollectionOpts, func(o mongo.WatchCollectionOptions) bool {
-					return o.WatchedDbName == dbName &&
-						o.WatchedCollName == collName &&
-						o.ResumeTokensDbName == tokensDbName &&
-						o.ResumeTokensCollName == tokensCollName &&
-						o.ResumeTokensCollCapped == true &&
-						o.StreamName == streamName &&
-						o.ChangeEventHandler != nil
-				})
-			}, 1*time.Second, 100*time.Millisecond)
-		})
+		// streams are created when the first change event of a collection is received
+		for i := 0; i < 2; i++ {
+			err := watchDbOpts.ChangeEventHandler(ctx, &mongo.ChangeEvent{
+				StreamName: "COLL1",
+				Subj:       "COLL1.insert",
+				MsgId:      "123",
+				Data:       []byte("test"),
+				Headers:    map[string]string{"Mongo-Coll": "coll1"},
+			})
+			require.NoError(t, err)
+		}
+		require.Equal(t, []nats.AddStreamOptions{{
+			StreamName:      "COLL1",
+			Storage:         nats.FileStorage,
+			Replicas:        1,
+			Retention:       nats.LimitsRetention,
+			Discard:         nats.DiscardOld,
+			DuplicateWindow: 2 * time.Minute,
+			Reconcile:       "create-only",
+		}}, natsClient.addStreamOpts)
+		require.Len(t, natsClient.publishOpts, 2)
+		require.Equal(t, map[string]string{"Mongo-Coll": "coll1", "Connector-Instance": "connector-0"},
+			natsClient.publishOpts[0].Headers)
 
-		t.Run("shut down and close clients when context is cancelled", func(t *testing.T) {
-			cancel() // stop the connector by canceling context
-			err := <-errCh
-			require.ErrorIs(t, err, http.ErrServerClosed)
-			require.True(t, mongoClient.closed)
-			require.True(t, natsClient.closed)
-		})
+		cancel() // stop the connector by canceling context
+		require.ErrorIs(t, <-errCh, http.ErrServerClosed)
 	})
 	t.Run("should stop connector and return error if collection creation fails", func(t *testing.T) {
 		var (
@@ -315,6 +1568,7 @@ func TestConnector_Run(t *testing.T) {
 			withMongoClient(mongoClient), // avoid connecting to a real mongo instance
 			withNatsClient(natsClient),   // avoid connecting to a real nats instance
 			WithContext(ctx),
+			WithFailFast(),
 			WithCollection(dbName, collName,
 				WithChangeStreamPreAndPostImages(),
 				WithTokensDbName(tokensDbName),
@@ -348,6 +1602,7 @@ func TestConnector_Run(t *testing.T) {
 			withMongoClient(mongoClient), // avoid connecting to a real mongo instance
 			withNatsClient(natsClient),   // avoid connecting to a real nats instance
 			WithContext(ctx),
+			WithFailFast(),
 			WithCollection(dbName, collName,
 				WithChangeStreamPreAndPostImages(),
 				WithTokensDbName(tokensDbName),
@@ -370,6 +1625,11 @@ type mockMongoClient struct {
 	createCollectionErr  error
 	watchCollectionOpts  []mongo.WatchCollectionOptions
 	watchCollectionErr   error
+	watchCollectionFunc  func(opts *mongo.WatchCollectionOptions) error
+	watchDatabaseOpts    []mongo.WatchDatabaseOptions
+	watchDatabaseErr     error
+	watchUntilDone       bool
+	mu                   sync.Mutex
 }
 
 func (m *mockMongoClient) Close() error {
@@ -389,18 +1649,57 @@ func (m *mockMongoClient) CreateCollection(_ context.Context, opts *mongo.Create
 	if m.createCollectionErr != nil {
 		return m.createCollectionErr
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.createCollectionOpts = append(m.createCollectionOpts, *opts)
 	return nil
 }
 
-func (m *mockMongoClient) WatchCollection(_ context.Context, opts *mongo.WatchCollectionOptions) error {
+func (m *mockMongoClient) getCreateCollectionOpts() []mongo.CreateCollectionOptions {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.createCollectionOpts)
+}
+
+func (m *mockMongoClient) WatchCollection(ctx context.Context, opts *mongo.WatchCollectionOptions) error {
 	if m.watchCollectionErr != nil {
 		return m.watchCollectionErr
 	}
+	m.mu.Lock()
 	m.watchCollectionOpts = append(m.watchCollectionOpts, *opts)
+	m.mu.Unlock()
+	if m.watchCollectionFunc != nil {
+		return m.watchCollectionFunc(opts)
+	}
+	if m.watchUntilDone {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (m *mockMongoClient) getWatchCollectionOpts() []mongo.WatchCollectionOptions {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.watchCollectionOpts)
+}
+
+func (m *mockMongoClient) WatchDatabase(_ context.Context, opts *mongo.WatchDatabaseOptions) error {
+	if m.watchDatabaseErr != nil {
+		return m.watchDatabaseErr
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.watchDatabaseOpts = append(m.watchDatabaseOpts, *opts)
 	return nil
 }
 
+func (m *mockMongoClient) getWatchDatabaseOpts() []mongo.WatchDatabaseOptions {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.watchDatabaseOpts)
+}
+
 type mockNatsClient struct {
 	closed        bool
 	name          string
@@ -409,6 +1708,10 @@ type mockNatsClient struct {
 	addStreamErr  error
 	publishOpts   []nats.PublishOptions
 	publishErr    error
+	keyValueOpts  []nats.KeyValueOptions
+	keyValueErr   error
+	keyValue      *mockKeyValue
+	mu            sync.Mutex
 }
 
 func (m *mockNatsClient) Close() error {
@@ -428,10 +1731,18 @@ func (m *mockNatsClient) AddStream(_ context.Context, opts *nats.AddStreamOption
 	if m.addStreamErr != nil {
 		return m.addStreamErr
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.addStreamOpts = append(m.addStreamOpts, *opts)
 	return nil
 }
 
+func (m *mockNatsClient) getAddStreamOpts() []nats.AddStreamOptions {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.addStreamOpts)
+}
+
 func (m *mockNatsClient) Publish(_ context.Context, opts *nats.PublishOptions) error {
 	if m.publishErr != nil {
 		return m.publishErr
@@ -439,3 +1750,234 @@ func (m *mockNatsClient) Publish(_ context.Context, opts *nats.PublishOptions) e
 	m.publishOpts = append(m.publishOpts, *opts)
 	return nil
 }
+
+func (m *mockNatsClient) PublishAsync(_ context.Context, opts *nats.PublishOptions, acked func(err error)) error {
+	if m.publishErr != nil {
+		acked(m.publishErr)
+		return nil
+	}
+	m.publishOpts = append(m.publishOpts, *opts)
+	acked(nil)
+	return nil
+}
+
+func (m *mockNatsClient) LastMsgId(_ context.Context, _ string) (string, error) {
+	return "", nil
+}
+
+func (m *mockNatsClient) KeyValue(_ context.Context, opts *nats.KeyValueOptions) (nats.KeyValue, error) {
+	if m.keyValueErr != nil {
+		return nil, m.keyValueErr
+	}
+	m.keyValueOpts = append(m.keyValueOpts, *opts)
+	if m.keyValue != nil {
+		return m.keyValue, nil
+	}
+	return newMockKeyValue(), nil
+}
+
+type mockKeyValue struct {
+	values    map[string][]byte
+	revisions map[string]uint64
+	revision  uint64
+	updateErr error
+	mu        sync.Mutex
+}
+
+func newMockKeyValue() *mockKeyValue {
+	return &mockKeyValue{values: map[string][]byte{}, revisions: map[string]uint64{}}
+}
+
+func (m *mockKeyValue) Get(_ context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.values[key], nil
+}
+
+func (m *mockKeyValue) Put(_ context.Context, key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.put(key, value)
+	return nil
+}
+
+func (m *mockKeyValue) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.values, key)
+	delete(m.revisions, key)
+	return nil
+}
+
+func (m *mockKeyValue) Create(_ context.Context, key string, value []byte) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, found := m.values[key]; found {
+		return 0, nats.ErrKeyValueConflict
+	}
+	return m.put(key, value), nil
+}
+
+func (m *mockKeyValue) Update(_ context.Context, key string, value []byte, revision uint64) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.updateErr != nil {
+		return 0, m.updateErr
+	}
+	if m.revisions[key] != revision {
+		return 0, nats.ErrKeyValueConflict
+	}
+	return m.put(key, value), nil
+}
+
+func (m *mockKeyValue) DeleteRevision(_ context.Context, key string, revision uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.revisions[key] != revision {
+		return nats.ErrKeyValueConflict
+	}
+	delete(m.values, key)
+	delete(m.revisions, key)
+	return nil
+}
+
+func (m *mockKeyValue) Keys(_ context.Context, filter string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var keys []string
+	for key := range m.values {
+		if strings.HasPrefix(key, strings.TrimSuffix(filter, ">")) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
+
+func (m *mockKeyValue) put(key string, value []byte) uint64 {
+	m.revision++
+	m.values[key] = value
+	m.revisions[key] = m.revision
+	return m.revision
+}
+
+func TestLease(t *testing.T) {
+	t.Run("should renew the lease until it is released", func(t *testing.T) {
+		kv := newMockKeyValue()
+		l := &lease{kv: kv, key: "coll1", owner: "instance1", ttl: 30 * time.Millisecond, logger: slog.Default()}
+
+		leaderCtx, release, err := l.acquire(context.Background())
+
+		require.NoError(t, err)
+		kv.mu.Lock()
+		acquired := kv.revisions["coll1"]
+		kv.mu.Unlock()
+		require.Eventually(t, func() bool {
+			kv.mu.Lock()
+			defer kv.mu.Unlock()
+			return kv.revisions["coll1"] > acquired
+		}, 1*time.Second, 10*time.Millisecond)
+		require.NoError(t, leaderCtx.Err())
+		release()
+		value, _ := kv.Get(context.Background(), "coll1")
+		require.Nil(t, value)
+	})
+	t.Run("should cancel the context once the lease has been taken over", func(t *testing.T) {
+		kv := newMockKeyValue()
+		l := &lease{kv: kv, key: "coll1", owner: "instance1", ttl: 30 * time.Millisecond, logger: slog.Default()}
+
+		leaderCtx, release, err := l.acquire(context.Background())
+		require.NoError(t, err)
+		defer release()
+		_ = kv.Put(context.Background(), "coll1", []byte("instance2"))
+
+		require.Eventually(t, func() bool {
+			return errors.Is(context.Cause(leaderCtx), errLeadershipLost)
+		}, 1*time.Second, 10*time.Millisecond)
+	})
+	t.Run("should cancel the context before the lease expires if it cannot be renewed", func(t *testing.T) {
+		kv := newMockKeyValue()
+		kv.updateErr = errors.New("nats unavailable")
+		ttl := 600 * time.Millisecond
+		l := &lease{kv: kv, key: "coll1", owner: "instance1", ttl: ttl, logger: slog.Default()}
+		start := time.Now()
+
+		leaderCtx, release, err := l.acquire(context.Background())
+		require.NoError(t, err)
+		defer release()
+
+		require.Eventually(t, func() bool {
+			return errors.Is(context.Cause(leaderCtx), errLeadershipLost)
+		}, 1*time.Second, 10*time.Millisecond)
+		// the lease is given up one renew interval before it expires, so that no standby can acquire it meanwhile
+		require.Less(t, time.Since(start), ttl)
+	})
+	t.Run("should stand by until the context is done", func(t *testing.T) {
+		kv := newMockKeyValue()
+		_, _ = kv.Create(context.Background(), "coll1", []byte("instance2"))
+		l := &lease{kv: kv, key: "coll1", owner: "instance1", ttl: 30 * time.Millisecond, logger: slog.Default()}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		leaderCtx, release, err := l.acquire(ctx)
+
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		require.Nil(t, leaderCtx)
+		require.Nil(t, release)
+	})
+}
+
+func TestMembership(t *testing.T) {
+	t.Run("should assign each watcher to a single instance", func(t *testing.T) {
+		kv := newMockKeyValue()
+		m1 := newMembership(kv, "instance1", time.Second, slog.Default())
+		m2 := newMembership(kv, "instance2", time.Second, slog.Default())
+		require.NoError(t, m1.refresh(context.Background()))
+		require.NoError(t, m2.refresh(context.Background()))
+		_, changed := m1.assigned("connector-db.coll1")
+		require.NoError(t, m1.refresh(context.Background()))
+
+		require.Equal(t, []string{"instance1", "instance2"}, m1.members)
+		require.Equal(t, []string{"instance1", "instance2"}, m2.members)
+		require.Eventually(t, func() bool {
+			select {
+			case <-changed:
+				return true
+			default:
+				return false
+			}
+		}, 1*time.Second, 10*time.Millisecond)
+		assignedTo := map[string]int{}
+		for i := 1; i <= 8; i++ {
+			key := fmt.Sprintf("connector-db.coll%d", i)
+			assigned1, _ := m1.assigned(key)
+			assigned2, _ := m2.assigned(key)
+			require.NotEqual(t, assigned1, assigned2)
+			if assigned1 {
+				assignedTo["instance1"]++
+			} else {
+				assignedTo["instance2"]++
+			}
+		}
+		require.Equal(t, map[string]int{"instance1": 5, "instance2": 3}, assignedTo)
+	})
+	t.Run("should leave once the context is done", func(t *testing.T) {
+		kv := newMockKeyValue()
+		m := newMembership(kv, "instance1", 30*time.Millisecond, slog.Default())
+		ctx, cancel := context.WithCancel(context.Background())
+
+		done := make(chan error)
+		go func() {
+			done <- m.run(ctx)
+		}()
+		require.Eventually(t, func() bool {
+			keys, _ := kv.Keys(context.Background(), "members.>")
+			return slices.Equal([]string{"members.instance1"}, keys)
+		}, 1*time.Second, 10*time.Millisecond)
+		cancel()
+
+		require.NoError(t, <-done)
+		keys, _ := kv.Keys(context.Background(), "members.>")
+		require.Empty(t, keys)
+	})
+}
@@ -0,0 +1,145 @@
+package connector
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/damianiandrea/mongodb-nats-connector/internal/nats"
+)
+
+const (
+	membersKeyPrefix  = "members."
+	watchersKeyPrefix = "watchers."
+)
+
+var errReassigned = errors.New("watcher reassigned to another instance")
+
+// membership keeps track of the Connector instances sharing the leases bucket, so that the watchers can be divided
+// between them: each instance announces itself by renewing its own key, which expires after the bucket TTL if the
+// instance stops renewing it.
+type membership struct {
+	kv         nats.KeyValue
+	instanceId string
+	ttl        time.Duration
+	logger     *slog.Logger
+
+	mu      sync.Mutex
+	members []string
+	changed chan struct{}
+}
+
+func newMembership(kv nats.KeyValue, instanceId string, ttl time.Duration, logger *slog.Logger) *membership {
+	return &membership{
+		kv:         kv,
+		instanceId: instanceId,
+		ttl:        ttl,
+		logger:     logger,
+		members:    []string{instanceId},
+		changed:    make(chan struct{}),
+	}
+}
+
+// run announces the instance and refreshes the members until the context is done, after which the instance leaves,
+// so that its watchers are reassigned right away.
+func (m *membership) run(ctx context.Context) error {
+	ticker := time.NewTicker(m.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := m.kv.Delete(context.Background(), membersKeyPrefix+m.instanceId); err != nil {
+				m.logger.Warn("could not leave connector instances", "err", err)
+			}
+			return nil
+		case <-ticker.C:
+		}
+		if err := m.refresh(ctx); err != nil {
+			m.logger.Error("could not refresh connector instances", "err", err)
+		}
+	}
+}
+
+// refresh renews the key of the instance, then reads the keys of the other instances.
+func (m *membership) refresh(ctx context.Context) error {
+	if err := m.kv.Put(ctx, membersKeyPrefix+m.instanceId, []byte(time.Now().UTC().Format(time.RFC3339))); err != nil {
+		return err
+	}
+	keys, err := m.kv.Keys(ctx, membersKeyPrefix+">")
+	if err != nil {
+		return err
+	}
+	members := []string{m.instanceId}
+	for _, key := range keys {
+		if member := strings.TrimPrefix(key, membersKeyPrefix); member != m.instanceId {
+			members = append(members, member)
+		}
+	}
+	slices.Sort(members)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !slices.Equal(members, m.members) {
+		m.logger.Info("connector instances changed", "instances", members)
+		m.members = members
+		close(m.changed)
+		m.changed = make(chan struct{})
+	}
+	return nil
+}
+
+// assigned tells whether the watcher with the given lease key is assigned to this instance, and returns a channel
+// that is closed as soon as the members change.
+// The watchers are assigned by rendezvous hashing, so that only the watchers of the instances that join or leave are
+// moved.
+func (m *membership) assigned(leaseKey string) (bool, <-chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var owner string
+	var maxScore uint64
+	for _, member := range m.members {
+		sum := sha256.Sum256([]byte(member + "/" + leaseKey))
+		if score := binary.BigEndian.Uint64(sum[:8]); owner == "" || score > maxScore {
+			owner, maxScore = member, score
+		}
+	}
+	return owner == m.instanceId, m.changed
+}
+
+// waitAssigned blocks until the watcher with the given lease key is assigned to this instance, or the context is done.
+func (m *membership) waitAssigned(ctx context.Context, leaseKey string) error {
+	for {
+		assigned, changed := m.assigned(leaseKey)
+		if assigned {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// watchAssigned cancels the given context with errReassigned as its cause as soon as the watcher with the given lease
+// key is no longer assigned to this instance.
+func (m *membership) watchAssigned(ctx context.Context, cancel context.CancelCauseFunc, leaseKey string) {
+	for {
+		assigned, changed := m.assigned(leaseKey)
+		if !assigned {
+			cancel(errReassigned)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-changed:
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/damianiandrea/mongodb-nats-connector/internal/nats"
+)
+
+var errLeadershipLost = errors.New("leadership lost: the lease could not be renewed")
+
+// lease elects a single leader for a watcher among the Connector instances sharing the same NATS KV bucket: it is
+// held by the instance that created its key, which renews it before the bucket TTL expires it. The other instances
+// stand by, trying to create the key until the leader releases the lease or stops renewing it.
+type lease struct {
+	kv     nats.KeyValue
+	key    string
+	owner  string
+	ttl    time.Duration
+	logger *slog.Logger
+
+	revision uint64
+	// renewedAt is when the last successful write of the lease was sent, from which its expiration is measured.
+	renewedAt time.Time
+}
+
+// acquire blocks until the lease is acquired, or the context is done. The returned context is cancelled with
+// errLeadershipLost as its cause if the lease is lost, and the returned function stops renewing and releases the
+// lease.
+func (l *lease) acquire(ctx context.Context) (context.Context, func(), error) {
+	for {
+		sentAt := time.Now()
+		revision, err := l.kv.Create(ctx, l.key, []byte(l.owner))
+		if err == nil {
+			l.revision = revision
+			l.renewedAt = sentAt
+			break
+		}
+		if !errors.Is(err, nats.ErrKeyValueConflict) {
+			l.logger.Error("could not acquire lease", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(l.renewInterval()):
+		}
+	}
+	l.logger.Info("acquired lease", "owner", l.owner)
+
+	leaderCtx, cancel := context.WithCancelCause(ctx)
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		l.renew(leaderCtx, cancel)
+	}()
+	release := func() {
+		cancel(nil)
+		<-renewed
+		// the lease is deleted only if it has not been taken over in the meantime, so that the standby instances can
+		// acquire it right away
+		if err := l.kv.DeleteRevision(context.Background(), l.key, l.revision); err != nil {
+			l.logger.Warn("could not release lease", "err", err)
+		}
+		l.logger.Info("released lease", "owner", l.owner)
+	}
+	return leaderCtx, release, nil
+}
+
+// renew renews the lease until the context is done. If the lease has been taken over, or it could not be renewed
+// and it may expire before the next attempt, the context is cancelled, so that the leader stops before a standby
+// instance can acquire the lease.
+func (l *lease) renew(ctx context.Context, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(l.renewInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		sentAt := time.Now()
+		revision, err := l.kv.Update(ctx, l.key, []byte(l.owner), l.revision)
+		if err == nil {
+			l.revision = revision
+			l.renewedAt = sentAt
+			continue
+		}
+		l.logger.Error("could not renew lease", "err", err)
+		if errors.Is(err, nats.ErrKeyValueConflict) || time.Since(l.renewedAt) >= l.ttl-l.renewInterval() {
+			cancel(errLeadershipLost)
+			return
+		}
+	}
+}
+
+// renewInterval returns how often the lease is renewed by the leader, and how often the standby instances try to
+// acquire it.
+func (l *lease) renewInterval() time.Duration {
+	return l.ttl / 3
+}
@@ -0,0 +1,195 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/damianiandrea/mongodb-nats-connector/internal/mongo"
+	"github.com/damianiandrea/mongodb-nats-connector/internal/server"
+)
+
+var _ server.NamedMonitor = &watcher{}
+
+// watcher runs the change stream of a configured collection, database or deployment with its own lifecycle: when it
+// fails, it is restarted according to the restart policy of the collection, without affecting the other watchers.
+// Its status is reported by the health check.
+type watcher struct {
+	name     string
+	leaseKey string
+	coll     *collection
+	logger   *slog.Logger
+
+	mu  sync.Mutex
+	err error
+	// storedTokenReplaced tells whether a checkpoint has been stored in place of the resume tokens ignored because of
+	// the start position, in which case they are no longer ignored when the watcher is restarted or takes over again.
+	storedTokenReplaced bool
+}
+
+func newWatcher(coll *collection, logger *slog.Logger) *watcher {
+	var watched string
+	switch coll.scope {
+	case collectionScope:
+		watched = fmt.Sprintf("%s.%s", coll.dbName, coll.collName)
+	case databaseScope:
+		watched = coll.dbName
+	default:
+		watched = "deployment"
+	}
+	name := "watcher:" + watched
+	return &watcher{
+		name:     name,
+		leaseKey: invalidCheckpointKeyChars.ReplaceAllString(watched, "_"),
+		coll:     coll,
+		logger:   logger.With("watcher", name),
+	}
+}
+
+func (w *watcher) Name() string {
+	return w.name
+}
+
+// Monitor returns the error of the last failure of the watcher, until it has been restarted, or server.ErrStandby
+// while another instance holds its lease.
+func (w *watcher) Monitor(_ context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+func (w *watcher) setErr(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.err = err
+}
+
+// startPosition returns the start position of the watcher, which ignores the stored resume tokens, if configured to,
+// only until a checkpoint has been stored in their place.
+func (w *watcher) startPosition() mongo.StartPosition {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	startPosition := w.coll.startPosition
+	if w.storedTokenReplaced {
+		startPosition.IgnoreStoredToken = false
+	}
+	return startPosition
+}
+
+func (w *watcher) setStoredTokenReplaced() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.storedTokenReplaced = true
+}
+
+// supervise runs the given watcher until the context is done, restarting it whenever it fails, unless the error is
+// permanent. The returned error is not nil only if the Connector must fail fast, in which case all the other watchers
+// are stopped as well.
+func (c *Connector) supervise(ctx context.Context, w *watcher, tokensNamespaces []mongo.Namespace) error {
+	for restarts := 0; ; restarts++ {
+		err := c.runWatcher(ctx, w, tokensNamespaces) // blocking call
+		if err == nil || ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, errLeadershipLost) || errors.Is(err, errReassigned) {
+			// another instance has taken over, this one stands by until the lease is available again
+			w.logger.Warn("watcher stopped, standing by", "reason", err)
+			restarts--
+			continue
+		}
+		w.setErr(err)
+		if c.options.failFast {
+			return err
+		}
+		if errors.Is(err, mongo.ErrPermanent) {
+			// restarting would fail the same way, the watcher is reported as down by the health check
+			w.logger.Error("watcher failed permanently, giving up", "err", err)
+			return nil
+		}
+		if w.coll.maxRestarts >= 0 && restarts >= w.coll.maxRestarts {
+			// the other watchers keep running, this one is reported as down by the health check
+			w.logger.Error("watcher failed, giving up", "restarts", restarts, "err", err)
+			return nil
+		}
+
+		w.logger.Error("watcher failed, restarting", "delay", w.coll.restartDelay, "err", err)
+		timer := time.NewTimer(w.coll.restartDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+// runWatcher runs the given watcher. If leader election is enabled, it first waits for the lease of the watcher, and
+// stops watching as soon as the lease is lost. If the watchers are distributed, it also waits for the watcher to be
+// assigned to this instance, and stops watching as soon as it is reassigned to another one.
+func (c *Connector) runWatcher(ctx context.Context, w *watcher, tokensNamespaces []mongo.Namespace) error {
+	if c.leases != nil {
+		w.setErr(server.ErrStandby)
+		if c.members != nil {
+			if err := c.members.waitAssigned(ctx, w.leaseKey); err != nil {
+				return err
+			}
+		}
+		l := &lease{
+			kv:     c.leases,
+			key:    watchersKeyPrefix + w.leaseKey,
+			owner:  c.options.instanceId,
+			ttl:    c.options.leaseTtl,
+			logger: w.logger,
+		}
+		leaderCtx, release, err := l.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		defer release()
+		ctx = leaderCtx
+		if c.members != nil {
+			assignedCtx, cancel := context.WithCancelCause(leaderCtx)
+			defer cancel(nil)
+			go c.members.watchAssigned(assignedCtx, cancel, w.leaseKey)
+			ctx = assignedCtx
+		}
+	}
+
+	err := c.startWatcher(ctx, w, tokensNamespaces)
+	if cause := context.Cause(ctx); errors.Is(cause, errLeadershipLost) || errors.Is(cause, errReassigned) {
+		return cause
+	}
+	return err
+}
+
+// startWatcher creates what the watcher needs on MongoDB and NATS, then watches its change stream.
+func (c *Connector) startWatcher(ctx context.Context, w *watcher, tokensNamespaces []mongo.Namespace) error {
+	coll := w.coll
+	if coll.scope == collectionScope {
+		createWatchedCollOpts := &mongo.CreateCollectionOptions{
+			DbName:                       coll.dbName,
+			CollName:                     coll.collName,
+			ChangeStreamPreAndPostImages: coll.changeStreamPreAndPostImages,
+		}
+		if err := c.options.mongoClient.CreateCollection(ctx, createWatchedCollOpts); err != nil {
+			return err
+		}
+	}
+
+	checkpointStore, err := c.createCheckpointStore(ctx, coll)
+	if err != nil {
+		return err
+	}
+
+	if coll.scope == collectionScope {
+		if err := c.addStream(ctx, coll, coll.streamName); err != nil {
+			return err
+		}
+	}
+
+	w.setErr(nil)
+	return c.watch(ctx, w, checkpointStore, tokensNamespaces)
+}
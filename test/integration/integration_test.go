@@ -396,6 +396,14 @@ type changeEvent struct {
 	OperationType            string        `json:"operationType"`
 	FullDocument             fullDocument  `json:"fullDocument"`
 	FullDocumentBeforeChange fullDocument  `json:"fullDocumentBeforeChange"`
+	Ns                       namespace     `json:"ns"`
+	Reason                   string        `json:"reason"`
+	OnHistoryLost            string        `json:"onHistoryLost"`
+}
+
+type namespace struct {
+	Db   string `json:"db"`
+	Coll string `json:"coll"`
 }
 
 type changeEventId struct {
@@ -0,0 +1,311 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/damianiandrea/mongodb-nats-connector/pkg/connector"
+)
+
+// the tests below run their own connector in process, so that it can be stopped and restarted, and it can be
+// configured without affecting the connector shared by the other tests.
+const (
+	watchTestDbName       = "test-connector-watch"
+	watchTestTokensDbName = "resume-tokens-watch"
+)
+
+// historyLostOperationTime is earlier than the first entry of the oplog, so that the change stream cannot be started.
+const historyLostOperationTime = "1970-01-01T00:00:01Z"
+
+func TestChangeStreamIsRestartedAfterInvalidate(t *testing.T) {
+	coll, _ := setUpWatchTest(t, "dropped", "DROPPED")
+	stop := startConnector(t, connector.WithCollection(watchTestDbName, "dropped",
+		connector.WithTokensDbName(watchTestTokensDbName),
+		connector.WithStreamName("DROPPED"),
+	))
+	defer stop()
+	sub := subscribe(t, "DROPPED")
+	requireWatching(t, coll, sub)
+
+	require.NoError(t, coll.Drop(context.Background()))
+	requireEvent(t, sub, "drop", "")
+	requireEvent(t, sub, "invalidate", "")
+
+	// the change stream is started after the invalidate event, the collection is recreated by the insert
+	requireWatching(t, coll, sub)
+	_, err := coll.InsertOne(context.Background(), bson.D{{Key: "message", Value: "recreated"}})
+	require.NoError(t, err)
+	requireEvent(t, sub, "insert", "recreated")
+}
+
+func TestRenamedCollectionIsFollowed(t *testing.T) {
+	coll, _ := setUpWatchTest(t, "renamed", "RENAMED")
+	renamedColl := mongoClient.Database(watchTestDbName).Collection("renamed-to")
+	require.NoError(t, renamedColl.Drop(context.Background()))
+	t.Cleanup(func() {
+		require.NoError(t, renamedColl.Drop(context.Background()))
+	})
+	stop := startConnector(t, connector.WithCollection(watchTestDbName, "renamed",
+		connector.WithTokensDbName(watchTestTokensDbName),
+		connector.WithStreamName("RENAMED"),
+		connector.WithFollowRenames(),
+	))
+	defer stop()
+	sub := subscribe(t, "RENAMED")
+	requireWatching(t, coll, sub)
+
+	rename := bson.D{
+		{Key: "renameCollection", Value: watchTestDbName + ".renamed"},
+		{Key: "to", Value: watchTestDbName + ".renamed-to"},
+	}
+	require.NoError(t, mongoClient.Database("admin").RunCommand(context.Background(), rename).Err())
+	requireEvent(t, sub, "rename", "")
+
+	// the change events of the renamed collection are published on the same stream
+	_, err := renamedColl.InsertOne(context.Background(), bson.D{{Key: "message", Value: "renamed"}})
+	require.NoError(t, err)
+	event := requireEvent(t, sub, "insert", "renamed")
+	require.Equal(t, "renamed-to", event.Ns.Coll)
+}
+
+func TestResumeTokenIsStoredOnShutdown(t *testing.T) {
+	coll, tokensColl := setUpWatchTest(t, "checkpointed", "CHECKPOINTED")
+	stop := startConnector(t, connector.WithCollection(watchTestDbName, "checkpointed",
+		connector.WithTokensDbName(watchTestTokensDbName),
+		connector.WithStreamName("CHECKPOINTED"),
+		connector.WithCheckpointEvents(1000),
+		connector.WithCheckpointInterval(time.Hour),
+	))
+	sub := subscribe(t, "CHECKPOINTED")
+	requireWatching(t, coll, sub)
+
+	_, err := coll.InsertOne(context.Background(), bson.D{{Key: "message", Value: "hi"}})
+	require.NoError(t, err)
+	event := requireEvent(t, sub, "insert", "hi")
+	require.False(t, lastResumeTokenIsUpdated(tokensColl, event))
+
+	// the resume token of the last published change event is stored before the connector stops
+	stop()
+	require.True(t, lastResumeTokenIsUpdated(tokensColl, event))
+}
+
+func TestGapIsPublishedAndConnectorFailsWhenHistoryIsLost(t *testing.T) {
+	setUpWatchTest(t, "lost-fail", "LOST_FAIL")
+	conn, err := connector.New(
+		connector.WithMongoUri(mongoUri),
+		connector.WithNatsUrl(natsUrl),
+		connector.WithServerAddr("127.0.0.1:0"),
+		connector.WithFailFast(),
+		connector.WithCollection(watchTestDbName, "lost-fail",
+			connector.WithTokensDbName(watchTestTokensDbName),
+			connector.WithStreamName("LOST_FAIL"),
+			connector.WithStartAtOperationTime(historyLostOperationTime),
+			connector.WithOnHistoryLost("fail"),
+		),
+	)
+	require.NoError(t, err)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- conn.Run()
+	}()
+
+	select {
+	case err = <-errCh:
+		require.Error(t, err)
+	case <-time.After(30 * time.Second):
+		t.Fatal("connector did not fail")
+	}
+	sub := subscribe(t, "LOST_FAIL")
+	event := requireEvent(t, sub, "gap", "")
+	require.Equal(t, "historyLost", event.Reason)
+	require.Equal(t, "fail", event.OnHistoryLost)
+}
+
+func TestGapIsPublishedAndChangeStreamIsResumedFromNowWhenHistoryIsLost(t *testing.T) {
+	coll, _ := setUpWatchTest(t, "lost-resume", "LOST_RESUME")
+	stop := startConnector(t, connector.WithCollection(watchTestDbName, "lost-resume",
+		connector.WithTokensDbName(watchTestTokensDbName),
+		connector.WithStreamName("LOST_RESUME"),
+		connector.WithStartAtOperationTime(historyLostOperationTime),
+		connector.WithOnHistoryLost("resumeFromNow"),
+	))
+	defer stop()
+	sub := subscribe(t, "LOST_RESUME")
+
+	event := requireEvent(t, sub, "gap", "")
+	require.Equal(t, "historyLost", event.Reason)
+	require.Equal(t, "resumeFromNow", event.OnHistoryLost)
+	requireWatching(t, coll, sub)
+}
+
+func TestGapIsPublishedAndCollectionIsSnapshottedWhenHistoryIsLost(t *testing.T) {
+	coll, _ := setUpWatchTest(t, "lost-snapshot", "LOST_SNAPSHOT")
+	_, err := coll.InsertOne(context.Background(), bson.D{{Key: "message", Value: "existing"}})
+	require.NoError(t, err)
+	stop := startConnector(t, connector.WithCollection(watchTestDbName, "lost-snapshot",
+		connector.WithTokensDbName(watchTestTokensDbName),
+		connector.WithStreamName("LOST_SNAPSHOT"),
+		connector.WithStartAtOperationTime(historyLostOperationTime),
+		connector.WithOnHistoryLost("resnapshot"),
+	))
+	defer stop()
+	sub := subscribe(t, "LOST_SNAPSHOT")
+
+	event := requireEvent(t, sub, "gap", "")
+	require.Equal(t, "historyLost", event.Reason)
+	require.Equal(t, "resnapshot", event.OnHistoryLost)
+	requireEvent(t, sub, "snapshot", "existing")
+	requireWatching(t, coll, sub)
+}
+
+func TestSnapshotIsContinuedAfterTheLastPublishedDocument(t *testing.T) {
+	coll, tokensColl := setUpWatchTest(t, "snapshotted", "SNAPSHOTTED")
+	// the ids are of different types, which are sorted by type before value
+	docs := []any{
+		bson.D{{Key: "_id", Value: int32(1)}, {Key: "message", Value: "one"}},
+		bson.D{{Key: "_id", Value: int32(2)}, {Key: "message", Value: "two"}},
+		bson.D{{Key: "_id", Value: "a"}, {Key: "message", Value: "a"}},
+		bson.D{{Key: "_id", Value: "b"}, {Key: "message", Value: "b"}},
+		bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "message", Value: "oid"}},
+	}
+	_, err := coll.InsertMany(context.Background(), docs)
+	require.NoError(t, err)
+
+	// the snapshot was stopped after publishing the second document
+	progress := bson.D{
+		{Key: "value", Value: ""},
+		{Key: "snapshot", Value: bson.D{
+			{Key: "clusterTime", Value: primitive.Timestamp{T: uint32(time.Now().Unix())}},
+			{Key: "lastId", Value: int32(2)},
+			{Key: "done", Value: false},
+		}},
+	}
+	_, err = tokensColl.InsertOne(context.Background(), progress)
+	require.NoError(t, err)
+
+	stop := startConnector(t, connector.WithCollection(watchTestDbName, "snapshotted",
+		connector.WithTokensDbName(watchTestTokensDbName),
+		connector.WithStreamName("SNAPSHOTTED"),
+		connector.WithSnapshot(),
+	))
+	defer stop()
+	sub := subscribe(t, "SNAPSHOTTED")
+
+	for _, message := range []string{"a", "b", "oid"} {
+		event := nextEvent(t, sub)
+		require.Equal(t, "snapshot", event.OperationType)
+		require.Equal(t, message, event.FullDocument.Message)
+	}
+	requireWatching(t, coll, sub)
+}
+
+// setUpWatchTest drops the given collection and its resume tokens collection, and deletes the given stream, both
+// before and after the test.
+func setUpWatchTest(t *testing.T, collName, streamName string) (*mongo.Collection, *mongo.Collection) {
+	coll := mongoClient.Database(watchTestDbName).Collection(collName)
+	tokensColl := mongoClient.Database(watchTestTokensDbName).Collection(collName)
+	tearDown := func() {
+		require.NoError(t, coll.Drop(context.Background()))
+		require.NoError(t, tokensColl.Drop(context.Background()))
+		if err := natsJs.DeleteStream(streamName); err != nil {
+			require.ErrorIs(t, err, nats.ErrStreamNotFound)
+		}
+	}
+	tearDown()
+	t.Cleanup(tearDown)
+	return coll, tokensColl
+}
+
+// startConnector runs a connector with the given options until the returned function is called, or the test ends.
+func startConnector(t *testing.T, opts ...connector.Option) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	conn, err := connector.New(append([]connector.Option{
+		connector.WithMongoUri(mongoUri),
+		connector.WithNatsUrl(natsUrl),
+		connector.WithContext(ctx),
+		connector.WithServerAddr("127.0.0.1:0"),
+	}, opts...)...)
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = conn.Run()
+	}()
+	stop := func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+// subscribe waits for the given stream to be created by the connector, then subscribes to all of its messages.
+func subscribe(t *testing.T, streamName string) *nats.Subscription {
+	require.Eventually(t, func() bool {
+		_, err := natsJs.StreamInfo(streamName)
+		return err == nil
+	}, 10*time.Second, 100*time.Millisecond)
+	sub, err := natsJs.SubscribeSync(fmt.Sprintf("%s.*", streamName), nats.DeliverAll())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = sub.Unsubscribe()
+	})
+	return sub
+}
+
+// requireWatching inserts documents into the given collection until one of them is published, since the change stream
+// may not have been started yet.
+func requireWatching(t *testing.T, coll *mongo.Collection, sub *nats.Subscription) {
+	require.Eventually(t, func() bool {
+		if _, err := coll.InsertOne(context.Background(), bson.D{{Key: "message", Value: "ping"}}); err != nil {
+			return false
+		}
+		for {
+			msg, err := sub.NextMsg(time.Second)
+			if err != nil {
+				return false
+			}
+			event := &changeEvent{}
+			if err = json.Unmarshal(msg.Data, event); err != nil {
+				return false
+			}
+			if event.OperationType == "insert" && event.FullDocument.Message == "ping" {
+				return true
+			}
+		}
+	}, 20*time.Second, 100*time.Millisecond)
+}
+
+// requireEvent skips the received messages until a change event with the given operation type, and the given message
+// if not empty, is received.
+func requireEvent(t *testing.T, sub *nats.Subscription, operationType, message string) *changeEvent {
+	for {
+		event := nextEvent(t, sub)
+		if event.OperationType == operationType && (message == "" || event.FullDocument.Message == message) {
+			return event
+		}
+	}
+}
+
+// nextEvent returns the next change event received by the given subscription.
+func nextEvent(t *testing.T, sub *nats.Subscription) *changeEvent {
+	msg, err := sub.NextMsg(10 * time.Second)
+	require.NoError(t, err)
+	event := &changeEvent{}
+	require.NoError(t, json.Unmarshal(msg.Data, event))
+	require.NotEmpty(t, event.Id.Data)
+	require.Equal(t, event.Id.Data, msg.Header.Get(nats.MsgIdHdr))
+	return event
+}